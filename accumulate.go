@@ -0,0 +1,144 @@
+package gocov
+
+// Accumulator merges a stream of coverage data sets into a running
+// total, for long-running tools that collect coverage from many runs.
+// Unlike CoverageData.Merge, it copies what it keeps out of the data
+// sets it is given and merges counters through scratch buffers that
+// are reused from one Add to the next, so a steady stream of data from
+// the same binaries stops allocating once every function has been
+// seen.
+type Accumulator struct {
+	m     merger
+	data  *CoverageData
+	alloc batchCounterAlloc
+
+	// scratch state reused across functions and calls to Add
+	dst, src []uint32
+	targets  []*FuncUnit
+}
+
+// NewAccumulator returns an empty accumulator that merges counters
+// according to 'mode' and records 'gran' as the granularity of every
+// pod. Data of other modes is merged as if it had been collected in
+// 'mode'.
+func NewAccumulator(mode counterMode, gran CounterGranularity) *Accumulator {
+	a := &Accumulator{
+		data: &CoverageData{
+			PodData: make(map[string]*PodData),
+		},
+	}
+	a.m.SetModeAndGranularity(mode, gran)
+	return a
+}
+
+// Add merges 'd' into the accumulated data. 'd' is not retained and may
+// be reused by the caller once Add returns.
+func (a *Accumulator) Add(d *CoverageData) {
+	if d.overflowed {
+		a.data.overflowed = true
+	}
+	a.data.overflows = append(a.data.overflows, d.overflows...)
+	a.data.stats.FilesRead += d.stats.FilesRead
+	a.data.stats.BytesRead += d.stats.BytesRead
+	for pName, p := range d.PodData {
+		curPod, ok := a.data.PodData[pName]
+		if !ok {
+			curPod = &PodData{
+				CounterGranularity: a.m.Granularity(),
+				CounterMode:        a.m.Mode(),
+				Packages:           make(map[uint32]*Package),
+				Provenance:         p.Provenance,
+			}
+			a.data.PodData[pName] = curPod
+		}
+		for packName, pack := range p.Packages {
+			curPack, ok := curPod.Packages[packName]
+			if !ok {
+				curPack = &Package{
+					ID:         pack.ID,
+					Name:       pack.Name,
+					ImportPath: pack.ImportPath,
+					ModulePath: pack.ModulePath,
+					NumFuncs:   pack.NumFuncs,
+					MetaHash:   pack.MetaHash,
+					Funcs:      make(map[uint32]*Func),
+				}
+				curPod.Packages[packName] = curPack
+			}
+			for fName, f := range pack.Funcs {
+				curFunc, ok := curPack.Funcs[fName]
+				if !ok {
+					curFunc = &Func{
+						Name:         f.Name,
+						SrcFile:      f.SrcFile,
+						Lit:          f.Lit,
+						Instrumented: f.Instrumented,
+						SrcHash:      f.SrcHash,
+					}
+					curPack.Funcs[fName] = curFunc
+				}
+				a.addFunc(pack.ImportPath, curFunc, f)
+			}
+		}
+	}
+}
+
+// addFunc merges the units of 'f' into those of 'curFunc'.
+func (a *Accumulator) addFunc(importPath string, curFunc, f *Func) {
+	a.targets = a.targets[:0]
+	if sameUnits(curFunc.Units, f.Units) {
+		a.targets = append(a.targets, curFunc.Units...)
+	} else {
+		units := make(map[funit]*FuncUnit, len(curFunc.Units))
+		for _, u := range curFunc.Units {
+			units[funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}] = u
+		}
+		for _, u := range f.Units {
+			cu, ok := units[funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}]
+			if !ok {
+				cu = &FuncUnit{
+					StLine:  u.StLine,
+					StCol:   u.StCol,
+					EnLine:  u.EnLine,
+					EnCol:   u.EnCol,
+					NxStmts: u.NxStmts,
+				}
+				curFunc.Units = append(curFunc.Units, cu)
+			}
+			a.targets = append(a.targets, cu)
+		}
+	}
+
+	n := len(a.targets)
+	if cap(a.dst) < n {
+		// The allocator hands out slices of a shared pool, so cap
+		// them to keep 'dst' from growing into 'src'.
+		buf := a.alloc.AllocateCounters(2 * n)
+		a.dst, a.src = buf[:n:n], buf[n:2*n:2*n]
+	}
+	dst, src := a.dst[:n], a.src[:n]
+	for i, cu := range a.targets {
+		dst[i] = cu.Count
+		src[i] = f.Units[i].Count
+	}
+	if _, ovf := a.m.MergeCounters(dst, src); ovf {
+		a.data.overflowed = true
+		a.data.addOverflows(importPath, f.Name, dst)
+	}
+	for i, cu := range a.targets {
+		cu.Count = dst[i]
+		cu.RunsCovered += f.Units[i].RunsCovered
+	}
+}
+
+// Snapshot returns a copy of the data accumulated so far, which stays
+// valid as further data is added. As with the other copies of coverage
+// data, functions without units are left out.
+func (a *Accumulator) Snapshot() *CoverageData {
+	out := a.data.filter(func(*Package, *Func, *FuncUnit) bool {
+		return true
+	})
+	out.overflows = append([]OverflowSite(nil), a.data.overflows...)
+	out.stats = a.data.stats
+	return out
+}
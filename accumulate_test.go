@@ -0,0 +1,53 @@
+package gocov
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAccumulator(t *testing.T) {
+	a := NewAccumulator(CtrModeCount, CtrGranularityPerBlock)
+	a.Add(readTestDir(t, countDir))
+	snap := a.Snapshot()
+	d := readTestDir(t, countDir)
+	a.Add(d)
+	a.Add(d)
+
+	got := a.Snapshot()
+	for _, pack := range readTestDir(t, countDir).PodData[countHash].Packages {
+		for _, fn := range pack.Funcs {
+			want := unitCounts(fn)
+			for i := range want {
+				want[i] *= 3
+			}
+			if counts := unitCounts(findFunc(got, countHash, pack.ImportPath, fn.Name)); !reflect.DeepEqual(counts, want) {
+				t.Errorf("%s: counts %v, want %v", fn.Name, counts, want)
+			}
+		}
+	}
+	// The earlier snapshot and the added data are left alone.
+	samePods(t, snap, readTestDir(t, countDir))
+	samePods(t, d, readTestDir(t, countDir))
+}
+
+func TestAccumulatorSteadyStateAllocs(t *testing.T) {
+	d := benchData(20, 8)
+	a := NewAccumulator(CtrModeCount, CtrGranularityPerBlock)
+	a.Add(d)
+	if n := testing.AllocsPerRun(100, func() { a.Add(d) }); n != 0 {
+		t.Errorf("Add of known functions allocated %v times, want 0", n)
+	}
+}
+
+func BenchmarkAccumulator(b *testing.B) {
+	d := benchData(20, 8)
+	a := NewAccumulator(CtrModeCount, CtrGranularityPerBlock)
+	a.Add(d)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for j := 0; j < 1000; j++ {
+			a.Add(d)
+		}
+	}
+}
@@ -2,8 +2,18 @@ package gocov
 
 import (
 	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"math"
 	"os"
+	"path/filepath"
 	"runtime/coverage"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/tools/cover"
 )
@@ -11,29 +21,97 @@ import (
 type Coverage struct {
 	config CoverageConfig
 	Data   *CoverageData
+	// snapshot holds the cumulative runtime counts last read by
+	// GetCoverage or Advance, so that Advance merges only what changed
+	// since.
+	snapshot map[snapshotKey][]uint32
 }
 
 type CoverageConfig struct {
 	UseDir    string
 	MatchPkgs []string
+	// SlashPaths rewrites the source file names of all functions to
+	// use forward slashes, regardless of the OS that produced them.
+	SlashPaths bool
+	// ExcludeStdlib drops standard library packages, that is packages
+	// whose import path has no dot in its first element.
+	ExcludeStdlib bool
+	// ExcludeSelf drops the packages of this module.
+	ExcludeSelf bool
+	// ExcludeTestFiles drops the functions defined in _test.go files,
+	// such as instrumented test helpers, to measure production code
+	// only.
+	ExcludeTestFiles bool
+	// InternStrings makes identical names (source files, functions,
+	// packages) share memory across packages, which reduces the
+	// footprint of very large meta-data files.
+	InternStrings bool
+	// DuplicateFuncs selects how several payloads for the same function
+	// within a single counter data file are handled.
+	DuplicateFuncs DuplicatePolicy
+	// SourceFS, if set, is used to read source files (for example by
+	// SourceLineCounts) instead of the OS file system. Absolute source
+	// file names are looked up without their leading slash.
+	SourceFS fs.FS
+	// FuncNameRegex, if set, restricts the coverage data to the
+	// functions whose name (such as "Handle" or "*T.ServeHTTP")
+	// matches this regular expression.
+	FuncNameRegex string
+	// StrictCounters makes reading fail if a function's counters don't
+	// match its units in number, rather than warning about it. The
+	// meta-data of every pod is then read in full up front, so that
+	// each counter payload is checked as soon as it is read.
+	StrictCounters bool
+	// RequireMatch makes reading fail with ErrNoPackagesMatched if
+	// MatchPkgs is set but matches no package at all, which usually
+	// means the patterns are wrong.
+	RequireMatch bool
+	// IncludeEmptyFuncs makes function metrics count functions without
+	// any statements as covered, rather than leaving them out.
+	IncludeEmptyFuncs bool
+	// ExcludeGenerated drops the functions of generated source files,
+	// those with a "// Code generated ... DO NOT EDIT." line before the
+	// package clause. Sources are read from SourceFS, or the OS file
+	// system if there is none; files that can't be read are kept.
+	ExcludeGenerated bool
+	// QualifyFiles names bare source files, those without a directory,
+	// by the import path of their package followed by the file name in
+	// GetProfiles, WriteTextProfile, LineCounts and FilePercents, as
+	// Package.QualifiedFile does, so that same-named files of different
+	// packages (say, two main.go) are kept apart.
+	QualifyFiles bool
+	// CounterFileWorkers is the number of counter data files of a pod
+	// decoded concurrently, which speeds up pods with many counter data
+	// files at the cost of holding all of them in memory at once. Zero
+	// or one decodes them one at a time.
+	CounterFileWorkers int
 }
 
 func GetCoverage(c CoverageConfig) (*Coverage, error) {
 	if c.UseDir != "" {
-		if err := coverage.WriteMetaDir(c.UseDir); err != nil {
+		// The meta-data of the program never changes, so it only needs
+		// writing if an earlier call didn't do so already.
+		written, err := metaFileWritten(c.UseDir)
+		if err != nil {
 			return nil, err
 		}
+		if !written {
+			if err := coverage.WriteMetaDir(c.UseDir); err != nil {
+				return nil, err
+			}
+		}
 		if err := coverage.WriteCountersDir(c.UseDir); err != nil {
 			return nil, err
 		}
 
-		data, err := ReadDir(c.UseDir, c.MatchPkgs)
+		data, err := readDir(c.UseDir, c)
 		if err != nil {
 			return nil, err
 		}
 		return &Coverage{
-			config: c,
-			Data:   data,
+			config:   c,
+			Data:     data,
+			snapshot: takeSnapshot(data),
 		}, nil
 	} else {
 		var rawCounters bytes.Buffer
@@ -46,47 +124,244 @@ func GetCoverage(c CoverageConfig) (*Coverage, error) {
 		if err := coverage.WriteCounters(&rawCounters); err != nil {
 			return nil, err
 		}
-		data, err := ReadFromBuffer(&rawMetadata, &rawCounters, c.MatchPkgs)
+		data, err := readFromBuffer(&rawMetadata, &rawCounters, c)
 		if err != nil {
 			return nil, err
 		}
 
 		return &Coverage{
-			config: c,
-			Data:   data,
+			config:   c,
+			Data:     data,
+			snapshot: takeSnapshot(data),
 		}, nil
 	}
 }
 
+var (
+	progMetaHashOnce sync.Once
+	progMetaHash     string
+	progMetaHashErr  error
+)
+
+// metaFileWritten reports whether 'dir' already holds the meta-data
+// file of the running program. The meta-data hash is computed once per
+// process.
+func metaFileWritten(dir string) (bool, error) {
+	progMetaHashOnce.Do(func() {
+		var buf bytes.Buffer
+		if progMetaHashErr = coverage.WriteMeta(&buf); progMetaHashErr != nil {
+			return
+		}
+		hdr, err := readMetaFileHeader(&buf)
+		if err != nil {
+			progMetaHashErr = fmt.Errorf("reading meta-data header: %v", err)
+			return
+		}
+		progMetaHash = hex.EncodeToString(hdr.MetaFileHash[:])
+	})
+	if progMetaHashErr != nil {
+		return false, progMetaHashErr
+	}
+	_, err := os.Stat(filepath.Join(dir, metaFilePref+"."+progMetaHash))
+	return err == nil, nil
+}
+
+// Advance takes a fresh snapshot of the coverage data of the running
+// program, merges what changed since the previous snapshot into the
+// data held by 'c', and returns only the units that the snapshot
+// covers for the first time. The runtime counters are cumulative, so
+// only the increase of each count is merged; a count lower than in the
+// previous snapshot means the counters were cleared (see
+// runtime/coverage.ClearCounters), and is merged as is.
+func (c *Coverage) Advance() (*CoverageData, error) {
+	cur, err := GetCoverage(c.config)
+	if err != nil {
+		return nil, err
+	}
+	subtractSnapshot(cur.Data, c.snapshot)
+	c.snapshot = cur.snapshot
+	if c.Data == nil {
+		c.Data = cur.Data
+		return newlyCovered(&CoverageData{}, cur.Data), nil
+	}
+	delta := newlyCovered(c.Data, cur.Data)
+	c.Data.Merge(cur.Data)
+	return delta, nil
+}
+
+// snapshotKey identifies a function of the running program within a
+// snapshot of its counters.
+type snapshotKey struct {
+	pod          string
+	pkIdx, fnIdx uint32
+}
+
+// takeSnapshot returns the unit counts of every function of 'data'.
+func takeSnapshot(data *CoverageData) map[snapshotKey][]uint32 {
+	snap := make(map[snapshotKey][]uint32)
+	for hash, p := range data.PodData {
+		for pkIdx, pack := range p.Packages {
+			for fnIdx, fn := range pack.Funcs {
+				counts := make([]uint32, len(fn.Units))
+				for i, u := range fn.Units {
+					counts[i] = u.Count
+				}
+				snap[snapshotKey{hash, pkIdx, fnIdx}] = counts
+			}
+		}
+	}
+	return snap
+}
+
+// subtractSnapshot turns the cumulative counts of 'data' into the
+// increase since 'snap', leaving counts that went down, and those of
+// functions 'snap' doesn't have, unchanged. Units without any increase
+// no longer count as covered in any run.
+func subtractSnapshot(data *CoverageData, snap map[snapshotKey][]uint32) {
+	if snap == nil {
+		return
+	}
+	for hash, p := range data.PodData {
+		for pkIdx, pack := range p.Packages {
+			for fnIdx, fn := range pack.Funcs {
+				prev, ok := snap[snapshotKey{hash, pkIdx, fnIdx}]
+				if !ok || len(prev) != len(fn.Units) {
+					continue
+				}
+				for i, u := range fn.Units {
+					if u.Count >= prev[i] {
+						u.Count -= prev[i]
+					}
+					if u.Count == 0 {
+						u.RunsCovered = 0
+					}
+				}
+			}
+		}
+	}
+}
+
+// Merge merges the coverage data of 'other' into 'c'. Both must have
+// been read with the same package selection (MatchPkgs, ExcludeStdlib
+// and ExcludeSelf), as the merged data would otherwise cover some
+// packages only partially, and pods present in both must agree on
+// counter mode and granularity. The rest of the config of 'other' is
+// ignored.
+func (c *Coverage) Merge(other *Coverage) error {
+	if !samePkgSelection(c.config, other.config) {
+		return fmt.Errorf("merging coverage read with different package selections")
+	}
+	if other.Data == nil {
+		return nil
+	}
+	if c.Data == nil {
+		c.Data = &CoverageData{
+			PodData: make(map[string]*PodData),
+		}
+	}
+	for hash, p := range other.Data.PodData {
+		cur, ok := c.Data.PodData[hash]
+		if !ok {
+			continue
+		}
+		if cur.CounterMode != p.CounterMode {
+			return fmt.Errorf("counter mode clash in pod %s: %s vs %s", hash, cur.CounterMode, p.CounterMode)
+		}
+		if cur.CounterGranularity != p.CounterGranularity {
+			return fmt.Errorf("counter granularity clash in pod %s: %s vs %s", hash, cur.CounterGranularity, p.CounterGranularity)
+		}
+	}
+	c.Data.Merge(other.Data)
+	return nil
+}
+
+// samePkgSelection reports whether 'a' and 'b' select the same packages.
+func samePkgSelection(a, b CoverageConfig) bool {
+	if a.ExcludeStdlib != b.ExcludeStdlib || a.ExcludeSelf != b.ExcludeSelf {
+		return false
+	}
+	ap := append([]string(nil), a.MatchPkgs...)
+	bp := append([]string(nil), b.MatchPkgs...)
+	sort.Strings(ap)
+	sort.Strings(bp)
+	return strings.Join(ap, "\x00") == strings.Join(bp, "\x00")
+}
+
 func (c *Coverage) Reset() error {
 	c.Data = nil
 	return os.RemoveAll(c.config.UseDir)
 }
 
+// GetProfiles returns the coverage data as profiles, one per source
+// file, with one block per unit. The NumStmt of a block is the number
+// of statements of its unit regardless of counter granularity, so the
+// blocks of a function always add up to its statements: in perfunc
+// granularity every block carries the function's single count rather
+// than a single block carrying all of them.
 func (c *Coverage) GetProfiles() []cover.Profile {
+	return c.profiles(c.fileName)
+}
+
+// fileName returns the name under which the source file of function
+// 'fn' of package 'pack' is reported: its SrcFile, qualified if the
+// QualifyFiles option is set.
+func (c *Coverage) fileName(pack *Package, fn *Func) string {
+	if c.config.QualifyFiles {
+		return pack.QualifiedFile(fn)
+	}
+	return fn.SrcFile
+}
+
+// PackageProfile returns the profiles, as GetProfiles would, of only
+// the source files of the functions of the package with import path
+// 'importPath'. It fails if no pod has that package.
+func (c *Coverage) PackageProfile(importPath string) ([]cover.Profile, error) {
+	if _, ok := c.Data.PodOf(importPath); !ok {
+		return nil, fmt.Errorf("package %s not found in coverage data", importPath)
+	}
+	pc := &Coverage{
+		config: c.config,
+		Data: c.Data.filter(func(pack *Package, fn *Func, u *FuncUnit) bool {
+			return pack.ImportPath == importPath
+		}),
+	}
+	return pc.GetProfiles(), nil
+}
+
+// profiles returns one profile per file, where 'fileName' computes the
+// file name under which the blocks of a function are reported.
+func (c *Coverage) profiles(fileName func(pack *Package, fn *Func) string) []cover.Profile {
 	fileProfiles := make(map[string]cover.Profile)
 	for _, p := range c.Data.PodData {
 		for _, pack := range p.Packages {
 			for _, fn := range pack.Funcs {
-				if _, ok := fileProfiles[fn.SrcFile]; !ok {
-					fileProfiles[fn.SrcFile] = cover.Profile{
-						FileName: fn.SrcFile,
+				name := fileName(pack, fn)
+				if _, ok := fileProfiles[name]; !ok {
+					fileProfiles[name] = cover.Profile{
+						FileName: name,
 						Mode:     p.CounterMode.String(),
 						Blocks:   make([]cover.ProfileBlock, 0),
 					}
 				}
-				profile := fileProfiles[fn.SrcFile]
+				profile := fileProfiles[name]
 
 				for _, u := range fn.Units {
+					count := u.Count
+					// Set mode profiles may only carry 0/1 counts, even if
+					// the data was produced or merged carelessly.
+					if p.CounterMode == CtrModeSet && count > 1 {
+						count = 1
+					}
 					profile.Blocks = append(profile.Blocks, cover.ProfileBlock{
-						StartLine: int(u.StLine),
-						StartCol:  int(u.StCol),
-						EndLine:   int(u.EnLine),
-						EndCol:    int(u.EnCol),
-						NumStmt:   int(u.NxStmts),
-						Count:     int(u.Count),
+						StartLine: profileInt(u.StLine),
+						StartCol:  profileInt(u.StCol),
+						EndLine:   profileInt(u.EnLine),
+						EndCol:    profileInt(u.EnCol),
+						NumStmt:   profileInt(u.NxStmts),
+						Count:     profileInt(count),
 					})
 				}
+				fileProfiles[name] = profile
 			}
 		}
 	}
@@ -101,6 +376,17 @@ func (c *Coverage) GetProfiles() []cover.Profile {
 	return out
 }
 
+// profileInt converts a value of coverage data to the int of a profile
+// block. Where int is 32 bits wide, values beyond its range, such as
+// saturated counts, are clamped to math.MaxInt rather than wrapping
+// around to negative numbers.
+func profileInt(v uint32) int {
+	if uint64(v) > math.MaxInt {
+		return math.MaxInt
+	}
+	return int(v)
+}
+
 func (c *Coverage) GetPercent() float64 {
 	totalStmts := 0
 	covered := 0
@@ -108,9 +394,9 @@ func (c *Coverage) GetPercent() float64 {
 		for _, pack := range p.Packages {
 			for _, fn := range pack.Funcs {
 				for _, u := range fn.Units {
-					nx := int(u.NxStmts)
+					nx := u.Weight()
 					totalStmts += nx
-					if u.Count != 0 {
+					if u.Covered() {
 						covered += nx
 					}
 				}
@@ -121,14 +407,30 @@ func (c *Coverage) GetPercent() float64 {
 	return 100 * float64(covered) / float64(totalStmts)
 }
 
+// PercentUnder returns the percentage of statements covered in the
+// packages whose import path is 'prefix' or lies below it, so that
+// "a/b" includes "a/b/c" but not "a/bc". It returns 0 if those packages
+// have no statements.
+func (c *Coverage) PercentUnder(prefix string) float64 {
+	prefix = strings.TrimSuffix(prefix, "/")
+	var sum stmtCount
+	for path, sc := range c.packageStmtCoverage() {
+		if path == prefix || strings.HasPrefix(path, prefix+"/") {
+			sum.covered += sc.covered
+			sum.total += sc.total
+		}
+	}
+	return sum.percent()
+}
+
 func (c *Coverage) GetCoveredLines() int {
 	covered := 0
 	for _, p := range c.Data.PodData {
 		for _, pack := range p.Packages {
 			for _, fn := range pack.Funcs {
 				for _, u := range fn.Units {
-					nx := int(u.NxStmts)
-					if u.Count != 0 {
+					nx := u.Weight()
+					if u.Covered() {
 						covered += nx
 					}
 				}
@@ -137,3 +439,244 @@ func (c *Coverage) GetCoveredLines() int {
 	}
 	return covered
 }
+
+// CountHistogram returns the number of units whose execution count
+// falls into each bucket, keyed by the bucket's upper bound. 'buckets'
+// holds the inclusive upper bounds in any order, so {0, 1, 10} counts
+// the units run never, exactly once, and 2 to 10 times. Units with a
+// count above the largest bound are keyed by math.MaxUint32. In set
+// mode all counts are 0 or 1, so the histogram is not meaningful.
+func (c *Coverage) CountHistogram(buckets []uint32) map[uint32]int {
+	bounds := append([]uint32(nil), buckets...)
+	sort.Slice(bounds, func(i, j int) bool { return bounds[i] < bounds[j] })
+	hist := make(map[uint32]int)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				for _, u := range fn.Units {
+					i := sort.Search(len(bounds), func(i int) bool { return u.Count <= bounds[i] })
+					if i == len(bounds) {
+						hist[math.MaxUint32]++
+					} else {
+						hist[bounds[i]]++
+					}
+				}
+			}
+		}
+	}
+	return hist
+}
+
+// PodInfo summarizes a pod, i.e. the coverage data of one binary.
+type PodInfo struct {
+	Hash        string
+	Mode        string
+	Granularity string
+	NumPackages int
+}
+
+// Pods returns a summary of every pod, sorted by hash.
+func (c *Coverage) Pods() []PodInfo {
+	pods := []PodInfo{}
+	for _, hash := range sortedKeys(c.Data.PodData) {
+		p := c.Data.PodData[hash]
+		pods = append(pods, PodInfo{
+			Hash:        hash,
+			Mode:        p.CounterMode.String(),
+			Granularity: p.CounterGranularity.String(),
+			NumPackages: len(p.Packages),
+		})
+	}
+	return pods
+}
+
+// Mode returns the counter mode and granularity shared by all pods, or
+// an error if the pods disagree. With no pods both are invalid.
+func (c *Coverage) Mode() (counterMode, CounterGranularity, error) {
+	mode, gran := CtrModeInvalid, CtrGranularityInvalid
+	for _, hash := range sortedKeys(c.Data.PodData) {
+		p := c.Data.PodData[hash]
+		if mode == CtrModeInvalid {
+			mode, gran = p.CounterMode, p.CounterGranularity
+			continue
+		}
+		if p.CounterMode != mode {
+			return CtrModeInvalid, CtrGranularityInvalid, fmt.Errorf("counter mode clash: pod %s has %s, others have %s", hash, p.CounterMode, mode)
+		}
+		if p.CounterGranularity != gran {
+			return CtrModeInvalid, CtrGranularityInvalid, fmt.Errorf("counter granularity clash: pod %s has %s, others have %s", hash, p.CounterGranularity, gran)
+		}
+	}
+	return mode, gran, nil
+}
+
+// Overflowed reports whether any counter saturated (reached
+// math.MaxUint32) while the coverage data was read or merged. Once
+// this happens count-mode data is no longer exact.
+func (c *Coverage) Overflowed() bool {
+	return c.Data.overflowed
+}
+
+// OverflowSite identifies a unit whose counter saturated.
+type OverflowSite struct {
+	ImportPath string
+	FuncName   string
+	// Unit is the index of the unit within Func.Units.
+	Unit int
+}
+
+// Overflows returns the units whose counters saturated while the
+// coverage data was read or merged, sorted and without duplicates.
+func (c *Coverage) Overflows() []OverflowSite {
+	sites := append([]OverflowSite(nil), c.Data.overflows...)
+	sort.Slice(sites, func(i, j int) bool {
+		si, sj := sites[i], sites[j]
+		if si.ImportPath != sj.ImportPath {
+			return si.ImportPath < sj.ImportPath
+		}
+		if si.FuncName != sj.FuncName {
+			return si.FuncName < sj.FuncName
+		}
+		return si.Unit < sj.Unit
+	})
+	out := sites[:0]
+	for i, s := range sites {
+		if i == 0 || s != sites[i-1] {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// PodLiveness returns the fraction of the packages in the pod with
+// meta-data hash 'hash' that have at least one covered unit, as a rough
+// measure of how representative the run was. It returns 0 if the pod
+// is unknown or has no packages.
+func (c *Coverage) PodLiveness(hash string) float64 {
+	p, ok := c.Data.PodData[hash]
+	if !ok || len(p.Packages) == 0 {
+		return 0
+	}
+	live := 0
+	for _, pack := range p.Packages {
+	funcs:
+		for _, fn := range pack.Funcs {
+			for _, u := range fn.Units {
+				if u.Covered() {
+					live++
+					break funcs
+				}
+			}
+		}
+	}
+	return float64(live) / float64(len(p.Packages))
+}
+
+// PodProvenance returns the build configuration (build tags, GOFLAGS,
+// Go version) recorded in the counter data files of the pod with
+// meta-data hash 'hash'. The map is empty if the pod is unknown or its
+// counter data files carry no such information.
+func (c *Coverage) PodProvenance(hash string) map[string]string {
+	out := make(map[string]string)
+	if p, ok := c.Data.PodData[hash]; ok {
+		for k, v := range p.Provenance {
+			out[k] = v
+		}
+	}
+	return out
+}
+
+// Toolchains returns the Go version (such as "go1.22") that produced
+// each pod, keyed by meta-data hash. Meta-data files don't record the
+// toolchain, so it is taken from the "goversion" arg of the counter
+// data files; pods without one are omitted.
+func (c *Coverage) Toolchains() map[string]string {
+	out := make(map[string]string)
+	for hash, p := range c.Data.PodData {
+		if v, ok := p.Provenance["goversion"]; ok {
+			out[hash] = v
+		}
+	}
+	return out
+}
+
+// ExportedFuncCoverage reports how many exported functions were
+// executed at least once. A function is exported if it is not a
+// function literal and its name (the method name, for methods) begins
+// with an upper case letter. Functions present in more than one pod
+// are counted once, and are covered if any pod covers them. The
+// uncovered functions are returned as sorted "importpath.name" strings.
+func (c *Coverage) ExportedFuncCoverage() (covered, total int, uncovered []string) {
+	funcs := make(map[string]bool)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				if fn.Lit || !isExportedFunc(fn.Name) {
+					continue
+				}
+				key := pack.ImportPath + "." + fn.Name
+				hit := funcs[key]
+				for _, u := range fn.Units {
+					hit = hit || u.Covered()
+				}
+				funcs[key] = hit
+			}
+		}
+	}
+	uncovered = []string{}
+	for name, hit := range funcs {
+		total++
+		if hit {
+			covered++
+		} else {
+			uncovered = append(uncovered, name)
+		}
+	}
+	sort.Strings(uncovered)
+	return covered, total, uncovered
+}
+
+func isExportedFunc(name string) bool {
+	if i := strings.LastIndex(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	r, _ := utf8.DecodeRuneInString(name)
+	return unicode.IsUpper(r)
+}
+
+// ReadStats returns the number of counter data files and bytes that
+// were read to produce the coverage data.
+func (c *Coverage) ReadStats() ReadStats {
+	return c.Data.stats
+}
+
+// RawCounters returns a copy of the per-unit counts of every function,
+// keyed by "importpath.funcname", for debugging. Should several
+// functions map to the same key (for example the same function in more
+// than one pod), the later ones in pod, package and function index
+// order get a "#n" suffix.
+func (c *Coverage) RawCounters() map[string][]uint32 {
+	out := make(map[string][]uint32)
+	for _, hash := range sortedKeys(c.Data.PodData) {
+		p := c.Data.PodData[hash]
+		for _, pkIdx := range sortedKeys(p.Packages) {
+			pack := p.Packages[pkIdx]
+			for _, fnIdx := range sortedKeys(pack.Funcs) {
+				fn := pack.Funcs[fnIdx]
+				key := pack.ImportPath + "." + fn.Name
+				for n := 1; ; n++ {
+					if _, ok := out[key]; !ok {
+						break
+					}
+					key = fmt.Sprintf("%s.%s#%d", pack.ImportPath, fn.Name, n)
+				}
+				counts := make([]uint32, len(fn.Units))
+				for i, u := range fn.Units {
+					counts[i] = u.Count
+				}
+				out[key] = counts
+			}
+		}
+	}
+	return out
+}
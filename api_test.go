@@ -0,0 +1,576 @@
+package gocov
+
+import (
+	"bytes"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestOverflowedReading(t *testing.T) {
+	if readTestCoverage(t, countDir).Overflowed() {
+		t.Fatal("Overflowed() = true for plain data")
+	}
+
+	dir := copyTestDir(t, countDir)
+	writeCounterFile(t, dir, countHash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{math.MaxUint32, math.MaxUint32, 0}},
+	})
+	c := readTestCoverage(t, dir)
+	if !c.Overflowed() {
+		t.Fatal("Overflowed() = false after reading saturating counters")
+	}
+	if got := unitCounts(findFunc(c.Data, countHash, "example.com/prog/lib", "Add")); got[0] != math.MaxUint32 {
+		t.Errorf("saturated count = %d, want %d", got[0], uint32(math.MaxUint32))
+	}
+}
+
+func TestOverflowedMerging(t *testing.T) {
+	c := readTestCoverage(t, countDir)
+	other := readTestDir(t, countDir)
+	findFunc(other, countHash, "example.com/prog", "main").Units[0].Count = math.MaxUint32
+	c.Data.Merge(other)
+	if !c.Overflowed() {
+		t.Fatal("Overflowed() = false after a saturating merge")
+	}
+
+	// The flag carries over into data merged into other data.
+	fresh := readTestDir(t, countDir)
+	fresh.Merge(c.Data)
+	if !(&Coverage{Data: fresh}).Overflowed() {
+		t.Error("Overflowed() = false after merging overflowed data")
+	}
+}
+
+func TestOverflows(t *testing.T) {
+	if got := readTestCoverage(t, countDir).Overflows(); len(got) != 0 {
+		t.Errorf("Overflows() = %v for plain data", got)
+	}
+
+	// Saturated while reading: the counters of the first two units of
+	// Add, in two counter data files.
+	dir := copyTestDir(t, countDir)
+	for pid := 1; pid <= 2; pid++ {
+		writeCounterFile(t, dir, countHash, pid, nil, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{math.MaxUint32, math.MaxUint32, 0}},
+		})
+	}
+	c := readTestCoverage(t, dir)
+	want := []OverflowSite{
+		{ImportPath: "example.com/prog/lib", FuncName: "Add", Unit: 0},
+		{ImportPath: "example.com/prog/lib", FuncName: "Add", Unit: 1},
+	}
+	if got := c.Overflows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after reading: Overflows() = %+v, want %+v", got, want)
+	}
+
+	// Saturated while merging: the first unit of main.
+	other := readTestDir(t, countDir)
+	findFunc(other, countHash, "example.com/prog", "main").Units[0].Count = math.MaxUint32
+	c.Data.Merge(other)
+	want = append([]OverflowSite{{ImportPath: "example.com/prog", FuncName: "main", Unit: 0}}, want...)
+	if got := c.Overflows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after merging: Overflows() = %+v, want %+v", got, want)
+	}
+
+	// The sites carry over into data merged into other data.
+	fresh := readTestDir(t, countDir)
+	fresh.Merge(c.Data)
+	if got := (&Coverage{Data: fresh}).Overflows(); !reflect.DeepEqual(got, want) {
+		t.Errorf("after merging overflowed data: Overflows() = %+v, want %+v", got, want)
+	}
+}
+
+func TestPodProvenance(t *testing.T) {
+	if got := readTestCoverage(t, setDir).PodProvenance(setHash); len(got) != 0 {
+		t.Errorf("PodProvenance without build args = %v, want none", got)
+	}
+
+	dir := copyTestDir(t, setDir)
+	writeCounterFile(t, dir, setHash, 1, map[string]string{
+		"buildtags": "linux,foo",
+		"GOFLAGS":   "-tags=foo",
+		"GOOS":      "linux",
+	}, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{0, 0, 0}}})
+	c := readTestCoverage(t, dir)
+	want := map[string]string{"buildtags": "linux,foo", "GOFLAGS": "-tags=foo"}
+	got := c.PodProvenance(setHash)
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("PodProvenance = %v, want %v", got, want)
+	}
+	got["buildtags"] = "changed"
+	if c.PodProvenance(setHash)["buildtags"] != "linux,foo" {
+		t.Error("PodProvenance returned the pod's own map")
+	}
+	if got := c.PodProvenance("0123"); len(got) != 0 {
+		t.Errorf("PodProvenance of an unknown pod = %v, want none", got)
+	}
+}
+
+func TestToolchains(t *testing.T) {
+	// The set pod gets a segment recording its Go version, the variant
+	// pod none.
+	dir := mergeTestDirs(t, setDir, variantDir)
+	writeCounterFile(t, dir, setHash, 1, map[string]string{
+		"goversion": "go1.22",
+		"GOOS":      "linux",
+	}, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{0, 0, 0}}})
+	c := readTestCoverage(t, dir)
+	want := map[string]string{setHash: "go1.22"}
+	if got := c.Toolchains(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Toolchains = %v, want %v", got, want)
+	}
+	if got := c.PodProvenance(setHash)["goversion"]; got != "go1.22" {
+		t.Errorf("PodProvenance goversion = %q, want go1.22", got)
+	}
+	if got := readTestCoverage(t, setDir).Toolchains(); len(got) != 0 {
+		t.Errorf("Toolchains of files written by the runtime = %v, want none", got)
+	}
+}
+
+func TestExportedFuncCoverage(t *testing.T) {
+	tests := []struct {
+		dirs           []string
+		covered, total int
+		uncovered      []string
+	}{
+		{[]string{setDir}, 3, 4, []string{"example.com/prog/lib.u.Exp"}},
+		{[]string{variantDir}, 1, 5, []string{
+			"example.com/prog/lib.*T.Method",
+			"example.com/prog/lib.u.Exp",
+			"example.com/prog/util.Also",
+			"example.com/prog/util.Never",
+		}},
+		// A function is covered if any pod covers it.
+		{[]string{setDir, variantDir}, 3, 5, []string{
+			"example.com/prog/lib.u.Exp",
+			"example.com/prog/util.Also",
+		}},
+	}
+	for _, tt := range tests {
+		c := &Coverage{Data: readTestDir(t, mergeTestDirs(t, tt.dirs...))}
+		covered, total, uncovered := c.ExportedFuncCoverage()
+		if covered != tt.covered || total != tt.total || !reflect.DeepEqual(uncovered, tt.uncovered) {
+			t.Errorf("%v: ExportedFuncCoverage = %d, %d, %v; want %d, %d, %v",
+				tt.dirs, covered, total, uncovered, tt.covered, tt.total, tt.uncovered)
+		}
+	}
+}
+
+func TestIsExportedFunc(t *testing.T) {
+	for name, want := range map[string]bool{
+		"Add":       true,
+		"unused":    false,
+		"*T.Method": true,
+		"*T.method": false,
+		"u.Exp":     true,
+		"Élan":      true,
+		"func1":     false,
+		"":          false,
+	} {
+		if got := isExportedFunc(name); got != want {
+			t.Errorf("isExportedFunc(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestReadStats(t *testing.T) {
+	// Only counter data files are counted: the set pod has two, of 131
+	// and 153 bytes, and the count pod three, of 131, 153 and 131 bytes.
+	if got, want := readTestCoverage(t, setDir).ReadStats(), (ReadStats{FilesRead: 2, BytesRead: 284}); got != want {
+		t.Errorf("set pod: ReadStats() = %+v, want %+v", got, want)
+	}
+	c, err := MergeDirs([]string{setDir, countDir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := c.ReadStats(), (ReadStats{FilesRead: 5, BytesRead: 699}); got != want {
+		t.Errorf("merged pods: ReadStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	want := "advance 1: first\nadvance 2: second\nheld: first second\n"
+	if out := runCovClient(t, "advance"); out != want {
+		t.Errorf("covclient advance printed\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestGetCoverageUseDir(t *testing.T) {
+	// The second call must only add a counter data file, leaving the
+	// meta-data file written by the first untouched.
+	want := "meta rewritten: false\nmeta files: 1\ncounter files: 2\n"
+	if out := runCovClient(t, "usedir", t.TempDir()); out != want {
+		t.Errorf("covclient usedir printed\n%s\nwant\n%s", out, want)
+	}
+}
+
+func TestSubtractSnapshot(t *testing.T) {
+	snap := takeSnapshot(readTestDir(t, countDir))
+
+	// A later snapshot in which Add ran twice more, Method didn't run
+	// again, and the counters were cleared before main ran once more
+	// without arguments.
+	later := readTestDir(t, countDir)
+	for _, u := range findFunc(later, countHash, "example.com/prog/lib", "Add").Units {
+		if u.Count != 0 {
+			u.Count += 2
+		}
+	}
+	mainFn := findFunc(later, countHash, "example.com/prog", "main")
+	mainFn.Units[0].Count, mainFn.Units[1].Count = 1, 0
+	subtractSnapshot(later, snap)
+
+	tests := []struct {
+		pkg, fn string
+		want    []uint32
+	}{
+		{"example.com/prog/lib", "Add", []uint32{2, 2, 0}},
+		{"example.com/prog/lib", "*T.Method", []uint32{0, 0, 0}},
+		{"example.com/prog", "main", []uint32{1, 0}},
+	}
+	for _, tt := range tests {
+		fn := findFunc(later, countHash, tt.pkg, tt.fn)
+		if got := unitCounts(fn); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: counts %v, want %v", tt.fn, got, tt.want)
+		}
+		for i, u := range fn.Units {
+			if u.Count == 0 && u.RunsCovered != 0 {
+				t.Errorf("%s unit %d: covered in %d runs without any increase", tt.fn, i, u.RunsCovered)
+			}
+		}
+	}
+}
+
+func TestRawCounters(t *testing.T) {
+	c := readTestCoverage(t, countDir)
+	raw := c.RawCounters()
+	tests := map[string][]uint32{
+		"example.com/prog/lib.Add":       {3, 3, 0},
+		"example.com/prog/lib.*T.Method": {1, 1, 1},
+		"example.com/prog.main":          {3, 1},
+	}
+	for key, want := range tests {
+		if got := raw[key]; !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: counts %v, want %v", key, got, want)
+		}
+	}
+	// The counts are copies.
+	raw["example.com/prog/lib.Add"][0] = 100
+	if got := findFunc(c.Data, countHash, "example.com/prog/lib", "Add").Units[0].Count; got != 3 {
+		t.Errorf("changing the raw counters changed the data to %d", got)
+	}
+
+	// Functions of the same name get an index, in function order.
+	c = &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+		"pod": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/pkg",
+				testFunc("func1", "/src/pkg/a.go", []uint32{3}, []uint32{1}),
+				testFunc("func1", "/src/pkg/b.go", []uint32{3}, []uint32{2}),
+				testFunc("func1", "/src/pkg/c.go", []uint32{3}, []uint32{3}),
+			),
+		}},
+	}}}
+	want := map[string][]uint32{
+		"example.com/test/pkg.func1":   {1},
+		"example.com/test/pkg.func1#1": {2},
+		"example.com/test/pkg.func1#2": {3},
+	}
+	if got := c.RawCounters(); !reflect.DeepEqual(got, want) {
+		t.Errorf("RawCounters() = %v, want %v", got, want)
+	}
+}
+
+func TestPercentUnder(t *testing.T) {
+	pkg := func(path string, counts ...uint32) *Package {
+		lines := make([]uint32, len(counts))
+		for i := range lines {
+			lines[i] = uint32(3 + i)
+		}
+		return testPackage(path, testFunc("F", "/src/"+path+"/f.go", lines, counts))
+	}
+	c := &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+		"pod": {Packages: map[uint32]*Package{
+			0: pkg("example.com/proj/internal", 1, 0),
+			1: pkg("example.com/proj/internal/a", 1, 1),
+			2: pkg("example.com/proj/internal/a/b", 0, 0, 0, 0),
+			// Siblings sharing a string prefix.
+			3: pkg("example.com/proj/internalx", 1, 1, 1, 1),
+			4: pkg("example.com/proj/cmd", 0),
+		}},
+	}}}
+	tests := []struct {
+		prefix string
+		want   float64
+	}{
+		{"example.com/proj/internal", 100 * 3.0 / 8},
+		{"example.com/proj/internal/", 100 * 3.0 / 8},
+		{"example.com/proj/internal/a", 100 * 2.0 / 6},
+		{"example.com/proj/internalx", 100},
+		{"example.com/proj", 100 * 7.0 / 13},
+		{"example.com/other", 0},
+	}
+	for _, tt := range tests {
+		if got := c.PercentUnder(tt.prefix); got != tt.want {
+			t.Errorf("PercentUnder(%q) = %.2f, want %.2f", tt.prefix, got, tt.want)
+		}
+	}
+}
+
+func TestPodLiveness(t *testing.T) {
+	dir, hash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a", testFunc("A", "/src/a.go", []uint32{3, 4}, []uint32{0, 1})),
+			1: testPackage("example.com/test/b",
+				testFunc("B1", "/src/b.go", []uint32{3}, []uint32{0}),
+				testFunc("B2", "/src/b.go", []uint32{5}, []uint32{1}),
+			),
+			2: testPackage("example.com/test/c", testFunc("C", "/src/c.go", []uint32{3}, []uint32{0})),
+			3: testPackage("example.com/test/d", testFunc("D", "/src/d.go", []uint32{3, 4}, []uint32{0, 0})),
+		},
+	})
+	c := readTestCoverage(t, dir)
+	if got := c.PodLiveness(hash); got != 0.5 {
+		t.Errorf("PodLiveness(%s) = %v, want 0.5", hash, got)
+	}
+	if got := c.PodLiveness("unknown"); got != 0 {
+		t.Errorf("PodLiveness of an unknown pod = %v, want 0", got)
+	}
+}
+
+func TestCountHistogram(t *testing.T) {
+	// The count pod has units run 0 (five), 1 (five) and 3 (three)
+	// times.
+	c := readTestCoverage(t, countDir)
+	tests := []struct {
+		buckets []uint32
+		want    map[uint32]int
+	}{
+		{[]uint32{10, 0, 1}, map[uint32]int{0: 5, 1: 5, 10: 3}},
+		{[]uint32{0, 2}, map[uint32]int{0: 5, 2: 5, math.MaxUint32: 3}},
+		{nil, map[uint32]int{math.MaxUint32: 13}},
+	}
+	for _, tt := range tests {
+		if got := c.CountHistogram(tt.buckets); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CountHistogram(%v) = %v, want %v", tt.buckets, got, tt.want)
+		}
+	}
+}
+
+func TestCoverageMerge(t *testing.T) {
+	// Each run of the set pod, read from its own buffers.
+	meta, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromBuffer := func(cdf string, c CoverageConfig) *Coverage {
+		counters, err := os.ReadFile(cdf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := readFromBuffer(bytes.NewBuffer(meta), bytes.NewBuffer(counters), c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Coverage{config: c, Data: data}
+	}
+	cdfs, err := filepath.Glob(filepath.Join(setDir, counterFilePref+".*"))
+	if err != nil || len(cdfs) != 2 {
+		t.Fatalf("found counter data files %v (%v), want two", cdfs, err)
+	}
+	c := fromBuffer(cdfs[0], CoverageConfig{})
+	if err := c.Merge(fromBuffer(cdfs[1], CoverageConfig{})); err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, c.Data, readTestDir(t, setDir))
+
+	// Merging into empty coverage, and merging empty coverage.
+	empty := &Coverage{}
+	if err := empty.Merge(c); err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, empty.Data, c.Data)
+	if err := c.Merge(&Coverage{}); err != nil {
+		t.Errorf("merging empty coverage: %v", err)
+	}
+
+	// Coverage of different package selections doesn't merge.
+	lib := CoverageConfig{MatchPkgs: []string{"example.com/prog/lib"}}
+	if err := c.Merge(fromBuffer(cdfs[1], lib)); err == nil {
+		t.Error("merging coverage of different package selections succeeded")
+	}
+	if err := fromBuffer(cdfs[0], lib).Merge(fromBuffer(cdfs[1], lib)); err != nil {
+		t.Errorf("merging coverage of the same package selection: %v", err)
+	}
+
+	// Nor does coverage of the same pod in different counter modes.
+	other := fromBuffer(cdfs[1], CoverageConfig{})
+	other.Data.PodData[setHash].CounterMode = CtrModeCount
+	if err := c.Merge(other); err == nil || !strings.Contains(err.Error(), "counter mode clash") {
+		t.Errorf("merging set and count mode data: error %v, want a counter mode clash", err)
+	}
+}
+
+func TestMode(t *testing.T) {
+	mode, gran, err := readTestCoverage(t, mergeTestDirs(t, setDir, variantDir)).Mode()
+	if err != nil || mode != CtrModeSet || gran != CtrGranularityPerBlock {
+		t.Errorf("consistent pods: Mode() = %s, %s, %v; want set, perblock", mode, gran, err)
+	}
+
+	if _, _, err := readTestCoverage(t, mergeTestDirs(t, setDir, countDir)).Mode(); err == nil || !strings.Contains(err.Error(), "counter mode clash") {
+		t.Errorf("set and count pods: Mode error %v, want a counter mode clash", err)
+	}
+
+	c := readTestCoverage(t, mergeTestDirs(t, setDir, variantDir))
+	c.Data.PodData[variantHash].CounterGranularity = CtrGranularityPerFunc
+	if _, _, err := c.Mode(); err == nil || !strings.Contains(err.Error(), "counter granularity clash") {
+		t.Errorf("perblock and perfunc pods: Mode error %v, want a counter granularity clash", err)
+	}
+
+	mode, gran, err = (&Coverage{Data: &CoverageData{}}).Mode()
+	if err != nil || mode != CtrModeInvalid || gran != CtrGranularityInvalid {
+		t.Errorf("no pods: Mode() = %s, %s, %v; want invalid mode and granularity", mode, gran, err)
+	}
+}
+
+func TestPods(t *testing.T) {
+	c := readTestCoverage(t, mergeTestDirs(t, setDir, countDir))
+	want := []PodInfo{
+		{Hash: countHash, Mode: "count", Granularity: "perblock", NumPackages: 3},
+		{Hash: setHash, Mode: "set", Granularity: "perblock", NumPackages: 3},
+	}
+	if countHash > setHash {
+		want[0], want[1] = want[1], want[0]
+	}
+	if got := c.Pods(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Pods() = %+v, want %+v", got, want)
+	}
+	if got := (&Coverage{Data: &CoverageData{}}).Pods(); len(got) != 0 {
+		t.Errorf("Pods() of no data = %+v, want none", got)
+	}
+}
+
+func TestProfileStmtsByGranularity(t *testing.T) {
+	tests := []struct {
+		gran     CounterGranularity
+		counters []uint32
+		counts   []int
+		percent  float64
+	}{
+		{CtrGranularityPerBlock, []uint32{1, 0, 1}, []int{1, 0, 1}, 50},
+		{CtrGranularityPerFunc, []uint32{1}, []int{1, 1, 1}, 100},
+	}
+	for _, tt := range tests {
+		fn := testFunc("F", "/src/f.go", []uint32{3, 4, 5}, []uint32{0, 0, 0})
+		for i, u := range fn.Units {
+			u.NxStmts = []uint32{2, 3, 1}[i]
+		}
+		dir, hash := writeTestPod(t, &PodData{
+			CounterMode:        CtrModeSet,
+			CounterGranularity: tt.gran,
+			Packages:           map[uint32]*Package{0: testPackage("example.com/test/fn", fn)},
+		})
+		writeCounterFile(t, dir, hash, 1, nil, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: tt.counters}})
+
+		cached, err := ReadDirCached(dir, t.TempDir(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, data := range map[string]*CoverageData{"ReadDir": readTestDir(t, dir), "ReadDirCached": cached} {
+			c := &Coverage{Data: data}
+			profiles := c.GetProfiles()
+			if len(profiles) != 1 {
+				t.Fatalf("%s, %s: %d profiles, want 1", tt.gran, name, len(profiles))
+			}
+			stmts := 0
+			var counts []int
+			for _, b := range profiles[0].Blocks {
+				stmts += b.NumStmt
+				counts = append(counts, b.Count)
+			}
+			if stmts != 6 {
+				t.Errorf("%s, %s: blocks add up to %d statements, want 6", tt.gran, name, stmts)
+			}
+			if !reflect.DeepEqual(counts, tt.counts) {
+				t.Errorf("%s, %s: block counts %v, want %v", tt.gran, name, counts, tt.counts)
+			}
+			if got := c.GetPercent(); got != tt.percent {
+				t.Errorf("%s, %s: GetPercent = %v, want %v", tt.gran, name, got, tt.percent)
+			}
+		}
+	}
+}
+
+func TestProfileLargeCounts(t *testing.T) {
+	c := readTestCoverage(t, countDir)
+	const big = math.MaxUint32 - 1
+	add := findFunc(c.Data, countHash, "example.com/prog/lib", "Add")
+	add.Units[0].Count = big
+	add.Units[1].Count = math.MaxUint32
+	counts := make(map[int]bool)
+	for _, p := range c.GetProfiles() {
+		for _, b := range p.Blocks {
+			if b.Count < 0 || b.NumStmt < 0 || b.StartLine < 0 || b.EndCol < 0 {
+				t.Errorf("%s: negative value in block %+v", p.FileName, b)
+			}
+			counts[b.Count] = true
+		}
+	}
+	// Where int is 32 bits wide, the counts are clamped instead.
+	for _, want := range []uint32{big, math.MaxUint32} {
+		if !counts[profileInt(want)] {
+			t.Errorf("no block with count %d among %v", profileInt(want), counts)
+		}
+	}
+
+	// Counts read back from a text profile are clamped to the range of
+	// the coverage data.
+	type conv struct {
+		in   int
+		want uint32
+	}
+	tests := []conv{{-1, 0}, {0, 0}, {7, 7}}
+	if strconv.IntSize == 64 {
+		// Not constants, which wouldn't compile where int is 32 bits.
+		large := uint64(math.MaxUint32)
+		tests = append(tests, conv{int(large - 1), big}, conv{int(large + 1), math.MaxUint32})
+	}
+	for _, tt := range tests {
+		if got := profileUint(tt.in); got != tt.want {
+			t.Errorf("profileUint(%d) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPackageProfile(t *testing.T) {
+	c := readTestCoverage(t, countDir)
+	tests := []struct {
+		importPath, file string
+		blocks           int
+	}{
+		{"example.com/prog/util", "example.com/prog/util/util.go", 1},
+		{"example.com/prog/lib", "example.com/prog/lib/lib.go", 10},
+	}
+	for _, tt := range tests {
+		profiles, err := c.PackageProfile(tt.importPath)
+		if err != nil {
+			t.Fatalf("PackageProfile(%q): %v", tt.importPath, err)
+		}
+		if len(profiles) != 1 || profiles[0].FileName != tt.file || len(profiles[0].Blocks) != tt.blocks {
+			t.Errorf("PackageProfile(%q) = %+v, want %d blocks of %s", tt.importPath, profiles, tt.blocks, tt.file)
+		}
+	}
+	if _, err := c.PackageProfile("example.com/prog/missing"); err == nil {
+		t.Error("PackageProfile of a missing package succeeded")
+	}
+	// The data itself is left alone.
+	if n := len(c.GetProfiles()); n != 3 {
+		t.Errorf("GetProfiles after PackageProfile: %d profiles, want 3", n)
+	}
+}
@@ -0,0 +1,52 @@
+package gocov
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+)
+
+// ReadTar reads the coverage data files contained in the tar archive
+// read from 'r', typically an archived GOCOVERDIR. The files are
+// buffered in memory and grouped into pods the same way as the files
+// of a directory read by ReadDir.
+func ReadTar(r io.Reader, matchPkgs []string) (*CoverageData, error) {
+	files := make(map[string][]byte)
+	names := []string{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("reading tar archive: %v", err)
+		}
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s from tar archive: %v", hdr.Name, err)
+		}
+		if _, ok := files[hdr.Name]; !ok {
+			names = append(names, hdr.Name)
+		}
+		files[hdr.Name] = b
+	}
+
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+
+	vis := &covDataVisitor{
+		cm:   &merger{},
+		data: data,
+	}
+	reader := makeCovDataPodReader(vis, collectPodsImpl(names), matchPkgs...)
+	reader.files = files
+	err := reader.Visit()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,64 @@
+package gocov
+
+import (
+	"archive/tar"
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarTestDirs returns a tar archive of the files of 'dirs', stored
+// under a "covdata" directory as CI jobs commonly upload them.
+func tarTestDirs(t *testing.T, dirs ...string) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "covdata/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, dir := range dirs {
+		ents, err := os.ReadDir(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdr := &tar.Header{Name: "covdata/" + e.Name(), Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(b))}
+			if err := tw.WriteHeader(hdr); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := tw.Write(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestReadTar(t *testing.T) {
+	got, err := ReadTar(tarTestDirs(t, setDir), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, setDir))
+
+	// Several pods, read with a package filter.
+	got, err = ReadTar(tarTestDirs(t, setDir, countDir), []string{"example.com/prog/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, mergeTestDirs(t, setDir, countDir), "example.com/prog/lib"))
+}
+
+func TestReadTarCorrupt(t *testing.T) {
+	if _, err := ReadTar(bytes.NewReader([]byte("not a tar archive, but long enough to look like a header block")), nil); err == nil {
+		t.Error("ReadTar of garbage succeeded")
+	}
+}
@@ -0,0 +1,52 @@
+package gocov
+
+import (
+	"fmt"
+	"io"
+	"text/template"
+)
+
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="104" height="20" role="img" aria-label="coverage: {{.Text}}">
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="104" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="61" height="20" fill="#555"/><rect x="61" width="43" height="20" fill="{{.Color}}"/><rect width="104" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="30.5" y="14">coverage</text>
+<text x="81.5" y="14">{{.Text}}</text>
+</g>
+</svg>
+`))
+
+// Badge colors, as used by shields.io.
+const (
+	badgeRed    = "#e05d44"
+	badgeYellow = "#dfb317"
+	badgeGreen  = "#4c1"
+)
+
+// WriteBadgeSVG writes a shields.io style badge showing the statement
+// coverage percentage, rounded to a whole number. The badge is red
+// below 'thresholds[0]' percent (50 by default), green from
+// 'thresholds[1]' percent (80 by default) and yellow in between.
+func (c *Coverage) WriteBadgeSVG(w io.Writer, thresholds ...float64) error {
+	yellow, green := 50.0, 80.0
+	if len(thresholds) > 0 {
+		yellow = thresholds[0]
+	}
+	if len(thresholds) > 1 {
+		green = thresholds[1]
+	}
+	covered, total := c.stmtCoverage()
+	pct := stmtCount{covered, total}.percent()
+	color := badgeRed
+	switch {
+	case pct >= green:
+		color = badgeGreen
+	case pct >= yellow:
+		color = badgeYellow
+	}
+	return badgeTemplate.Execute(w, struct {
+		Text  string
+		Color string
+	}{fmt.Sprintf("%.0f%%", pct), color})
+}
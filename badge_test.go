@@ -0,0 +1,58 @@
+package gocov
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+)
+
+func TestWriteBadgeSVG(t *testing.T) {
+	// cov returns coverage data with 'covered' of 'total' statements
+	// covered.
+	cov := func(covered, total int) *Coverage {
+		lines := make([]uint32, total)
+		counts := make([]uint32, total)
+		for i := range lines {
+			lines[i] = uint32(3 + i)
+			if i < covered {
+				counts[i] = 1
+			}
+		}
+		return &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+			"pod": {
+				CounterMode:        CtrModeSet,
+				CounterGranularity: CtrGranularityPerBlock,
+				Packages: map[uint32]*Package{
+					0: testPackage("example.com/test/p", testFunc("F", "p.go", lines, counts)),
+				},
+			},
+		}}}
+	}
+	tests := []struct {
+		c          *Coverage
+		thresholds []float64
+		text       string
+		color      string
+	}{
+		{cov(1, 3), nil, "33%", badgeRed},
+		{cov(2, 3), nil, "67%", badgeYellow},
+		{cov(4, 5), nil, "80%", badgeGreen},
+		{cov(2, 3), []float64{70}, "67%", badgeRed},
+		{cov(2, 3), []float64{60, 65}, "67%", badgeGreen},
+		{cov(0, 0), nil, "0%", badgeRed},
+	}
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		if err := tt.c.WriteBadgeSVG(&buf, tt.thresholds...); err != nil {
+			t.Fatal(err)
+		}
+		svg := buf.String()
+		if !strings.Contains(svg, ">"+tt.text+"</text>") || !strings.Contains(svg, `fill="`+tt.color+`"`) {
+			t.Errorf("%s with thresholds %v: badge\n%s\nwant text %q and color %s", tt.text, tt.thresholds, svg, tt.text, tt.color)
+		}
+		if err := xml.Unmarshal(buf.Bytes(), new(struct{})); err != nil {
+			t.Errorf("%s: badge is not valid XML: %v", tt.text, err)
+		}
+	}
+}
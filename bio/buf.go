@@ -7,8 +7,10 @@ package bio
 
 import (
 	"bufio"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"os"
 )
 
@@ -76,6 +78,9 @@ func (r *Reader) Slice(length uint64) ([]byte, bool, error) {
 		return data, true, nil
 	}
 
+	if length > math.MaxInt {
+		return nil, false, fmt.Errorf("slice of %d bytes too large for this platform", length)
+	}
 	data = make([]byte, length)
 	_, err := io.ReadFull(r, data)
 	if err != nil {
@@ -7,6 +7,7 @@
 package bio
 
 import (
+	"math"
 	"runtime"
 	"sync/atomic"
 	"syscall"
@@ -39,6 +40,11 @@ func (r *Reader) sliceOS(length uint64) ([]byte, bool) {
 	if length < threshold {
 		return nil, false
 	}
+	// Nor can we map more than the address space holds, as happens
+	// with large files on 32-bit platforms.
+	if length > math.MaxInt/2 {
+		return nil, false
+	}
 
 	// Have we reached the mmap limit?
 	if atomic.AddInt32(&mmapLimit, -1) < 0 {
@@ -0,0 +1,217 @@
+package gocov
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// This file contains helpers for caching the parsed meta-data of a
+// coverage pod on disk. The meta-data file hash uniquely identifies
+// the instrumented program, so the decoded package/function/unit
+// structure can be reused across runs; only the counter data files
+// (which change with every execution) need to be read again.
+
+// ReadDirCached is like ReadDir, but loads the meta-data structure of
+// each pod in 'dir' from 'cacheDir' when a cached copy keyed by the
+// meta-data hash is available, and stores it there otherwise.
+func ReadDirCached(dir, cacheDir string, matchPkgs []string) (*CoverageData, error) {
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+
+	podlist, err := collectPods(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading inputs: %v", err)
+	}
+	for _, p := range podlist {
+		if err := readPodCached(p, cacheDir, matchPkgs, data); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
+
+// readPodCached reads the pod 'p' into 'data', using the meta-data
+// structure cached in 'cacheDir' if there is one.
+func readPodCached(p Pod, cacheDir string, matchPkgs []string, data *CoverageData) error {
+	hash := p.hash()
+	if hash == "" {
+		// A meta-data file without a hash suffix is keyed by the hash
+		// it records, as ReadDir keys its pod. If the header can't be
+		// read, the file is left for readPodMeta to skip or reject.
+		if info, err := ReadMetaHeader(p.MetaFile); err == nil {
+			hash = hex.EncodeToString(info.Hash[:])
+		}
+	}
+	meta, err := loadCachedMeta(cacheDir, hash)
+	if err != nil {
+		return err
+	}
+	if meta == nil {
+		if meta, err = readPodMeta(p.MetaFile); err != nil {
+			return err
+		}
+		if meta == nil {
+			// The meta-data file was skipped, as ReadDir would skip
+			// it; there is nothing to cache.
+			return nil
+		}
+		if err := storeCachedMeta(cacheDir, hash, meta); err != nil {
+			return err
+		}
+	}
+
+	vis := &covDataVisitor{
+		cm:   &merger{},
+		data: data,
+	}
+	vis.BeginPod(p)
+	if err := vis.visitCachedMeta(hash, meta); err != nil {
+		return err
+	}
+	r := makeCovDataDirReader(vis, "", matchPkgs...)
+	for _, cdf := range p.CounterDataFiles {
+		if err := r.visitCounterDataFile(cdf); err != nil {
+			return err
+		}
+	}
+	vis.visitCachedPackages(meta, r.matchpkg)
+	data.stats.FilesRead += r.stats.FilesRead
+	data.stats.BytesRead += r.stats.BytesRead
+	return nil
+}
+
+// readPodMeta decodes the meta-data file 'metaFile' on its own,
+// returning the full (unfiltered) package structure with all counts
+// set to zero, or nil if the file is skipped (for example because it
+// is empty).
+func readPodMeta(metaFile string) (*PodData, error) {
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+	vis := &covDataVisitor{
+		cm:   &merger{},
+		data: data,
+	}
+	r := makeCovDataDirReader(vis, "")
+	if err := r.visitPod(Pod{MetaFile: metaFile}); err != nil {
+		return nil, err
+	}
+	return data.PodData[vis.podHash], nil
+}
+
+func cachedMetaPath(cacheDir, hash string) string {
+	return filepath.Join(cacheDir, metaFilePref+"."+hash+".json")
+}
+
+// loadCachedMeta returns the cached meta-data structure for the pod
+// with hash 'hash', or nil if there is no usable cache entry for it.
+// Entries without any package map, such as a "null" left by earlier
+// versions for skipped meta-data files, are treated as missing, so
+// that they are rebuilt.
+func loadCachedMeta(cacheDir, hash string) (*PodData, error) {
+	b, err := os.ReadFile(cachedMetaPath(cacheDir, hash))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("reading meta-data cache: %v", err)
+	}
+	meta := &PodData{}
+	if err := json.Unmarshal(b, meta); err != nil {
+		return nil, fmt.Errorf("decoding meta-data cache entry %s: %v", hash, err)
+	}
+	if meta.Packages == nil {
+		return nil, nil
+	}
+	return meta, nil
+}
+
+func storeCachedMeta(cacheDir, hash string, meta *PodData) error {
+	b, err := json.Marshal(meta)
+	if err != nil {
+		return fmt.Errorf("encoding meta-data cache entry %s: %v", hash, err)
+	}
+	if err := os.MkdirAll(cacheDir, 0o755); err != nil {
+		return fmt.Errorf("writing meta-data cache: %v", err)
+	}
+	// Write to a temporary file first so that concurrent readers never
+	// observe a partially written entry.
+	path := cachedMetaPath(cacheDir, hash)
+	tmp := fmt.Sprintf("%s.%d.tmp", path, os.Getpid())
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return fmt.Errorf("writing meta-data cache: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// visitCachedMeta is the cached counterpart of VisitMetaDataFile: it
+// sets up the pod and the legal package/function combinations from a
+// previously decoded meta-data structure.
+func (d *covDataVisitor) visitCachedMeta(hash string, meta *PodData) error {
+	d.podHash = hash
+	d.data.PodData[hash] = &PodData{
+		CounterGranularity: meta.CounterGranularity,
+		CounterMode:        meta.CounterMode,
+		Packages:           make(map[uint32]*Package),
+	}
+	if err := d.cm.SetModeAndGranularity(meta.CounterMode, meta.CounterGranularity); err != nil {
+		return err
+	}
+	d.pkm = make(map[uint32]uint32)
+	for pkIdx, pack := range meta.Packages {
+		d.pkm[pkIdx] = pack.NumFuncs
+	}
+	return nil
+}
+
+// visitCachedPackages is the cached counterpart of VisitMetaDataFile's
+// package setup and of the meta-data walk: it adds the packages of
+// 'meta' to the pod and hands the functions of those for which 'match'
+// is true to VisitFunc, so that counters are applied as for an
+// uncached read. As with ReadDir, the other packages are kept without
+// any functions.
+func (d *covDataVisitor) visitCachedPackages(meta *PodData, match func(path string) bool) {
+	podData := d.data.PodData[d.podHash]
+	for _, pkIdx := range sortedKeys(meta.Packages) {
+		pack := meta.Packages[pkIdx]
+		if !d.matchPkg(pack.ImportPath) {
+			continue
+		}
+		podData.Packages[pkIdx] = &Package{
+			ID:         pack.ID,
+			Name:       pack.Name,
+			ImportPath: pack.ImportPath,
+			ModulePath: pack.ModulePath,
+			NumFuncs:   pack.NumFuncs,
+			MetaHash:   pack.MetaHash,
+			Funcs:      make(map[uint32]*Func),
+		}
+		if !match(pack.ImportPath) {
+			continue
+		}
+		for _, fnIdx := range sortedKeys(pack.Funcs) {
+			fn := pack.Funcs[fnIdx]
+			fd := &funcDesc{
+				Funcname: fn.Name,
+				Srcfile:  fn.SrcFile,
+				Units:    make([]coverableUnit, len(fn.Units)),
+				Lit:      fn.Lit,
+			}
+			for i, u := range fn.Units {
+				fd.Units[i] = coverableUnit{
+					StLine:  u.StLine,
+					StCol:   u.StCol,
+					EnLine:  u.EnLine,
+					EnCol:   u.EnCol,
+					NxStmts: u.NxStmts,
+				}
+			}
+			d.VisitFunc(pkIdx, fnIdx, fd)
+		}
+	}
+}
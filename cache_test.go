@@ -0,0 +1,98 @@
+package gocov
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDirCachedMatchesReadDir(t *testing.T) {
+	for _, dir := range []string{setDir, countDir, variantDir} {
+		want := readTestDir(t, dir)
+		cacheDir := t.TempDir()
+		for _, pass := range []string{"miss", "hit"} {
+			got, err := ReadDirCached(dir, cacheDir, nil)
+			if err != nil {
+				t.Fatalf("%s %s: %v", dir, pass, err)
+			}
+			samePods(t, got, want)
+		}
+	}
+}
+
+func TestReadDirCachedHit(t *testing.T) {
+	dir := copyTestDir(t, setDir)
+	cacheDir := t.TempDir()
+	want, err := ReadDirCached(dir, cacheDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(cachedMetaPath(cacheDir, setHash)); err != nil {
+		t.Fatalf("no cache entry written: %v", err)
+	}
+
+	// With a cache entry in place the meta-data file isn't decoded, so
+	// a broken magic string in it goes unnoticed.
+	metaFile := filepath.Join(dir, metaFilePref+"."+setHash)
+	b, err := os.ReadFile(metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(b, "XXXX")
+	if err := os.WriteFile(metaFile, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDir(dir, nil); err == nil {
+		t.Fatal("ReadDir of a corrupt meta-data file succeeded")
+	}
+	got, err := ReadDirCached(dir, cacheDir, nil)
+	if err != nil {
+		t.Fatalf("cached read: %v", err)
+	}
+	samePods(t, got, want)
+}
+
+func TestReadDirCachedMatchPkgs(t *testing.T) {
+	cacheDir := t.TempDir()
+	// Fill the cache with the unfiltered structure, then read through
+	// it with a pattern.
+	if _, err := ReadDirCached(setDir, cacheDir, nil); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadDirCached(setDir, cacheDir, []string{"example.com/prog/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, setDir, "example.com/prog/lib"))
+}
+
+func TestReadDirCachedEmptyMeta(t *testing.T) {
+	dir := copyTestDir(t, setDir)
+	metaFile := filepath.Join(dir, metaFilePref+"."+setHash)
+	if err := os.WriteFile(metaFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	got, err := ReadDirCached(dir, cacheDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.PodData) != 0 {
+		t.Errorf("got %d pods for an empty meta-data file, want 0", len(got.PodData))
+	}
+	if _, err := os.Stat(cachedMetaPath(cacheDir, setHash)); !os.IsNotExist(err) {
+		t.Errorf("cache entry written for an empty meta-data file (stat: %v)", err)
+	}
+}
+
+func TestReadDirCachedNullEntry(t *testing.T) {
+	cacheDir := t.TempDir()
+	if err := os.WriteFile(cachedMetaPath(cacheDir, setHash), []byte("null"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadDirCached(setDir, cacheDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, setDir))
+}
@@ -52,6 +52,18 @@ func (m *merger) SaturatingAdd(dst, src uint32) uint32 {
 	return result
 }
 
+// saturatedUnits returns the indices of the counters in 'counts' that
+// have saturated at math.MaxUint32.
+func saturatedUnits(counts []uint32) []int {
+	var units []int
+	for i, c := range counts {
+		if c == math.MaxUint32 {
+			units = append(units, i)
+		}
+	}
+	return units
+}
+
 // Saturating add does a saturing addition of 'dst' and 'src',
 // returning added value or math.MaxUint32 plus an overflow flag.
 func saturatingAdd(dst, src uint32) (uint32, bool) {
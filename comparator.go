@@ -1,5 +1,7 @@
 package gocov
 
+import "sort"
+
 type funit struct {
 	stline uint32
 	enline uint32
@@ -38,13 +40,104 @@ func DiffLines(one, two *CoverageData) int {
 	return new
 }
 
+// Block is a source region corresponding to a single coverable unit.
+type Block struct {
+	File          string
+	StLine, StCol uint32
+	EnLine, EnCol uint32
+}
+
+type fileUnit struct {
+	file string
+	unit funit
+}
+
+// DiffBlocks returns the blocks that are covered in 'head' but were
+// not covered in 'base' (either because their count was zero or
+// because they did not exist), sorted by file and position.
+func DiffBlocks(base, head *CoverageData) []Block {
+	covered := make(map[fileUnit]bool)
+	for _, p := range base.PodData {
+		for _, pa := range p.Packages {
+			for _, f := range pa.Funcs {
+				for _, u := range f.Units {
+					key := fileUnit{f.SrcFile, funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}}
+					covered[key] = covered[key] || u.Covered()
+				}
+			}
+		}
+	}
+
+	blocks := []Block{}
+	for _, p := range head.PodData {
+		for _, pa := range p.Packages {
+			for _, f := range pa.Funcs {
+				for _, u := range f.Units {
+					key := fileUnit{f.SrcFile, funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}}
+					if !u.Covered() || covered[key] {
+						continue
+					}
+					covered[key] = true
+					blocks = append(blocks, Block{
+						File:   f.SrcFile,
+						StLine: u.StLine,
+						StCol:  u.StCol,
+						EnLine: u.EnLine,
+						EnCol:  u.EnCol,
+					})
+				}
+			}
+		}
+	}
+	sort.Slice(blocks, func(i, j int) bool {
+		bi, bj := blocks[i], blocks[j]
+		if bi.File != bj.File {
+			return bi.File < bj.File
+		}
+		if bi.StLine != bj.StLine {
+			return bi.StLine < bj.StLine
+		}
+		return bi.StCol < bj.StCol
+	})
+	return blocks
+}
+
+// newlyCovered returns the parts of 'head' that are covered but were
+// not covered in 'base': only the units with a non-zero count in
+// 'head' and no non-zero count for the same file and position in
+// 'base' are kept, along with the functions, packages and pods that
+// contain them.
+func newlyCovered(base, head *CoverageData) *CoverageData {
+	covered := make(map[fileUnit]bool)
+	for _, p := range base.PodData {
+		for _, pa := range p.Packages {
+			for _, f := range pa.Funcs {
+				for _, u := range f.Units {
+					key := fileUnit{f.SrcFile, funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}}
+					covered[key] = covered[key] || u.Covered()
+				}
+			}
+		}
+	}
+
+	return head.filter(func(pack *Package, fn *Func, u *FuncUnit) bool {
+		key := fileUnit{fn.SrcFile, funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}}
+		return u.Covered() && !covered[key]
+	})
+}
+
 type mcount struct {
-	cur uint32
-	new uint32
-	idx int
+	cur  uint32
+	new  uint32
+	runs uint32
+	idx  int
 }
 
 func (cur *CoverageData) Merge(other *CoverageData) {
+	if other.overflowed {
+		cur.overflowed = true
+	}
+	cur.overflows = append(cur.overflows, other.overflows...)
 	for pName, p := range other.PodData {
 		if _, ok := cur.PodData[pName]; !ok {
 			cur.PodData[pName] = p
@@ -61,20 +154,46 @@ func (cur *CoverageData) Merge(other *CoverageData) {
 					continue
 				}
 				curUnits := cur.PodData[pName].Packages[packName].Funcs[fName].Units
+
+				m := &merger{}
+				m.SetModeAndGranularity(p.CounterMode, p.CounterGranularity)
+
+				// Fast path: counter data from the same binary has
+				// identical unit sets, so the counters can be merged in
+				// order without reconciling units.
+				if sameUnits(curUnits, f.Units) {
+					curCount := make([]uint32, len(curUnits))
+					newCount := make([]uint32, len(f.Units))
+					for i := range curUnits {
+						curCount[i] = curUnits[i].Count
+						newCount[i] = f.Units[i].Count
+					}
+					if _, ovf := m.MergeCounters(curCount, newCount); ovf {
+						cur.overflowed = true
+						cur.addOverflows(pack.ImportPath, f.Name, curCount)
+					}
+					for i, u := range curUnits {
+						u.Count = curCount[i]
+						u.RunsCovered += f.Units[i].RunsCovered
+					}
+					continue
+				}
+
 				unitMap := make(map[funit]*mcount)
 
 				for _, u := range curUnits {
 					uKey := funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}
-					unitMap[uKey] = &mcount{cur: u.Count}
+					unitMap[uKey] = &mcount{cur: u.Count, runs: u.RunsCovered}
 				}
 
 				for _, u := range f.Units {
 					uKey := funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}
 					count, ok := unitMap[uKey]
 					if !ok {
-						unitMap[uKey] = &mcount{new: u.Count}
+						unitMap[uKey] = &mcount{new: u.Count, runs: u.RunsCovered}
 					} else {
 						count.new = u.Count
+						count.runs += u.RunsCovered
 					}
 				}
 
@@ -88,22 +207,394 @@ func (cur *CoverageData) Merge(other *CoverageData) {
 					i += 1
 				}
 
-				m := &merger{}
-				m.SetModeAndGranularity(p.CounterMode, p.CounterGranularity)
-				m.MergeCounters(curCount, newCount)
+				if _, ovf := m.MergeCounters(curCount, newCount); ovf {
+					cur.overflowed = true
+					cur.addOverflows(pack.ImportPath, f.Name, curCount)
+				}
 
 				cur.PodData[pName].Packages[packName].Funcs[fName].Units = make([]*FuncUnit, len(unitMap))
 				for key, count := range unitMap {
 					cur.PodData[pName].Packages[packName].Funcs[fName].Units[count.idx] = &FuncUnit{
-						StLine:  key.stline,
-						StCol:   key.stcol,
-						EnLine:  key.enline,
-						EnCol:   key.encol,
-						NxStmts: key.nstmts,
-						Count:   curCount[count.idx],
+						StLine:      key.stline,
+						StCol:       key.stcol,
+						EnLine:      key.enline,
+						EnCol:       key.encol,
+						NxStmts:     key.nstmts,
+						Count:       curCount[count.idx],
+						RunsCovered: count.runs,
 					}
 				}
 			}
 		}
 	}
 }
+
+// MergeByPackageHash merges 'other' into 'cur' like Merge, except that
+// a package of 'other' is merged into the package of any pod of 'cur'
+// with the same package meta-data hash, rather than only into the
+// same package of the same pod. A package recompiled unchanged, whose
+// binary differs only in other packages, thus has its counters merged
+// across builds. A package present in several pods of 'cur' is merged
+// into the one of the pod with the same hash as the pod it comes from,
+// if any, and otherwise into the first in hash order. Packages are only matched
+// between pods of the same counter mode and granularity; the others
+// are merged as by Merge. The packages of 'other' are copied, so
+// 'other' is left untouched.
+func (cur *CoverageData) MergeByPackageHash(other *CoverageData) {
+	// Merge one pod at a time, so that packages merged from a pod can
+	// be matched by the later ones.
+	for i, hash := range sortedKeys(other.PodData) {
+		p := other.PodData[hash]
+		remapped := &CoverageData{
+			PodData: make(map[string]*PodData),
+		}
+		if i == 0 {
+			remapped.overflowed = other.overflowed
+			remapped.overflows = other.overflows
+		}
+		// Move the matching packages of the pod to the pods and
+		// indices of their counterparts, so that Merge lines them up.
+		byHash := cur.packagesByHash()
+		for pkIdx, pack := range p.Packages {
+			dst, found := pkgRef{hash, pkIdx}, false
+			for _, ref := range byHash[pack.MetaHash] {
+				target := cur.PodData[ref.pod]
+				if target.CounterMode != p.CounterMode || target.CounterGranularity != p.CounterGranularity {
+					continue
+				}
+				if !found || ref.pod == hash {
+					dst, found = ref, true
+				}
+				if ref.pod == hash {
+					break
+				}
+			}
+			rp, ok := remapped.PodData[dst.pod]
+			if !ok {
+				rp = &PodData{
+					CounterGranularity: p.CounterGranularity,
+					CounterMode:        p.CounterMode,
+					Packages:           make(map[uint32]*Package),
+					Provenance:         p.Provenance,
+				}
+				remapped.PodData[dst.pod] = rp
+			}
+			moved := pack.clone()
+			moved.ID = dst.pkIdx
+			rp.Packages[dst.pkIdx] = moved
+		}
+		cur.Merge(remapped)
+	}
+}
+
+// pkgRef locates a package within coverage data.
+type pkgRef struct {
+	pod   string
+	pkIdx uint32
+}
+
+// packagesByHash returns the packages of 'c' by meta-data hash, in pod
+// hash order for packages present in several pods.
+func (c *CoverageData) packagesByHash() map[[16]byte][]pkgRef {
+	byHash := make(map[[16]byte][]pkgRef)
+	for _, hash := range sortedKeys(c.PodData) {
+		for _, pkIdx := range sortedKeys(c.PodData[hash].Packages) {
+			mh := c.PodData[hash].Packages[pkIdx].MetaHash
+			if mh != ([16]byte{}) {
+				byHash[mh] = append(byHash[mh], pkgRef{hash, pkIdx})
+			}
+		}
+	}
+	return byHash
+}
+
+// clone returns a deep copy of the package.
+func (p *Package) clone() *Package {
+	out := *p
+	out.Funcs = make(map[uint32]*Func, len(p.Funcs))
+	for fnIdx, fn := range p.Funcs {
+		out.Funcs[fnIdx] = fn.clone()
+	}
+	return &out
+}
+
+// clone returns a deep copy of the function.
+func (f *Func) clone() *Func {
+	out := *f
+	out.Units = make([]*FuncUnit, len(f.Units))
+	for i, u := range f.Units {
+		unit := *u
+		out.Units[i] = &unit
+	}
+	return &out
+}
+
+// addOverflows records an overflow site for every saturated counter in
+// 'counts', the merged counters of function 'funcName'.
+func (cur *CoverageData) addOverflows(importPath, funcName string, counts []uint32) {
+	for _, i := range saturatedUnits(counts) {
+		cur.overflows = append(cur.overflows, OverflowSite{
+			ImportPath: importPath,
+			FuncName:   funcName,
+			Unit:       i,
+		})
+	}
+}
+
+// sameUnits reports whether 'a' and 'b' describe the same units in the
+// same order, ignoring counter values.
+func sameUnits(a, b []*FuncUnit) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		ua, ub := a[i], b[i]
+		if (funit{ua.StLine, ua.EnLine, ua.StCol, ua.EnCol, ua.NxStmts}) !=
+			(funit{ub.StLine, ub.EnLine, ub.StCol, ub.EnCol, ub.NxStmts}) {
+			return false
+		}
+	}
+	return true
+}
+
+// MergeUnion merges 'other' into 'cur' as set-mode data: every count of
+// the result is 1 if the unit was executed in either, and 0 otherwise,
+// and all pods of 'cur' are marked as set mode. This is cheaper than
+// Merge and sufficient when only union coverage is of interest.
+func (cur *CoverageData) MergeUnion(other *CoverageData) {
+	// Counts of set mode pods are already 0 or 1, so folding many runs
+	// into 'cur' only clamps each pod once.
+	for _, p := range cur.PodData {
+		if p.CounterMode == CtrModeSet {
+			continue
+		}
+		p.CounterMode = CtrModeSet
+		for _, pack := range p.Packages {
+			for _, f := range pack.Funcs {
+				for _, u := range f.Units {
+					u.Count = setCount(u.Count)
+				}
+			}
+		}
+	}
+	for pName, p := range other.PodData {
+		curPod, ok := cur.PodData[pName]
+		if !ok {
+			curPod = &PodData{
+				CounterGranularity: p.CounterGranularity,
+				CounterMode:        CtrModeSet,
+				Packages:           make(map[uint32]*Package),
+				Provenance:         p.Provenance,
+			}
+			cur.PodData[pName] = curPod
+		}
+		for packName, pack := range p.Packages {
+			curPack, ok := curPod.Packages[packName]
+			if !ok {
+				curPack = &Package{
+					ID:         pack.ID,
+					Name:       pack.Name,
+					ImportPath: pack.ImportPath,
+					ModulePath: pack.ModulePath,
+					NumFuncs:   pack.NumFuncs,
+					MetaHash:   pack.MetaHash,
+					Funcs:      make(map[uint32]*Func),
+				}
+				curPod.Packages[packName] = curPack
+			}
+			for fName, f := range pack.Funcs {
+				curFunc, ok := curPack.Funcs[fName]
+				if !ok {
+					curFunc = &Func{
+						Name:         f.Name,
+						SrcFile:      f.SrcFile,
+						Units:        make([]*FuncUnit, len(f.Units)),
+						Lit:          f.Lit,
+						Instrumented: f.Instrumented,
+						SrcHash:      f.SrcHash,
+					}
+					for i, u := range f.Units {
+						nu := *u
+						nu.Count = setCount(u.Count)
+						curFunc.Units[i] = &nu
+					}
+					curPack.Funcs[fName] = curFunc
+					continue
+				}
+				if sameUnits(curFunc.Units, f.Units) {
+					for i, u := range curFunc.Units {
+						u.Count |= setCount(f.Units[i].Count)
+						u.RunsCovered += f.Units[i].RunsCovered
+					}
+					continue
+				}
+				units := make(map[funit]*FuncUnit, len(curFunc.Units))
+				for _, u := range curFunc.Units {
+					units[funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}] = u
+				}
+				for _, u := range f.Units {
+					key := funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}
+					if cu, ok := units[key]; ok {
+						cu.Count |= setCount(u.Count)
+						cu.RunsCovered += u.RunsCovered
+						continue
+					}
+					nu := *u
+					nu.Count = setCount(u.Count)
+					curFunc.Units = append(curFunc.Units, &nu)
+				}
+			}
+		}
+	}
+}
+
+func setCount(count uint32) uint32 {
+	if count != 0 {
+		return 1
+	}
+	return 0
+}
+
+// flatPod is the key of the single pod of coverage data flattened by
+// FlattenPods.
+const flatPod = "flat"
+
+// flatPackage tracks a package of the flattened pod: its index, and the
+// indices given to its functions.
+type flatPackage struct {
+	id       uint32
+	metaHash [16]byte
+	numFuncs uint32
+	funcs    map[flatFunc]uint32
+}
+
+// flatFunc identifies a function across the pods being flattened.
+type flatFunc struct {
+	srcFile, name string
+	line          uint32
+}
+
+// FlattenPods returns a copy of the coverage with all pods folded into
+// a single pod, keyed "flat", for reports that don't care which binary
+// covered what. Packages present in several pods are matched by import
+// path, and their functions by source file, name and start line, and
+// their counters are merged as by Merge; functions are renumbered in
+// the process. It fails if the pods don't share the counter mode and
+// granularity. Only the provenance entries on which all pods agree are
+// kept, and a package keeps its meta-data hash only if all pods agree
+// on it.
+func (c *Coverage) FlattenPods() (*Coverage, error) {
+	mode, gran, err := c.Mode()
+	if err != nil {
+		return nil, err
+	}
+	data := &CoverageData{
+		PodData:    make(map[string]*PodData),
+		overflowed: c.Data.overflowed,
+		overflows:  append([]OverflowSite(nil), c.Data.overflows...),
+		stats:      c.Data.stats,
+	}
+	packs := make(map[string]*flatPackage)
+	var provenance map[string]string
+	for i, hash := range sortedKeys(c.Data.PodData) {
+		p := c.Data.PodData[hash]
+		if i == 0 {
+			for k, v := range p.Provenance {
+				if provenance == nil {
+					provenance = make(map[string]string)
+				}
+				provenance[k] = v
+			}
+		} else {
+			for k, v := range provenance {
+				if pv, ok := p.Provenance[k]; !ok || pv != v {
+					delete(provenance, k)
+				}
+			}
+		}
+
+		// Renumber the packages and functions of the pod to those of
+		// the flattened pod, copying them so that merging leaves 'c'
+		// untouched.
+		pod := &PodData{
+			CounterGranularity: gran,
+			CounterMode:        mode,
+			Packages:           make(map[uint32]*Package),
+		}
+		for _, pkIdx := range sortedKeys(p.Packages) {
+			pack := p.Packages[pkIdx]
+			fp, ok := packs[pack.ImportPath]
+			if !ok {
+				fp = &flatPackage{
+					id:       uint32(len(packs)),
+					metaHash: pack.MetaHash,
+					funcs:    make(map[flatFunc]uint32),
+				}
+				packs[pack.ImportPath] = fp
+			} else if fp.metaHash != pack.MetaHash {
+				fp.metaHash = [16]byte{}
+			}
+			moved := &Package{
+				ID:         fp.id,
+				Name:       pack.Name,
+				ImportPath: pack.ImportPath,
+				ModulePath: pack.ModulePath,
+				Funcs:      make(map[uint32]*Func),
+			}
+			for _, fnIdx := range sortedKeys(pack.Funcs) {
+				fn := pack.Funcs[fnIdx]
+				key := flatFunc{fn.SrcFile, fn.Name, fn.StartLine()}
+				idx, ok := fp.funcs[key]
+				if _, taken := moved.Funcs[idx]; !ok || taken {
+					idx = fp.numFuncs
+					fp.numFuncs++
+					fp.funcs[key] = idx
+				}
+				moved.Funcs[idx] = fn.clone()
+			}
+			pod.Packages[fp.id] = moved
+		}
+		data.Merge(&CoverageData{PodData: map[string]*PodData{flatPod: pod}})
+	}
+
+	if flat, ok := data.PodData[flatPod]; ok {
+		flat.Provenance = provenance
+		for _, fp := range packs {
+			if pack, ok := flat.Packages[fp.id]; ok {
+				pack.NumFuncs = fp.numFuncs
+				pack.MetaHash = fp.metaHash
+			}
+		}
+	}
+	return &Coverage{
+		config: c.config,
+		Data:   data,
+	}, nil
+}
+
+// PercentDelta returns the change in statement coverage percentage
+// from 'base' to 'head', e.g. 1.3 for an increase of 1.3 points. Data
+// without any statements, such as a base that predates a new package,
+// counts as 0% rather than NaN.
+func PercentDelta(base, head *Coverage) float64 {
+	bc, bt := base.stmtCoverage()
+	hc, ht := head.stmtCoverage()
+	return stmtCount{hc, ht}.percent() - stmtCount{bc, bt}.percent()
+}
+
+// DiffPercent is like PercentDelta, with both 'base' and 'head' first
+// restricted to the packages that match the patterns in 'matchPkgs'
+// (all packages if there are none). A side without any matching
+// package counts as 0%, so a package new in 'head' shows as a delta
+// from 0.
+func DiffPercent(base, head *Coverage, matchPkgs []string) float64 {
+	restrict := func(c *Coverage) *Coverage {
+		return &Coverage{
+			config: c.config,
+			Data: c.Data.filter(func(pack *Package, fn *Func, u *FuncUnit) bool {
+				return len(matchPkgs) == 0 || matchAnyPattern(matchPkgs, pack.ImportPath)
+			}),
+		}
+	}
+	return PercentDelta(restrict(base), restrict(head))
+}
@@ -0,0 +1,441 @@
+package gocov
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeSameBinaryCount(t *testing.T) {
+	cur := readTestDir(t, countDir)
+	other := readTestDir(t, countDir)
+	want := readTestDir(t, countDir)
+	cur.Merge(other)
+	for _, pack := range want.PodData[countHash].Packages {
+		for _, fn := range pack.Funcs {
+			got := findFunc(cur, countHash, pack.ImportPath, fn.Name)
+			for i, u := range fn.Units {
+				if gu := got.Units[i]; gu.Count != 2*u.Count || gu.RunsCovered != 2*u.RunsCovered {
+					t.Errorf("%s unit %d: got count %d runs %d, want %d and %d",
+						fn.Name, i, gu.Count, gu.RunsCovered, 2*u.Count, 2*u.RunsCovered)
+				}
+			}
+		}
+	}
+}
+
+func TestMergeSameBinarySet(t *testing.T) {
+	cur := readTestDir(t, setDir)
+	want := readTestDir(t, setDir)
+	cur.Merge(readTestDir(t, setDir))
+	for _, pack := range want.PodData[setHash].Packages {
+		for _, fn := range pack.Funcs {
+			got := findFunc(cur, setHash, pack.ImportPath, fn.Name)
+			if fmt.Sprint(unitCounts(got)) != fmt.Sprint(unitCounts(fn)) {
+				t.Errorf("%s: got counts %v, want %v", fn.Name, unitCounts(got), unitCounts(fn))
+			}
+		}
+	}
+}
+
+func TestMergeReorderedUnits(t *testing.T) {
+	cur := readTestDir(t, countDir)
+	other := readTestDir(t, countDir)
+	// Reversing the units of 'other' takes the merge off the in-order
+	// path; units still pair up by position.
+	fn := findFunc(other, countHash, "example.com/prog/lib", "Add")
+	for i, j := 0, len(fn.Units)-1; i < j; i, j = i+1, j-1 {
+		fn.Units[i], fn.Units[j] = fn.Units[j], fn.Units[i]
+	}
+	want := map[funit]uint32{}
+	for _, u := range findFunc(readTestDir(t, countDir), countHash, "example.com/prog/lib", "Add").Units {
+		want[funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}] = 2 * u.Count
+	}
+	cur.Merge(other)
+	got := findFunc(cur, countHash, "example.com/prog/lib", "Add")
+	if len(got.Units) != len(want) {
+		t.Fatalf("got %d units, want %d", len(got.Units), len(want))
+	}
+	for _, u := range got.Units {
+		key := funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}
+		if u.Count != want[key] {
+			t.Errorf("unit %v: got count %d, want %d", key, u.Count, want[key])
+		}
+	}
+}
+
+func TestSameUnits(t *testing.T) {
+	a := findFunc(readTestDir(t, setDir), setHash, "example.com/prog/lib", "Add").Units
+	b := findFunc(readTestDir(t, countDir), countHash, "example.com/prog/lib", "Add").Units
+	if !sameUnits(a, b) {
+		t.Error("sameUnits of the same function in set and count mode = false, want true")
+	}
+	if sameUnits(a, b[:2]) {
+		t.Error("sameUnits with a unit missing = true, want false")
+	}
+	c := append([]*FuncUnit{b[1], b[0]}, b[2:]...)
+	if sameUnits(a, c) {
+		t.Error("sameUnits with units swapped = true, want false")
+	}
+}
+
+// benchData returns count mode data for a single pod with 'nfuncs'
+// functions of 'nunits' units each.
+func benchData(nfuncs, nunits int) *CoverageData {
+	pack := &Package{ImportPath: "example.com/bench", Funcs: make(map[uint32]*Func)}
+	for f := 0; f < nfuncs; f++ {
+		fn := &Func{Name: fmt.Sprintf("F%d", f), SrcFile: "bench.go"}
+		for u := 0; u < nunits; u++ {
+			line := uint32(f*nunits + u + 1)
+			fn.Units = append(fn.Units, &FuncUnit{StLine: line, StCol: 1, EnLine: line, EnCol: 10, NxStmts: 1, Count: uint32(u)})
+		}
+		pack.Funcs[uint32(f)] = fn
+	}
+	return &CoverageData{PodData: map[string]*PodData{
+		"bench": {CounterMode: CtrModeCount, CounterGranularity: CtrGranularityPerBlock, Packages: map[uint32]*Package{0: pack}},
+	}}
+}
+
+func BenchmarkMergeSameBinary(b *testing.B) {
+	cur := benchData(1000, 10)
+	other := benchData(1000, 10)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cur.Merge(other)
+	}
+}
+
+func BenchmarkMergeReconciledUnits(b *testing.B) {
+	cur := benchData(1000, 10)
+	other := benchData(1000, 10)
+	// Rotating the units defeats the in-order path.
+	for _, fn := range other.PodData["bench"].Packages[0].Funcs {
+		fn.Units = append(fn.Units[1:], fn.Units[0])
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		cur.Merge(other)
+	}
+}
+
+func TestMergeUnion(t *testing.T) {
+	cur := readTestDir(t, countDir)
+	other := readTestDir(t, countDir)
+	// A unit run only by 'other'.
+	findFunc(other, countHash, "example.com/prog/lib", "unused").Units[1].Count = 7
+	cur.MergeUnion(other)
+	cur.MergeUnion(readTestDir(t, setDir))
+
+	if p := cur.PodData[countHash]; p.CounterMode != CtrModeSet {
+		t.Errorf("merged pod has mode %s, want set", p.CounterMode)
+	}
+	tests := []struct {
+		fn   string
+		want []uint32
+	}{
+		{"Add", []uint32{1, 1, 0}},
+		{"unused", []uint32{0, 1, 0}},
+		{"*T.Method", []uint32{1, 1, 1}},
+	}
+	for _, tt := range tests {
+		if got := unitCounts(findFunc(cur, countHash, "example.com/prog/lib", tt.fn)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: counts %v, want %v", tt.fn, got, tt.want)
+		}
+	}
+	// A pod only 'other' has is added.
+	if got, want := unitCounts(findFunc(cur, setHash, "example.com/prog/lib", "Add")), []uint32{1, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("added pod: Add counts %v, want %v", got, want)
+	}
+
+	// Pods already in set mode aren't walked again, only the units
+	// merged from 'other' are.
+	cur = readTestDir(t, setDir)
+	findFunc(cur, setHash, "example.com/prog/lib", "unused").Units[0].Count = 3
+	other = readTestDir(t, countDir)
+	cur.MergeUnion(other)
+	if got := findFunc(cur, setHash, "example.com/prog/lib", "unused").Units[0].Count; got != 3 {
+		t.Errorf("set mode pod was clamped again: count %d, want 3", got)
+	}
+	if got, want := unitCounts(findFunc(cur, countHash, "example.com/prog/lib", "Add")), []uint32{1, 1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("count pod merged into set data: Add counts %v, want %v", got, want)
+	}
+}
+
+// BenchmarkMergeUnion compares MergeUnion with Merge on count mode data
+// of many runs of the same binary.
+func BenchmarkMergeUnion(b *testing.B) {
+	runs := make([]*CoverageData, 100)
+	for i := range runs {
+		runs[i] = benchData(1000, 10)
+	}
+	merges := map[string]func(cur, other *CoverageData){
+		"Merge":      (*CoverageData).Merge,
+		"MergeUnion": (*CoverageData).MergeUnion,
+	}
+	for _, name := range []string{"Merge", "MergeUnion"} {
+		merge := merges[name]
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				cur := benchData(1000, 10)
+				b.StartTimer()
+				for _, other := range runs {
+					merge(cur, other)
+				}
+			}
+		})
+	}
+}
+
+func TestDiffBlocks(t *testing.T) {
+	// The variant run had no arguments, so it missed what the set run
+	// with arguments covered.
+	got := DiffBlocks(readTestDir(t, variantDir), readTestDir(t, setDir))
+	want := []Block{
+		{File: "example.com/prog/lib/lib.go", StLine: 20, StCol: 2, EnLine: 20, EnCol: 18},
+		{File: "example.com/prog/lib/lib.go", StLine: 20, StCol: 20, EnLine: 20, EnCol: 30},
+		{File: "example.com/prog/lib/lib.go", StLine: 21, StCol: 2, EnLine: 21, EnCol: 12},
+		{File: "example.com/prog/main.go", StLine: 14, StCol: 3, EnLine: 16, EnCol: 1},
+		{File: "example.com/prog/util/util.go", StLine: 3, StCol: 23, EnLine: 3, EnCol: 39},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("DiffBlocks = %v, want %v", got, want)
+	}
+
+	if got := DiffBlocks(readTestDir(t, setDir), readTestDir(t, variantDir)); len(got) != 0 {
+		t.Errorf("DiffBlocks against a superset = %v, want none", got)
+	}
+}
+
+func TestDiffBlocksEmptyBase(t *testing.T) {
+	head := readTestDir(t, setDir)
+	got := DiffBlocks(&CoverageData{}, head)
+	covered := 0
+	for _, p := range head.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				for _, u := range fn.Units {
+					if u.Covered() {
+						covered++
+					}
+				}
+			}
+		}
+	}
+	if len(got) != covered {
+		t.Errorf("DiffBlocks against empty data = %d blocks, want all %d covered units", len(got), covered)
+	}
+}
+
+func TestPercentDelta(t *testing.T) {
+	// cov returns coverage data of a package with one single statement
+	// unit per count in 'counts', or no data at all if there are none.
+	cov := func(counts ...uint32) *Coverage {
+		data := &CoverageData{PodData: make(map[string]*PodData)}
+		if len(counts) != 0 {
+			lines := make([]uint32, len(counts))
+			for i := range lines {
+				lines[i] = uint32(3 + i)
+			}
+			data.PodData["pod"] = &PodData{
+				CounterMode:        CtrModeSet,
+				CounterGranularity: CtrGranularityPerBlock,
+				Packages: map[uint32]*Package{
+					0: testPackage("example.com/test/p", testFunc("F", "p.go", lines, counts)),
+				},
+			}
+		}
+		return &Coverage{Data: data}
+	}
+	tests := []struct {
+		name       string
+		base, head *Coverage
+		want       float64
+	}{
+		{"increase", cov(1, 0, 0, 0), cov(1, 1, 1, 0), 50},
+		{"decrease", cov(1, 1, 1, 0), cov(1, 0, 0, 0), -50},
+		{"unchanged", cov(1, 0), cov(0, 1), 0},
+		{"new package", cov(), cov(1, 1, 1, 0), 75},
+		{"removed package", cov(1, 1, 1, 0), cov(), -75},
+		{"empty", cov(), cov(), 0},
+	}
+	for _, tt := range tests {
+		if got := PercentDelta(tt.base, tt.head); got != tt.want {
+			t.Errorf("%s: PercentDelta = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDiffPercent(t *testing.T) {
+	cov := func(packs ...*Package) *Coverage {
+		pod := &PodData{
+			CounterMode:        CtrModeSet,
+			CounterGranularity: CtrGranularityPerBlock,
+			Packages:           make(map[uint32]*Package),
+		}
+		for i, pack := range packs {
+			pod.Packages[uint32(i)] = pack
+		}
+		return &Coverage{Data: &CoverageData{PodData: map[string]*PodData{"pod": pod}}}
+	}
+	base := cov(
+		testPackage("example.com/test/internal/a", testFunc("A", "a.go", []uint32{3, 4}, []uint32{1, 0})),
+		testPackage("example.com/test/cmd", testFunc("C", "c.go", []uint32{3, 4, 5, 6}, []uint32{1, 1, 1, 1})),
+	)
+	head := cov(
+		testPackage("example.com/test/internal/a", testFunc("A", "a.go", []uint32{3, 4}, []uint32{1, 1})),
+		testPackage("example.com/test/internal/b", testFunc("B", "b.go", []uint32{3, 4, 5, 6, 7, 8}, []uint32{1, 1, 1, 1, 0, 0})),
+		testPackage("example.com/test/cmd", testFunc("C", "c.go", []uint32{3, 4, 5, 6}, []uint32{0, 0, 0, 0})),
+	)
+	tests := []struct {
+		name      string
+		matchPkgs []string
+		want      float64
+	}{
+		// internal/b is only in head, so its delta is from 0%.
+		{"head only", []string{"example.com/test/internal/b"}, 200.0 / 3},
+		{"internal", []string{"example.com/test/internal/..."}, 25},
+		{"base and head", []string{"example.com/test/cmd"}, -100},
+		{"no match", []string{"example.com/nomatch"}, 0},
+		{"all", nil, PercentDelta(base, head)},
+	}
+	for _, tt := range tests {
+		if got := DiffPercent(base, head, tt.matchPkgs); got != tt.want {
+			t.Errorf("%s: DiffPercent(%q) = %v, want %v", tt.name, tt.matchPkgs, got, tt.want)
+		}
+	}
+	// The inputs are left as they were.
+	if got := len(head.Data.PodData["pod"].Packages); got != 3 {
+		t.Errorf("head has %d packages after DiffPercent, want 3", got)
+	}
+}
+
+func TestMergeByPackageHash(t *testing.T) {
+	// The set and variant builds differ only in package util, so lib
+	// and the main package are merged into the pod of the set build.
+	cur := readTestDir(t, setDir)
+	other := readTestDir(t, variantDir)
+	findFunc(other, variantHash, "example.com/prog/lib", "u.Exp").Units[0].Count = 1
+	cur.MergeByPackageHash(other)
+
+	if n := len(cur.PodData[setHash].Packages); n != 3 {
+		t.Errorf("set pod has %d packages, want 3", n)
+	}
+	vp := cur.PodData[variantHash]
+	if vp == nil || len(vp.Packages) != 1 || vp.Packages[1] == nil || vp.Packages[1].ImportPath != "example.com/prog/util" {
+		t.Fatalf("variant pod is %+v, want only package util", vp)
+	}
+	if fn := findFunc(cur, setHash, "example.com/prog/lib", "u.Exp"); !reflect.DeepEqual(unitCounts(fn), []uint32{1}) {
+		t.Errorf("u.Exp counts %v, want [1] from the variant", unitCounts(fn))
+	}
+	if fn := findFunc(cur, variantHash, "example.com/prog/util", "Also"); fn == nil {
+		t.Error("Also of the variant's util is missing")
+	}
+	// 'other' is copied, not shared.
+	findFunc(cur, variantHash, "example.com/prog/util", "Never").Units[0].Count = 7
+	if fn := findFunc(other, variantHash, "example.com/prog/util", "Never"); fn.Units[0].Count != 0 {
+		t.Error("merging shares units with the merged data")
+	}
+	if len(other.PodData[variantHash].Packages) != 3 {
+		t.Error("merging changed the merged data")
+	}
+
+	// Where several pods hold the package, the one of the same pod is
+	// preferred over the first in hash order, the variant's.
+	cur = readTestDir(t, variantDir)
+	cur.MergeUnion(readTestDir(t, setDir))
+	set := readTestDir(t, setDir)
+	findFunc(set, setHash, "example.com/prog/lib", "u.Exp").Units[0].Count = 1
+	cur.MergeByPackageHash(set)
+	if fn := findFunc(cur, setHash, "example.com/prog/lib", "u.Exp"); fn.Units[0].Count != 1 {
+		t.Error("lib of the set build wasn't merged into the set pod")
+	}
+	if fn := findFunc(cur, variantHash, "example.com/prog/lib", "u.Exp"); fn.Units[0].Count != 0 {
+		t.Error("lib of the set build was merged into the variant pod")
+	}
+
+	// Pods of another counter mode are left apart.
+	cur = readTestDir(t, countDir)
+	cur.MergeByPackageHash(readTestDir(t, setDir))
+	if len(cur.PodData) != 2 || len(cur.PodData[setHash].Packages) != 3 {
+		t.Errorf("count and set pods merged by package: %d pods", len(cur.PodData))
+	}
+	samePods(t, &CoverageData{PodData: map[string]*PodData{countHash: cur.PodData[countHash]}}, readTestDir(t, countDir))
+}
+
+func TestFlattenPods(t *testing.T) {
+	// Both pods record the same build tags but differing GOFLAGS.
+	dir := mergeTestDirs(t, setDir, variantDir)
+	for i, hash := range []string{setHash, variantHash} {
+		writeCounterFile(t, dir, hash, i+1, map[string]string{
+			"buildtags": "foo",
+			"GOFLAGS":   fmt.Sprintf("-count=%d", i),
+		}, nil)
+	}
+	c := readTestCoverage(t, dir)
+	flat, err := c.FlattenPods()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(flat.Data.PodData) != 1 || flat.Data.PodData["flat"] == nil {
+		t.Fatalf("flattened pods %v, want only the pod flat", sortedKeys(flat.Data.PodData))
+	}
+	pod := flat.Data.PodData["flat"]
+	if pod.CounterMode != CtrModeSet || pod.CounterGranularity != CtrGranularityPerBlock {
+		t.Errorf("flat pod has mode %s, granularity %s; want set, perblock", pod.CounterMode, pod.CounterGranularity)
+	}
+	if want := map[string]string{"buildtags": "foo"}; !reflect.DeepEqual(pod.Provenance, want) {
+		t.Errorf("flat pod provenance %v, want %v", pod.Provenance, want)
+	}
+
+	// Counters of the packages in both pods are merged, and util has
+	// the functions of both builds.
+	tests := []struct {
+		pkg, name string
+		want      []uint32
+	}{
+		{"example.com/prog", "main", []uint32{1, 1}},
+		{"example.com/prog/lib", "*T.Method", []uint32{1, 1, 1}},
+		{"example.com/prog/lib", "Add", []uint32{1, 1, 0}},
+		{"example.com/prog/util", "Never", []uint32{1}},
+		{"example.com/prog/util", "Also", []uint32{0}},
+	}
+	for _, tt := range tests {
+		fn := findFunc(flat.Data, "flat", tt.pkg, tt.name)
+		if fn == nil {
+			t.Errorf("%s.%s missing", tt.pkg, tt.name)
+			continue
+		}
+		if got := unitCounts(fn); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s.%s counts %v, want %v", tt.pkg, tt.name, got, tt.want)
+		}
+	}
+	if len(pod.Packages) != 3 {
+		t.Errorf("flat pod has %d packages, want 3", len(pod.Packages))
+	}
+	for _, pack := range pod.Packages {
+		if int(pack.NumFuncs) != len(pack.Funcs) {
+			t.Errorf("%s: NumFuncs %d, but %d functions", pack.ImportPath, pack.NumFuncs, len(pack.Funcs))
+		}
+		// Only util differs between the builds.
+		if zero := pack.MetaHash == ([16]byte{}); zero != (pack.ImportPath == "example.com/prog/util") {
+			t.Errorf("%s: MetaHash %x", pack.ImportPath, pack.MetaHash)
+		}
+	}
+	// Each statement counts once: 10 of the 14 of the set build are
+	// covered, plus the uncovered one of Also.
+	if got, want := flat.GetPercent(), 1000.0/15; got != want {
+		t.Errorf("GetPercent = %v, want %v", got, want)
+	}
+
+	// The original coverage is left untouched.
+	samePods(t, c.Data, readTestDir(t, dir))
+
+	if _, err := readTestCoverage(t, mergeTestDirs(t, setDir, countDir)).FlattenPods(); err == nil || !strings.Contains(err.Error(), "counter mode clash") {
+		t.Errorf("flattening set and count pods: error %v, want a counter mode clash", err)
+	}
+}
@@ -0,0 +1,138 @@
+package gocov
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// FuncPayload holds the counter values recorded for a single function,
+// identified by its package and function index within the meta-data
+// file of the program that produced the counters.
+type FuncPayload struct {
+	PkgIdx   uint32
+	FuncIdx  uint32
+	Counters []uint32
+}
+
+// CounterReader reads the raw function payloads from a counter data
+// file, independently of any meta-data file. A counter data file is
+// made up of one or more segments, each holding the counters of one
+// execution of the program; the reader starts out positioned at the
+// first segment.
+type CounterReader struct {
+	rs   io.ReadSeeker
+	cdr  *counterDataReader
+	seg  int
+	data funcPayload
+	// funcOff and funcLen locate the record of the function last
+	// returned by Next.
+	funcOff, funcLen int64
+}
+
+// NewCounterReader creates a CounterReader for the counter data file
+// contents available from 'r'.
+func NewCounterReader(r io.ReadSeeker) (*CounterReader, error) {
+	cdr, err := newCounterDataReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &CounterReader{rs: r, cdr: cdr}, nil
+}
+
+// NewCounterReaderBuffered creates a CounterReader for a counter data
+// file read from a stream that can't seek, such as a pipe. The whole
+// stream is read into memory first.
+func NewCounterReaderBuffered(r io.Reader) (*CounterReader, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("reading counter data: %v", err)
+	}
+	return NewCounterReader(bytes.NewReader(b))
+}
+
+// NumSegments returns the number of segments in the counter data file.
+func (r *CounterReader) NumSegments() int {
+	return int(r.cdr.NumSegments())
+}
+
+// BeginSegment positions the reader at the start of segment 'i', so
+// that Next returns the functions of that segment.
+func (r *CounterReader) BeginSegment(i int) error {
+	if i < 0 || i >= r.NumSegments() {
+		return fmt.Errorf("segment %d out of range, file has %d segments", i, r.NumSegments())
+	}
+	// Segments can only be located by reading through the ones before
+	// them, so anything but moving forward means starting over.
+	if i <= r.seg {
+		if _, err := r.rs.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		cdr, err := newCounterDataReader(r.rs)
+		if err != nil {
+			return err
+		}
+		r.cdr, r.seg = cdr, 0
+	}
+	for r.seg < i {
+		for {
+			ok, err := r.cdr.NextFunc(&r.data)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				break
+			}
+		}
+		if _, err := r.cdr.BeginNextSegment(); err != nil {
+			return err
+		}
+		r.seg++
+	}
+	return nil
+}
+
+// Args returns the args (key/value annotations such as os.Args,
+// GOOS and GOARCH) recorded in the current segment.
+func (r *CounterReader) Args() map[string]string {
+	args := make(map[string]string, len(r.cdr.args))
+	for k, v := range r.cdr.args {
+		args[k] = v
+	}
+	return args
+}
+
+// Next returns the payload of the next function in the current
+// segment, or false once all of its functions have been read. The
+// returned payload is not reused by later calls.
+func (r *CounterReader) Next() (*FuncPayload, bool, error) {
+	start, err := r.rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false, err
+	}
+	ok, err := r.cdr.NextFunc(&r.data)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	end, err := r.rs.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, false, err
+	}
+	r.funcOff, r.funcLen = start, end-start
+	p := &FuncPayload{
+		PkgIdx:   r.data.PkgIdx,
+		FuncIdx:  r.data.FuncIdx,
+		Counters: make([]uint32, len(r.data.Counters)),
+	}
+	copy(p.Counters, r.data.Counters)
+	return p, true, nil
+}
+
+// FuncExtent returns the byte offset within the counter data file and
+// the length of the record of the function last returned by Next: its
+// package and function indices and number of counters, followed by
+// the counters themselves. It returns zeros before the first call to
+// Next.
+func (r *CounterReader) FuncExtent() (offset, length int64) {
+	return r.funcOff, r.funcLen
+}
@@ -0,0 +1,355 @@
+package gocov
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"unsafe"
+)
+
+const setArgsCounterFile = setDir + "/covcounters." + setHash + ".5144.1792112601019318660"
+
+// readPayloads returns the payloads of the current segment of 'r'.
+func readPayloads(t *testing.T, r *CounterReader) []FuncPayload {
+	t.Helper()
+	var out []FuncPayload
+	for {
+		p, ok, err := r.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return out
+		}
+		out = append(out, *p)
+	}
+}
+
+func TestCounterReader(t *testing.T) {
+	f, err := os.Open(setArgsCounterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := NewCounterReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FuncPayload{
+		{PkgIdx: 1, FuncIdx: 0, Counters: []uint32{1}},
+		{PkgIdx: 2, FuncIdx: 0, Counters: []uint32{1, 1}},
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 1, 0}},
+		{PkgIdx: 0, FuncIdx: 2, Counters: []uint32{1, 1, 1}},
+	}
+	if got := readPayloads(t, r); !reflect.DeepEqual(got, want) {
+		t.Errorf("payloads = %v, want %v", got, want)
+	}
+	// Reading past the end keeps reporting the end.
+	if _, ok, err := r.Next(); ok || err != nil {
+		t.Errorf("Next after the end = %v, %v; want false, nil", ok, err)
+	}
+}
+
+func TestCounterReaderPayloadsNotReused(t *testing.T) {
+	payloads := []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 2, 3}},
+		{PkgIdx: 0, FuncIdx: 1, Counters: []uint32{4, 5, 6}},
+	}
+	var hash [16]byte
+	r, err := NewCounterReader(bytes.NewReader(encodeCounterFile(hash, nil, payloads)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := readPayloads(t, r)
+	for i, p := range payloads {
+		if !reflect.DeepEqual(got[i].Counters, p.Counters) {
+			t.Errorf("payload %d counters = %v, want %v", i, got[i].Counters, p.Counters)
+		}
+	}
+}
+
+func TestCounterReaderNotCounterFile(t *testing.T) {
+	b, err := os.ReadFile(setDir + "/covmeta." + setHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewCounterReader(bytes.NewReader(b)); err == nil {
+		t.Error("NewCounterReader of a meta-data file succeeded")
+	}
+}
+
+func TestCounterReaderSegments(t *testing.T) {
+	segs := []counterSegment{
+		{map[string]string{"argc": "1", "argv0": "first"}, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0}},
+			{PkgIdx: 0, FuncIdx: 1, Counters: []uint32{2}},
+		}},
+		{map[string]string{"argc": "1", "argv0": "second"}, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{0, 3}},
+			{PkgIdx: 1, FuncIdx: 0, Counters: []uint32{1}},
+			{PkgIdx: 1, FuncIdx: 2, Counters: []uint32{4, 5, 6}},
+		}},
+	}
+	var hash [16]byte
+	r, err := NewCounterReader(bytes.NewReader(encodeCounterSegments(hash, segs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.NumSegments(); n != 2 {
+		t.Fatalf("NumSegments() = %d, want 2", n)
+	}
+	// Visit the second segment before going back to the first.
+	for _, i := range []int{1, 0, 1} {
+		if err := r.BeginSegment(i); err != nil {
+			t.Fatalf("BeginSegment(%d): %v", i, err)
+		}
+		if got, want := r.Args()["argv0"], segs[i].args["argv0"]; got != want {
+			t.Errorf("segment %d: argv0 %q, want %q", i, got, want)
+		}
+		got := readPayloads(t, r)
+		if len(got) != len(segs[i].payloads) {
+			t.Errorf("segment %d: read %d funcs, want %d", i, len(got), len(segs[i].payloads))
+			continue
+		}
+		for j, p := range got {
+			want := segs[i].payloads[j]
+			if p.PkgIdx != want.PkgIdx || p.FuncIdx != want.FuncIdx || !reflect.DeepEqual(p.Counters, want.Counters) {
+				t.Errorf("segment %d: func %d is %+v, want %+v", i, j, p, want)
+			}
+		}
+	}
+	for _, i := range []int{-1, 2} {
+		if err := r.BeginSegment(i); err == nil {
+			t.Errorf("BeginSegment(%d) succeeded", i)
+		}
+	}
+}
+
+func TestCounterReaderBuffered(t *testing.T) {
+	segs := []counterSegment{
+		{map[string]string{"argc": "1", "argv0": "first"}, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0}},
+		}},
+		{map[string]string{"argc": "1", "argv0": "second"}, []funcPayload{
+			{PkgIdx: 1, FuncIdx: 2, Counters: []uint32{4, 5, 6}},
+		}},
+	}
+	var hash [16]byte
+	b := encodeCounterSegments(hash, segs)
+	// A pipe, fed in small writes, can't seek.
+	pr, pw := io.Pipe()
+	go func() {
+		for len(b) > 0 {
+			n := 7
+			if n > len(b) {
+				n = len(b)
+			}
+			if _, err := pw.Write(b[:n]); err != nil {
+				return
+			}
+			b = b[n:]
+		}
+		pw.Close()
+	}()
+	r, err := NewCounterReaderBuffered(pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Going back to the first segment seeks within the buffered stream.
+	for _, i := range []int{1, 0} {
+		if err := r.BeginSegment(i); err != nil {
+			t.Fatalf("BeginSegment(%d): %v", i, err)
+		}
+		got := readPayloads(t, r)
+		want := segs[i].payloads[0]
+		if len(got) != 1 || got[0].PkgIdx != want.PkgIdx || got[0].FuncIdx != want.FuncIdx || !reflect.DeepEqual(got[0].Counters, want.Counters) {
+			t.Errorf("segment %d: payloads %+v, want %+v", i, got, want)
+		}
+	}
+
+	readErr := errors.New("broken pipe")
+	if _, err := NewCounterReaderBuffered(iotest.ErrReader(readErr)); err == nil || !strings.Contains(err.Error(), "broken pipe") {
+		t.Errorf("NewCounterReaderBuffered of a failing stream: error %v, want %q", err, readErr)
+	}
+}
+
+// recordSize returns the length of the record of 'p' in a counter data
+// file of flavor 'flavor': the number of counters, the package and
+// function indices, then the counters.
+func recordSize(flavor counterFlavor, p *FuncPayload) int64 {
+	vals := append([]uint32{uint32(len(p.Counters)), p.PkgIdx, p.FuncIdx}, p.Counters...)
+	if flavor == ctrRaw {
+		return 4 * int64(len(vals))
+	}
+	var n int64
+	for _, v := range vals {
+		n++
+		for v >= 0x80 {
+			v >>= 7
+			n++
+		}
+	}
+	return n
+}
+
+func TestCounterReaderFuncExtent(t *testing.T) {
+	// extents reads the functions of every segment of 'b', checking that
+	// each extent lies past the one before and within 'b', and that it
+	// spans the function's record.
+	extents := func(b []byte) [][2]int64 {
+		r, err := NewCounterReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if off, n := r.FuncExtent(); off != 0 || n != 0 {
+			t.Errorf("FuncExtent() before Next = %d, %d; want zeros", off, n)
+		}
+		var exts [][2]int64
+		end := int64(0)
+		for i := 0; i < r.NumSegments(); i++ {
+			if err := r.BeginSegment(i); err != nil {
+				t.Fatal(err)
+			}
+			for {
+				p, ok, err := r.Next()
+				if err != nil {
+					t.Fatal(err)
+				}
+				if !ok {
+					break
+				}
+				off, n := r.FuncExtent()
+				if off < end || off+n > int64(len(b)) {
+					t.Errorf("segment %d: extent [%d,+%d) of func %d/%d overlaps the one before or leaves the file of %d bytes", i, off, n, p.PkgIdx, p.FuncIdx, len(b))
+				}
+				if want := recordSize(r.cdr.hdr.CFlavor, p); n != want {
+					t.Errorf("segment %d: extent of func %d/%d is %d bytes, want %d", i, p.PkgIdx, p.FuncIdx, n, want)
+				}
+				end = off + n
+				exts = append(exts, [2]int64{off, n})
+			}
+		}
+		return exts
+	}
+
+	b, err := os.ReadFile(setArgsCounterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exts := extents(b); len(exts) == 0 {
+		t.Error("no functions read")
+	}
+
+	segs := []counterSegment{
+		{map[string]string{"argc": "0"}, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 300}},
+			{PkgIdx: 0, FuncIdx: 1, Counters: []uint32{2}},
+		}},
+		{map[string]string{"argc": "0"}, []funcPayload{
+			{PkgIdx: 1, FuncIdx: 2, Counters: []uint32{4, 5, 1 << 20}},
+		}},
+	}
+	var hash [16]byte
+	b = encodeCounterSegments(hash, segs)
+	exts := extents(b)
+	if len(exts) != 3 {
+		t.Fatalf("read %d functions, want 3", len(exts))
+	}
+	// Going back to a segment finds the same extents.
+	r, err := NewCounterReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, i := range []int{1, 0} {
+		if err := r.BeginSegment(i); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := r.Next(); err != nil {
+			t.Fatal(err)
+		}
+		want := exts[0]
+		if i == 1 {
+			want = exts[2]
+		}
+		if off, n := r.FuncExtent(); off != want[0] || n != want[1] {
+			t.Errorf("segment %d: FuncExtent() = %d, %d; want %d, %d", i, off, n, want[0], want[1])
+		}
+	}
+}
+
+func TestMisalignedSegmentPreamble(t *testing.T) {
+	hash, err := hex.DecodeString(countHash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var metaHash [16]byte
+	copy(metaHash[:], hash)
+	payloads := []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 2, 3}}}
+	b := encodeCounterFile(metaHash, map[string]string{"k": "v"}, payloads)
+
+	// encodeCounterFile counts the padding in the args section; have it
+	// follow the three bytes of args instead, as the runtime does.
+	shdrOff := binary.Size(counterFileHeader{})
+	argsLenOff := shdrOff + int(unsafe.Offsetof(counterSegmentHeader{}.ArgsLen))
+	strTabLen := binary.LittleEndian.Uint32(b[shdrOff+int(unsafe.Offsetof(counterSegmentHeader{}.StrTabLen)):])
+	argsLen := binary.LittleEndian.Uint32(b[argsLenOff:])
+	const args = 3
+	if argsLen == args {
+		t.Fatal("segment preamble of the test needs no padding")
+	}
+	binary.LittleEndian.PutUint32(b[argsLenOff:], args)
+	padOff := shdrOff + binary.Size(counterSegmentHeader{}) + int(strTabLen) + args
+	padLen := int(argsLen) - args
+
+	read := func(b []byte) ([]FuncPayload, error) {
+		r, err := NewCounterReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		var got []FuncPayload
+		for {
+			p, ok, err := r.Next()
+			if err != nil || !ok {
+				return got, err
+			}
+			got = append(got, *p)
+		}
+	}
+	got, err := read(b)
+	if err != nil {
+		t.Fatalf("zero padding: %v", err)
+	}
+	if want := []FuncPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 2, 3}}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("zero padding: read %+v, want %+v", got, want)
+	}
+
+	// Padding that isn't zero, and padding left out, so that the
+	// counters would be read from the padding's place.
+	nonzero := append([]byte(nil), b...)
+	nonzero[padOff+padLen-1] = 0xff
+	missing := append(append([]byte(nil), b[:padOff]...), b[padOff+padLen:]...)
+	const msg = "misaligned segment preamble"
+	for name, bad := range map[string][]byte{"nonzero padding": nonzero, "missing padding": missing} {
+		if _, err := read(bad); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: error %v, want %q", name, err, msg)
+		}
+		// A misaligned preamble is corruption, not truncation, so
+		// reading fails rather than skipping the file.
+		dir := copyTestDir(t, countDir)
+		cdf := filepath.Join(dir, counterFilePref+"."+countHash+".1.1")
+		if err := os.WriteFile(cdf, bad, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadDir(dir, nil); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("ReadDir with %s: error %v, want %q", name, err, msg)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package gocov
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+var (
+	covClientOnce sync.Once
+	covClientPath string
+	covClientErr  error
+)
+
+// buildCovClient builds testdata/covclient with -cover, once per test
+// binary, and returns the path of the executable. Tests using it are
+// skipped in short mode.
+func buildCovClient(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping build of instrumented program in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	covClientOnce.Do(func() {
+		dir, err := os.MkdirTemp("", "covclient")
+		if err != nil {
+			covClientErr = err
+			return
+		}
+		covClientPath = filepath.Join(dir, "covclient")
+		cmd := exec.Command("go", "build", "-cover", "-covermode=atomic", "-o", covClientPath, ".")
+		cmd.Dir = filepath.Join("testdata", "covclient")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			covClientErr = &buildError{err, string(out)}
+		}
+	})
+	if covClientErr != nil {
+		t.Fatalf("building covclient: %v", covClientErr)
+	}
+	return covClientPath
+}
+
+type buildError struct {
+	err error
+	out string
+}
+
+func (e *buildError) Error() string {
+	return e.err.Error() + "\n" + e.out
+}
+
+// runCovClient runs the covclient scenario 'scenario' with 'args' and
+// returns its standard output.
+func runCovClient(t *testing.T, scenario string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command(buildCovClient(t), append([]string{scenario}, args...)...)
+	cmd.Env = append(os.Environ(), "GOCOVERDIR="+t.TempDir())
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("covclient %s: %v\n%s", scenario, err, stderr.String())
+	}
+	return string(out)
+}
+
+func TestMain(m *testing.M) {
+	code := m.Run()
+	if covClientPath != "" {
+		os.RemoveAll(filepath.Dir(covClientPath))
+	}
+	os.Exit(code)
+}
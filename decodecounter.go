@@ -6,6 +6,7 @@ package gocov
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"os"
@@ -16,6 +17,10 @@ import (
 // This file contains helpers for reading counter data files created
 // during the executions of a coverage-instrumented binary.
 
+// errTruncated reports a counter data file that ends early, typically
+// because the program writing it hasn't finished yet.
+var errTruncated = errors.New("truncated counter data file")
+
 type counterDataReader struct {
 	stab     *sReader
 	args     map[string]string
@@ -83,7 +88,9 @@ func (cdr *counterDataReader) readFooter() error {
 		return err
 	}
 	if !checkMagic(cdr.ftr.Magic) {
-		return fmt.Errorf("invalid magic string (not a counter data file)")
+		// The header checked out, so the footer is most likely yet to
+		// be written.
+		return fmt.Errorf("%w: no footer (invalid magic string)", errTruncated)
 	}
 	if cdr.ftr.NumSegments == 0 {
 		return fmt.Errorf("invalid counter data file (no segments)")
@@ -111,15 +118,22 @@ func (cdr *counterDataReader) readSegmentPreamble() error {
 	if err := cdr.readArgs(); err != nil {
 		return err
 	}
-	// Seek past any padding to bring us up to a 4-byte boundary.
-	if of, err := cdr.mr.Seek(0, io.SeekCurrent); err != nil {
+	// Read past any padding to bring us up to a 4-byte boundary. The
+	// padding is all zeros; anything else means the producer padded the
+	// preamble wrongly, and the counters would be read from the wrong
+	// offset.
+	of, err := cdr.mr.Seek(0, io.SeekCurrent)
+	if err != nil {
 		return err
-	} else {
-		rem := of % 4
-		if rem != 0 {
-			pad := 4 - rem
-			if _, err := cdr.mr.Seek(pad, io.SeekCurrent); err != nil {
-				return err
+	}
+	if rem := of % 4; rem != 0 {
+		pad := make([]byte, 4-rem)
+		if _, err := io.ReadFull(cdr.mr, pad); err != nil {
+			return fmt.Errorf("reading segment preamble padding: %w", err)
+		}
+		for _, b := range pad {
+			if b != 0 {
+				return fmt.Errorf("misaligned segment preamble: nonzero padding byte at offset %d", of)
 			}
 		}
 	}
@@ -127,13 +141,17 @@ func (cdr *counterDataReader) readSegmentPreamble() error {
 }
 
 func (cdr *counterDataReader) readStringTable() error {
-	b := make([]byte, cdr.shdr.StrTabLen)
+	n, err := intLen(uint64(cdr.shdr.StrTabLen))
+	if err != nil {
+		return fmt.Errorf("reading string table: %v", err)
+	}
+	b := make([]byte, n)
 	nr, err := cdr.mr.Read(b)
 	if err != nil {
 		return err
 	}
-	if nr != int(cdr.shdr.StrTabLen) {
-		return fmt.Errorf("error: short read on string table")
+	if nr != n {
+		return fmt.Errorf("%w: short read on string table", errTruncated)
 	}
 	slr := newReader(b, false /* not readonly */)
 	cdr.stab = newSReader(slr)
@@ -142,23 +160,31 @@ func (cdr *counterDataReader) readStringTable() error {
 }
 
 func (cdr *counterDataReader) readArgs() error {
-	b := make([]byte, cdr.shdr.ArgsLen)
+	n, err := intLen(uint64(cdr.shdr.ArgsLen))
+	if err != nil {
+		return fmt.Errorf("reading args table: %v", err)
+	}
+	b := make([]byte, n)
 	nr, err := cdr.mr.Read(b)
 	if err != nil {
 		return err
 	}
-	if nr != int(cdr.shdr.ArgsLen) {
-		return fmt.Errorf("error: short read on args table")
+	if nr != n {
+		return fmt.Errorf("%w: short read on args table", errTruncated)
 	}
 	slr := newReader(b, false /* not readonly */)
 	sget := func() (string, error) {
 		kidx := slr.ReadULEB128()
-		if int(kidx) >= cdr.stab.Entries() {
+		if kidx >= uint64(cdr.stab.Entries()) {
 			return "", fmt.Errorf("malformed string table ref")
 		}
 		return cdr.stab.Get(uint32(kidx)), nil
 	}
 	nents := slr.ReadULEB128()
+	// Each entry takes at least two bytes, one per string reference.
+	if nents > uint64(n)/2 {
+		return fmt.Errorf("malformed args table: %d entries in %d bytes", nents, n)
+	}
 	cdr.args = make(map[string]string, int(nents))
 	for i := uint64(0); i < nents; i++ {
 		k, errk := sget()
@@ -15,6 +15,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"sort"
 )
 
 // See comments in the encodecovmeta package for details on the format.
@@ -23,15 +24,17 @@ type coverageMetaDataDecoder struct {
 	r      *reader
 	hdr    metaSymbolHeader
 	strtab *sReader
+	intern map[string]string
 	tmp    []byte
 	debug  bool
 }
 
-func newCoverageMetaDataDecoder(b []byte, readonly bool) (*coverageMetaDataDecoder, error) {
+func newCoverageMetaDataDecoder(b []byte, readonly bool, intern map[string]string) (*coverageMetaDataDecoder, error) {
 	slr := newReader(b, readonly)
 	x := &coverageMetaDataDecoder{
-		r:   slr,
-		tmp: make([]byte, 0, 256),
+		r:      slr,
+		tmp:    make([]byte, 0, 256),
+		intern: intern,
 	}
 	if err := x.readHeader(); err != nil {
 		return nil, err
@@ -42,6 +45,64 @@ func newCoverageMetaDataDecoder(b []byte, readonly bool) (*coverageMetaDataDecod
 	return x, nil
 }
 
+// peekPackagePath returns the import path and number of functions of
+// the package whose encoded meta-data is 'b'. Unlike a full decoder it
+// reads only the header and the string table entries up to the package
+// path, which makes it cheap to rule out packages that aren't wanted.
+func peekPackagePath(b []byte) (string, uint32, error) {
+	// The slice reader doesn't report running out of data, so a short
+	// header must be caught up front.
+	if len(b) < covMetaHeaderSize {
+		return "", 0, fmt.Errorf("malformed package meta-data: %d bytes, shorter than the header", len(b))
+	}
+	r := newReader(b, true)
+	var hdr metaSymbolHeader
+	if err := binary.Read(r, binary.LittleEndian, &hdr); err != nil {
+		return "", 0, err
+	}
+	stringTableLocation := int64(covMetaHeaderSize + 4*hdr.NumFuncs)
+	if stringTableLocation > int64(len(b)) {
+		return "", 0, fmt.Errorf("malformed string table offset %d", stringTableLocation)
+	}
+	r.SeekTo(stringTableLocation)
+	// The string table may be truncated or corrupt, so every read is
+	// checked against the end of 'b'.
+	readULEB128 := func() (uint64, error) {
+		var value uint64
+		for shift := uint(0); shift < 64; shift += 7 {
+			if r.Offset() >= int64(len(b)) {
+				break
+			}
+			c := r.ReadUint8()
+			value |= uint64(c&0x7F) << shift
+			if c&0x80 == 0 {
+				return value, nil
+			}
+		}
+		return 0, fmt.Errorf("malformed string table")
+	}
+	numEntries, err := readULEB128()
+	if err != nil {
+		return "", 0, err
+	}
+	if uint64(hdr.PkgPath) >= numEntries {
+		return "", 0, fmt.Errorf("malformed package path index %d", hdr.PkgPath)
+	}
+	for idx := uint32(0); ; idx++ {
+		slen, err := readULEB128()
+		if err != nil {
+			return "", 0, err
+		}
+		if slen > uint64(int64(len(b))-r.Offset()) {
+			return "", 0, fmt.Errorf("malformed string table: entry %d of %d bytes at offset %d", idx, slen, r.Offset())
+		}
+		if idx == hdr.PkgPath {
+			return string(r.ReadBytes(int64(slen))), hdr.NumFuncs, nil
+		}
+		r.SeekTo(r.Offset() + int64(slen))
+	}
+}
+
 func (d *coverageMetaDataDecoder) readHeader() error {
 	if err := binary.Read(d.r, binary.LittleEndian, &d.hdr); err != nil {
 		return err
@@ -59,6 +120,7 @@ func (d *coverageMetaDataDecoder) readStringTable() error {
 
 	// Read the table itself.
 	d.strtab = newSReader(d.r)
+	d.strtab.intern = d.intern
 	d.strtab.Read()
 	return nil
 }
@@ -75,6 +137,12 @@ func (d *coverageMetaDataDecoder) ModulePath() string {
 	return d.strtab.Get(d.hdr.ModulePath)
 }
 
+// MetaHash returns the hash of the package's meta-data blob, which is
+// the same in every build where the package's code is unchanged.
+func (d *coverageMetaDataDecoder) MetaHash() [16]byte {
+	return d.hdr.MetaHash
+}
+
 func (d *coverageMetaDataDecoder) NumFuncs() uint32 {
 	return d.hdr.NumFuncs
 }
@@ -109,7 +177,7 @@ func (d *coverageMetaDataDecoder) ReadFunc(fidx uint32, f *funcDesc) error {
 
 	// Now the units
 	f.Units = f.Units[:0]
-	if cap(f.Units) < int(numUnits) {
+	if uint64(cap(f.Units)) < uint64(numUnits) {
 		f.Units = make([]coverableUnit, 0, numUnits)
 	}
 	for k := uint32(0); k < numUnits; k++ {
@@ -127,6 +195,27 @@ func (d *coverageMetaDataDecoder) ReadFunc(fidx uint32, f *funcDesc) error {
 	return nil
 }
 
+// FindFunc returns the index of the first function named 'name' in the
+// package, reading only the function names rather than decoding every
+// function.
+func (d *coverageMetaDataDecoder) FindFunc(name string) (uint32, bool) {
+	for fidx := uint32(0); fidx < d.hdr.NumFuncs; fidx++ {
+		funcOffsetLocation := int64(covMetaHeaderSize + 4*fidx)
+		d.r.SeekTo(funcOffsetLocation)
+		foff := d.r.ReadUint32()
+		if foff < uint32(funcOffsetLocation) || foff > d.hdr.Length {
+			return 0, false
+		}
+		d.r.SeekTo(int64(foff))
+		d.r.ReadULEB128() // number of units
+		fnameidx := uint32(d.r.ReadULEB128())
+		if uint64(fnameidx) < uint64(d.strtab.Entries()) && d.strtab.Get(fnameidx) == name {
+			return fidx, true
+		}
+	}
+	return 0, false
+}
+
 // This package contains APIs and helpers for reading and decoding
 // meta-data output files emitted by the runtime when a
 // coverage-instrumented binary executes. A meta-data file contains
@@ -144,7 +233,22 @@ type coverageMetaFileReader struct {
 	strtab     *sReader
 	fileRdr    *bufio.Reader
 	fileView   []byte
-	debug      bool
+	// intern, if set, is shared by the package decoders to intern the
+	// strings of their string tables.
+	intern map[string]string
+	// pkgs caches the packages peeked and decoded so far, so that the
+	// passes over the meta-data of a pod handle each only once.
+	pkgs  map[uint32]*metaPackage
+	debug bool
+}
+
+// metaPackage is what a coverageMetaFileReader knows about one of its
+// packages.
+type metaPackage struct {
+	path string
+	nf   uint32
+	pp   []byte
+	pd   *coverageMetaDataDecoder
 }
 
 // newCoverageMetaFileReader returns a new helper object for reading
@@ -170,22 +274,18 @@ func newCoverageMetaFileReader(reader io.ReadSeeker, fileView []byte) (*coverage
 func (r *coverageMetaFileReader) readFileHeader() error {
 	var err error
 
-	// Read file header.
-	if err := binary.Read(r.fileRdr, binary.LittleEndian, &r.hdr); err != nil {
+	if r.hdr, err = readMetaFileHeader(r.fileRdr); err != nil {
 		return err
 	}
 
-	// Verify magic string
-	m := r.hdr.Magic
-	g := covMetaMagic
-	if m[0] != g[0] || m[1] != g[1] || m[2] != g[2] || m[3] != g[3] {
-		return fmt.Errorf("invalid meta-data file magic string")
+	// A file cut short after an intact header would otherwise only fail
+	// later on, deep in some package payload read.
+	size, err := r.fileSize()
+	if err != nil {
+		return err
 	}
-
-	// Vet the version. If this is a meta-data file from the future,
-	// we won't be able to read it.
-	if r.hdr.Version > metaFileVersion {
-		return fmt.Errorf("meta-data file withn unknown version %d (expected %d)", r.hdr.Version, metaFileVersion)
+	if uint64(size) < r.hdr.TotalLength {
+		return fmt.Errorf("truncated meta-data file: header records length %d, file has %d bytes", r.hdr.TotalLength, size)
 	}
 
 	// Read package offsets for good measure
@@ -210,6 +310,30 @@ func (r *coverageMetaFileReader) readFileHeader() error {
 		}
 	}
 
+	// Package payloads must lie within the file and not overlap, or
+	// one package would be decoded from another's bytes.
+	var order []int
+	for i := range r.pkgOffsets {
+		if r.pkgLengths[i] > r.hdr.TotalLength-r.pkgOffsets[i] {
+			return fmt.Errorf("insane pkg extent %d: offset %d length %d > totlen %d",
+				i, r.pkgOffsets[i], r.pkgLengths[i], r.hdr.TotalLength)
+		}
+		if r.pkgLengths[i] != 0 {
+			order = append(order, i)
+		}
+	}
+	sort.Slice(order, func(i, j int) bool {
+		return r.pkgOffsets[order[i]] < r.pkgOffsets[order[j]]
+	})
+	for k := 1; k < len(order); k++ {
+		prev, cur := order[k-1], order[k]
+		if r.pkgOffsets[prev]+r.pkgLengths[prev] > r.pkgOffsets[cur] {
+			return fmt.Errorf("overlapping pkgs %d and %d: [%d,%d) and [%d,%d)",
+				prev, cur, r.pkgOffsets[prev], r.pkgOffsets[prev]+r.pkgLengths[prev],
+				r.pkgOffsets[cur], r.pkgOffsets[cur]+r.pkgLengths[cur])
+		}
+	}
+
 	// Read string table.
 	b := make([]byte, r.hdr.StrTabLength)
 	nr, err := r.fileRdr.Read(b)
@@ -230,6 +354,96 @@ func (r *coverageMetaFileReader) readFileHeader() error {
 	return nil
 }
 
+// readMetaFileHeader reads and checks the header at the start of a
+// meta-data file from 'rd', leaving 'rd' positioned just after it.
+func readMetaFileHeader(rd io.Reader) (metaFileHeader, error) {
+	// Read the magic string and version, which lead the header in
+	// every version of the format.
+	var pre struct {
+		Magic   [4]byte
+		Version uint32
+	}
+	if err := binary.Read(rd, binary.LittleEndian, &pre); err != nil {
+		return metaFileHeader{}, err
+	}
+
+	// Verify magic string
+	m := pre.Magic
+	g := covMetaMagic
+	if m[0] != g[0] || m[1] != g[1] || m[2] != g[2] || m[3] != g[3] {
+		return metaFileHeader{}, fmt.Errorf("invalid meta-data file magic string")
+	}
+
+	// Decode the rest of the header according to the version. If this
+	// is a meta-data file from the future, we won't be able to read it.
+	var hdr metaFileHeader
+	var err error
+	switch pre.Version {
+	case 1:
+		hdr, err = decodeHeaderV1(rd)
+	default:
+		return metaFileHeader{}, fmt.Errorf("meta-data file with unknown version %d (expected %d)", pre.Version, metaFileVersion)
+	}
+	if err != nil {
+		return metaFileHeader{}, err
+	}
+	hdr.Magic = pre.Magic
+	hdr.Version = pre.Version
+	return hdr, nil
+}
+
+// metaFileHeaderV1 is the layout of the version 1 meta-data file
+// header following the magic string and version.
+type metaFileHeaderV1 struct {
+	TotalLength  uint64
+	Entries      uint64
+	MetaFileHash [16]byte
+	StrTabOffset uint32
+	StrTabLength uint32
+	CMode        counterMode
+	CGranularity CounterGranularity
+	_            [6]byte // padding
+}
+
+// decodeHeaderV1 reads the remainder of a version 1 meta-data file
+// header from 'rd'. Headers of later versions get their own decode
+// function, so that version 1 files remain readable.
+func decodeHeaderV1(rd io.Reader) (metaFileHeader, error) {
+	var h metaFileHeaderV1
+	if err := binary.Read(rd, binary.LittleEndian, &h); err != nil {
+		return metaFileHeader{}, err
+	}
+	return metaFileHeader{
+		TotalLength:  h.TotalLength,
+		Entries:      h.Entries,
+		MetaFileHash: h.MetaFileHash,
+		StrTabOffset: h.StrTabOffset,
+		StrTabLength: h.StrTabLength,
+		CMode:        h.CMode,
+		CGranularity: h.CGranularity,
+	}, nil
+}
+
+// fileSize returns the size of the meta-data file, leaving the read
+// position of the underlying file unchanged.
+func (r *coverageMetaFileReader) fileSize() (int64, error) {
+	if r.fileView != nil {
+		return int64(len(r.fileView)), nil
+	}
+	pos, err := r.f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return 0, err
+	}
+	size, err := r.f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := r.f.Seek(pos, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return size, nil
+}
+
 func (r *coverageMetaFileReader) rdUint64() (uint64, error) {
 	r.tmp = r.tmp[:0]
 	r.tmp = append(r.tmp, make([]byte, 8)...)
@@ -287,13 +501,61 @@ func (r *coverageMetaFileReader) GetPackageDecoder(pkIdx uint32, payloadbuf []by
 	if err != nil {
 		return nil, nil, err
 	}
-	mdd, err := newCoverageMetaDataDecoder(pp, r.fileView != nil)
+	mdd, err := newCoverageMetaDataDecoder(pp, r.fileView != nil, r.intern)
 	if err != nil {
 		return nil, nil, err
 	}
 	return mdd, pp, nil
 }
 
+// PeekPackagePath returns the import path and number of functions of
+// the package with index 'pkIdx' without building a decoder for it.
+// The payload buffer is handled as with GetPackageDecoder.
+func (r *coverageMetaFileReader) PeekPackagePath(pkIdx uint32, payloadbuf []byte) (string, uint32, []byte, error) {
+	pp, err := r.GetPackagePayload(pkIdx, payloadbuf)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	path, nf, err := peekPackagePath(pp)
+	if err != nil {
+		return "", 0, nil, err
+	}
+	return path, nf, pp, nil
+}
+
+// peekPackage is like PeekPackagePath, but remembers the result (and
+// the payload) for later calls and for packageDecoder.
+func (r *coverageMetaFileReader) peekPackage(pkIdx uint32) (*metaPackage, error) {
+	if mp, ok := r.pkgs[pkIdx]; ok {
+		return mp, nil
+	}
+	path, nf, pp, err := r.PeekPackagePath(pkIdx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.pkgs == nil {
+		r.pkgs = make(map[uint32]*metaPackage)
+	}
+	mp := &metaPackage{path: path, nf: nf, pp: pp}
+	r.pkgs[pkIdx] = mp
+	return mp, nil
+}
+
+// packageDecoder returns the decoder for the package with index
+// 'pkIdx'. It is built on the first call and shared by later ones.
+func (r *coverageMetaFileReader) packageDecoder(pkIdx uint32) (*coverageMetaDataDecoder, error) {
+	mp, err := r.peekPackage(pkIdx)
+	if err != nil {
+		return nil, err
+	}
+	if mp.pd == nil {
+		if mp.pd, err = newCoverageMetaDataDecoder(mp.pp, r.fileView != nil, r.intern); err != nil {
+			return nil, err
+		}
+	}
+	return mp.pd, nil
+}
+
 // GetPackagePayload returns the raw (encoded) meta-data payload for the
 // package with index 'pkIdx'. As with GetPackageDecoder, if the
 // CoverageMetaFileReader was set up with a read-only file view, a
@@ -314,15 +576,22 @@ func (r *coverageMetaFileReader) GetPackagePayload(pkIdx uint32, payloadbuf []by
 		fmt.Fprintf(os.Stderr, "=-= for pk %d, off=%d len=%d\n", pkIdx, off, len)
 	}
 
+	if off > r.hdr.TotalLength || len > r.hdr.TotalLength-off {
+		return nil, fmt.Errorf("GetPackagePayload: pkg %d at offset %d length %d exceeds file length %d", pkIdx, off, len, r.hdr.TotalLength)
+	}
 	if r.fileView != nil {
 		return r.fileView[off : off+len], nil
 	}
 
+	n, err := intLen(len)
+	if err != nil {
+		return nil, fmt.Errorf("GetPackagePayload: pkg %d: %v", pkIdx, err)
+	}
 	payload := payloadbuf[:0]
-	if cap(payload) < int(len) {
-		payload = make([]byte, 0, len)
+	if cap(payload) < n {
+		payload = make([]byte, 0, n)
 	}
-	payload = append(payload, make([]byte, len)...)
+	payload = append(payload, make([]byte, n)...)
 	if _, err := r.f.Seek(int64(off), io.SeekStart); err != nil {
 		return nil, err
 	}
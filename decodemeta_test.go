@@ -0,0 +1,320 @@
+package gocov
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestTruncatedMetaFile(t *testing.T) {
+	metaFile := filepath.Join(setDir, metaFilePref+"."+setHash)
+	b, err := os.ReadFile(metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const want = "truncated meta-data file"
+	hdrSize := int(unsafe.Sizeof(metaFileHeader{}))
+	for _, size := range []int{hdrSize, hdrSize + 10, len(b) - 1} {
+		short := b[:size]
+		if _, err := newCoverageMetaFileReader(bytes.NewReader(short), nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%d bytes, read through a reader: error %v, want %q", size, err, want)
+		}
+		if _, err := newCoverageMetaFileReader(bytes.NewReader(short), short); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%d bytes, read from memory: error %v, want %q", size, err, want)
+		}
+
+		dir := copyTestDir(t, setDir)
+		if err := os.Truncate(filepath.Join(dir, filepath.Base(metaFile)), int64(size)); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ReadDir(dir, nil); err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%d bytes, ReadDir: error %v, want %q", size, err, want)
+		}
+	}
+	if _, err := newCoverageMetaFileReader(bytes.NewReader(b), b); err != nil {
+		t.Errorf("complete file: %v", err)
+	}
+}
+
+func TestReadMetaFileHeaderVersions(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture is a version 1 file, whose header is decoded by
+	// decodeHeaderV1 after the magic string and version.
+	hdr, err := readMetaFileHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1, err := decodeHeaderV1(bytes.NewReader(b[8:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v1.Magic, v1.Version = covMetaMagic, 1
+	if hdr != v1 {
+		t.Errorf("header %+v, want the version 1 decoding %+v", hdr, v1)
+	}
+	if hex.EncodeToString(hdr.MetaFileHash[:]) != setHash || hdr.CMode != CtrModeSet || hdr.CGranularity != CtrGranularityPerBlock {
+		t.Errorf("header %+v doesn't describe the set pod", hdr)
+	}
+
+	// Versions without a decoder are rejected, as are other files.
+	future := append([]byte(nil), b...)
+	binary.LittleEndian.PutUint32(future[4:], 2)
+	if _, err := readMetaFileHeader(bytes.NewReader(future)); err == nil || !strings.Contains(err.Error(), "unknown version 2") {
+		t.Errorf("version 2 header: error %v, want an unknown version", err)
+	}
+	bad := append([]byte("XXXX"), b[4:]...)
+	if _, err := readMetaFileHeader(bytes.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "magic") {
+		t.Errorf("header with a bad magic string: error %v", err)
+	}
+}
+
+func TestReadMetaHeaderStringTable(t *testing.T) {
+	metaFile := filepath.Join(setDir, metaFilePref+"."+setHash)
+	info, err := ReadMetaHeader(metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The fixture's file-level string table holds only the empty
+	// string: its entry count and that string's zero length.
+	if info.StrTabOffset != 104 || info.StrTabLength != 2 || info.StrTabEntries != 1 {
+		t.Errorf("string table at %d, %d bytes, %d entries; want 104, 2, 1", info.StrTabOffset, info.StrTabLength, info.StrTabEntries)
+	}
+	b, err := os.ReadFile(metaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := newCoverageMetaFileReader(bytes.NewReader(b), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := r.strtab.Entries(); info.StrTabEntries != n {
+		t.Errorf("ReadMetaHeader found %d string table entries, the full reader %d", info.StrTabEntries, n)
+	}
+
+	// An entry count the table can't hold is an error.
+	b[info.StrTabOffset], b[info.StrTabOffset+1] = 0xc8, 0x01
+	bad := filepath.Join(t.TempDir(), metaFilePref+"."+setHash)
+	if err := os.WriteFile(bad, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadMetaHeader(bad); err == nil || !strings.Contains(err.Error(), "claims 200 entries") {
+		t.Errorf("ReadMetaHeader of a bad string table: error %v", err)
+	}
+}
+
+func TestMetaHashesNotVerified(t *testing.T) {
+	pack := testPackage("example.com/test/p",
+		testFunc("F", "p.go", []uint32{3, 4}, []uint32{1, 0}),
+	)
+	blob, pkgHash := encodePackageMeta(pack, []*Func{pack.Funcs[0]})
+	if want := md5.Sum(blob[covMetaHeaderSize:]); pkgHash != want {
+		t.Errorf("package hash %x, want the md5 of the payload body %x", pkgHash, want)
+	}
+
+	// Meta-data with recorded hashes that match nothing is read as it
+	// is, keyed by those hashes: the read path does no hashing.
+	var badPkg, badFile [16]byte
+	for i := range badPkg {
+		badPkg[i], badFile[i] = 0xee, 0xff
+	}
+	copy(blob[unsafe.Offsetof(metaSymbolHeader{}.MetaHash):], badPkg[:])
+	meta, _ := encodeMetaFile([][]byte{blob}, [][16]byte{badPkg}, CtrModeSet, CtrGranularityPerBlock)
+	copy(meta[unsafe.Offsetof(metaFileHeader{}.MetaFileHash):], badFile[:])
+	dir := t.TempDir()
+	hash := hex.EncodeToString(badFile[:])
+	if err := os.WriteFile(filepath.Join(dir, metaFilePref+"."+hash), meta, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	writeCounterFile(t, dir, hash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0}},
+	})
+	data, err := ReadDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := data.PodData[hash]
+	if !ok {
+		t.Fatalf("read pods %v, want %s", sortedKeys(data.PodData), hash)
+	}
+	if got := p.Packages[0].MetaHash; got != badPkg {
+		t.Errorf("package hash %x, want the recorded %x", got, badPkg)
+	}
+	if got, want := unitCounts(p.Packages[0].Funcs[0]), []uint32{1, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("counts %v, want %v", got, want)
+	}
+}
+
+func TestReadMetaHeader(t *testing.T) {
+	for _, tt := range []struct {
+		dir, hash string
+		mode      string
+	}{
+		{setDir, setHash, "set"},
+		{countDir, countHash, "count"},
+		{variantDir, variantHash, "set"},
+	} {
+		info, err := ReadMetaHeader(filepath.Join(tt.dir, metaFilePref+"."+tt.hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode != tt.mode || info.Granularity != "perblock" || info.NumPackages != 3 || info.Version != 1 {
+			t.Errorf("%s: %+v, want mode %s, granularity perblock, 3 packages, version 1", tt.dir, info, tt.mode)
+		}
+		if got := hex.EncodeToString(info.Hash[:]); got != tt.hash {
+			t.Errorf("%s: hash %s, want %s", tt.dir, got, tt.hash)
+		}
+	}
+
+	if _, err := ReadMetaHeader(setArgsCounterFile); err == nil {
+		t.Error("ReadMetaHeader of a counter data file succeeded")
+	}
+	if _, err := ReadMetaHeader(filepath.Join(setDir, metaFilePref+".missing")); err == nil {
+		t.Error("ReadMetaHeader of a missing file succeeded")
+	}
+}
+
+func TestPackageExtentOutOfRange(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The package offsets follow the file header, then the lengths.
+	hdrSize := int(unsafe.Sizeof(metaFileHeader{}))
+	offs, lens := hdrSize, hdrSize+8*3
+	tests := []struct {
+		name string
+		at   int
+		v    uint64
+		want string
+	}{
+		{"offset beyond 32 bits", offs, 1 << 40, "insane pkg offset"},
+		{"largest length", lens, math.MaxUint64, "insane pkg length"},
+		{"length past the end", lens, uint64(len(b)) - 8, "insane pkg extent"},
+	}
+	for _, tt := range tests {
+		bad := append([]byte(nil), b...)
+		binary.LittleEndian.PutUint64(bad[tt.at:], tt.v)
+		for _, view := range [][]byte{nil, bad} {
+			_, err := newCoverageMetaFileReader(bytes.NewReader(bad), view)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s, file view %v: error %v, want %q", tt.name, view != nil, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestOverlappingPackages(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdrSize := int(unsafe.Sizeof(metaFileHeader{}))
+	offs, lens := hdrSize, hdrSize+8*3
+	off := func(b []byte, i int) uint64 { return binary.LittleEndian.Uint64(b[offs+8*i:]) }
+	length := func(b []byte, i int) uint64 { return binary.LittleEndian.Uint64(b[lens+8*i:]) }
+	// The payloads of the fixture are adjacent.
+	for i := 1; i < 3; i++ {
+		if off(b, i-1)+length(b, i-1) != off(b, i) {
+			t.Fatalf("payloads of packages %d and %d aren't adjacent", i-1, i)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		patch func(b []byte)
+		want  string
+	}{
+		{"one byte into the next", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[lens:], length(b, 0)+1)
+		}, "overlapping pkgs 0 and 1"},
+		{"same offset", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[offs+8*2:], off(b, 1))
+		}, "overlapping pkgs 1 and 2"},
+		// The check is by offset, not by package index.
+		{"out of order", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[offs+8*2:], off(b, 0)+1)
+		}, "overlapping pkgs 0 and 2"},
+		{"swapped", func(b []byte) {
+			o0, l0, o1, l1 := off(b, 0), length(b, 0), off(b, 1), length(b, 1)
+			binary.LittleEndian.PutUint64(b[offs:], o1)
+			binary.LittleEndian.PutUint64(b[lens:], l1)
+			binary.LittleEndian.PutUint64(b[offs+8:], o0)
+			binary.LittleEndian.PutUint64(b[lens+8:], l0)
+		}, ""},
+		// Empty payloads overlap nothing.
+		{"empty", func(b []byte) {
+			binary.LittleEndian.PutUint64(b[offs:], off(b, 1))
+			binary.LittleEndian.PutUint64(b[lens:], 0)
+		}, ""},
+	}
+	for _, tt := range tests {
+		bad := append([]byte(nil), b...)
+		tt.patch(bad)
+		for _, view := range [][]byte{nil, bad} {
+			_, err := newCoverageMetaFileReader(bytes.NewReader(bad), view)
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("%s, file view %v: %v", tt.name, view != nil, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("%s, file view %v: error %v, want %q", tt.name, view != nil, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestPeekPackagePathMalformed(t *testing.T) {
+	f, err := os.Open(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	mfr, err := newCoverageMetaFileReader(f, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp, err := mfr.GetPackagePayload(0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path, nf, err := peekPackagePath(pp)
+	if err != nil || path != "example.com/prog/lib" || nf != 4 {
+		t.Fatalf("peekPackagePath = %q, %d, %v; want example.com/prog/lib, 4", path, nf, err)
+	}
+
+	// Truncating the payload makes for an error rather than a panic,
+	// unless the package path is still there.
+	for n := 0; n < len(pp); n++ {
+		if got, _, err := peekPackagePath(pp[:n]); err == nil && got != path {
+			t.Errorf("payload truncated to %d bytes: package path %q", n, got)
+		}
+	}
+	if _, _, err := peekPackagePath(pp[:covMetaHeaderSize+4]); err == nil {
+		t.Error("payload without a string table: no error")
+	}
+
+	// A string length running past the end of the payload, and a
+	// ULEB128 value that never ends.
+	var hdr metaSymbolHeader
+	strtab := covMetaHeaderSize + 4*int(binary.LittleEndian.Uint32(pp[unsafe.Offsetof(hdr.NumFuncs):]))
+	for name, patch := range map[string][]byte{
+		"long string": {0x03, 0xff, 0x7f},
+		"endless":     {0xff, 0xff, 0xff},
+	} {
+		bad := append(append([]byte(nil), pp[:strtab]...), patch...)
+		if _, _, err := peekPackagePath(bad); err == nil || !strings.Contains(err.Error(), "malformed string table") {
+			t.Errorf("%s: error %v, want a malformed string table", name, err)
+		}
+	}
+}
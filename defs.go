@@ -323,7 +323,7 @@ type counterFileFooter struct {
 // name: prefix followed by meta-file hash followed by process ID
 // followed by emit UnixNanoTime.
 const counterFilePref = "covcounters"
-const counterFileRegexp = `^%s\.(\S+)\.(\d+)\.(\d+)+$`
+const counterFileRegexp = `^%s\.(\S*)\.(\d+)\.(\d+)+$`
 
 // counterFlavor describes how function and counters are
 // stored/represented in the counter section of the file.
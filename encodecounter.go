@@ -0,0 +1,84 @@
+package gocov
+
+// This file contains helpers for encoding counter data files, the
+// counterpart of decodecounter.go.
+
+import (
+	"bytes"
+	"encoding/binary"
+)
+
+// encodeCounterFile encodes a counter data file for the meta-data file
+// with hash 'metaHash', holding a single segment with the args 'args'
+// and the function payloads 'payloads'.
+func encodeCounterFile(metaHash [16]byte, args map[string]string, payloads []funcPayload) []byte {
+	return encodeCounterSegments(metaHash, []counterSegment{{args, payloads}})
+}
+
+// counterSegment is the args section and function payloads of one
+// segment of a counter data file.
+type counterSegment struct {
+	args     map[string]string
+	payloads []funcPayload
+}
+
+// encodeCounterSegments encodes a counter data file for the meta-data
+// file with hash 'metaHash' holding the segments 'segs'. As when the
+// runtime appends a segment to an existing file, every segment is
+// followed by a footer counting the segments up to it.
+func encodeCounterSegments(metaHash [16]byte, segs []counterSegment) []byte {
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, counterFileHeader{
+		Magic:    covCounterMagic,
+		Version:  counterFileVersion,
+		MetaHash: metaHash,
+		CFlavor:  ctrULeb128,
+	})
+	for i, seg := range segs {
+		appendCounterSegment(&buf, seg)
+		binary.Write(&buf, binary.LittleEndian, counterFileFooter{
+			Magic:       covCounterMagic,
+			NumSegments: uint32(i + 1),
+		})
+	}
+	return buf.Bytes()
+}
+
+// appendCounterSegment encodes the segment 'seg' at the end of 'buf'.
+func appendCounterSegment(buf *bytes.Buffer, seg counterSegment) {
+	stab := newSWriter()
+	keys := sortedKeys(seg.args)
+	for _, k := range keys {
+		stab.Lookup(k)
+		stab.Lookup(seg.args[k])
+	}
+	strtab := stab.Append(nil)
+	argtab := appendULEB128(nil, uint64(len(keys)))
+	for _, k := range keys {
+		argtab = appendULEB128(argtab, uint64(stab.Lookup(k)))
+		argtab = appendULEB128(argtab, uint64(stab.Lookup(seg.args[k])))
+	}
+	// The counters start at a 4-byte boundary.
+	shdrSize := binary.Size(counterSegmentHeader{})
+	for (buf.Len()+shdrSize+len(strtab)+len(argtab))%4 != 0 {
+		argtab = append(argtab, 0)
+	}
+	binary.Write(buf, binary.LittleEndian, counterSegmentHeader{
+		FcnEntries: uint64(len(seg.payloads)),
+		StrTabLen:  uint32(len(strtab)),
+		ArgsLen:    uint32(len(argtab)),
+	})
+	buf.Write(strtab)
+	buf.Write(argtab)
+
+	var b []byte
+	for _, p := range seg.payloads {
+		b = appendULEB128(b, uint64(len(p.Counters)))
+		b = appendULEB128(b, uint64(p.PkgIdx))
+		b = appendULEB128(b, uint64(p.FuncIdx))
+		for _, c := range p.Counters {
+			b = appendULEB128(b, uint64(c))
+		}
+	}
+	buf.Write(b)
+}
@@ -0,0 +1,120 @@
+package gocov
+
+// This file contains helpers for encoding meta-data files, the
+// counterpart of decodemeta.go.
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"unsafe"
+)
+
+// encodePackageMeta encodes the meta-data payload of package 'pack',
+// whose functions are given in 'funcs' in function index order. It
+// returns the payload and its hash.
+func encodePackageMeta(pack *Package, funcs []*Func) ([]byte, [16]byte) {
+	stab := newSWriter()
+	pkgPath := stab.Lookup(pack.ImportPath)
+	pkgName := stab.Lookup(pack.Name)
+	modPath := stab.Lookup(pack.ModulePath)
+
+	encoded := make([][]byte, len(funcs))
+	for i, fn := range funcs {
+		var b []byte
+		b = appendULEB128(b, uint64(len(fn.Units)))
+		b = appendULEB128(b, uint64(stab.Lookup(fn.Name)))
+		b = appendULEB128(b, uint64(stab.Lookup(fn.SrcFile)))
+		for _, u := range fn.Units {
+			b = appendULEB128(b, uint64(u.StLine))
+			b = appendULEB128(b, uint64(u.StCol))
+			b = appendULEB128(b, uint64(u.EnLine))
+			b = appendULEB128(b, uint64(u.EnCol))
+			b = appendULEB128(b, uint64(u.NxStmts))
+		}
+		lit := uint64(0)
+		if fn.Lit {
+			lit = 1
+		}
+		encoded[i] = appendULEB128(b, lit)
+	}
+	strtab := stab.Append(nil)
+
+	// The function offsets are relative to the start of the payload,
+	// and follow the header, the offsets themselves and the string
+	// table.
+	var body []byte
+	foff := uint32(covMetaHeaderSize + 4*len(funcs) + len(strtab))
+	for _, b := range encoded {
+		body = binary.LittleEndian.AppendUint32(body, foff)
+		foff += uint32(len(b))
+	}
+	body = append(body, strtab...)
+	for _, b := range encoded {
+		body = append(body, b...)
+	}
+
+	hdr := metaSymbolHeader{
+		Length:     uint32(covMetaHeaderSize + len(body)),
+		PkgName:    pkgName,
+		PkgPath:    pkgPath,
+		ModulePath: modPath,
+		MetaHash:   md5.Sum(body),
+		NumFiles:   uint32(stab.Entries()),
+		NumFuncs:   uint32(len(funcs)),
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, hdr)
+	buf.Write(body)
+	return buf.Bytes(), hdr.MetaHash
+}
+
+// encodeMetaFile encodes a meta-data file holding the package payloads
+// 'blobs', whose hashes are 'hashes'. It returns the file contents and
+// the meta-data file hash, which also depends on the counter mode and
+// granularity, so that pods only differing in those don't collide.
+func encodeMetaFile(blobs [][]byte, hashes [][16]byte, mode counterMode, gran CounterGranularity) ([]byte, [16]byte) {
+	h := md5.New()
+	for _, ph := range hashes {
+		h.Write(ph[:])
+	}
+	h.Write([]byte{byte(mode), byte(gran)})
+	var fileHash [16]byte
+	copy(fileHash[:], h.Sum(nil))
+
+	strtab := newSWriter().Append(nil)
+	hdrSize := uint64(unsafe.Sizeof(metaFileHeader{}))
+	stOffset := hdrSize + uint64(16*len(blobs))
+	preambleLength := stOffset + uint64(len(strtab))
+	total := preambleLength
+	for _, b := range blobs {
+		total += uint64(len(b))
+	}
+
+	hdr := metaFileHeader{
+		Magic:        covMetaMagic,
+		Version:      metaFileVersion,
+		TotalLength:  total,
+		Entries:      uint64(len(blobs)),
+		MetaFileHash: fileHash,
+		StrTabOffset: uint32(stOffset),
+		StrTabLength: uint32(len(strtab)),
+		CMode:        mode,
+		CGranularity: gran,
+	}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, hdr)
+	off := preambleLength
+	for _, b := range blobs {
+		binary.Write(&buf, binary.LittleEndian, off)
+		off += uint64(len(b))
+	}
+	for _, b := range blobs {
+		binary.Write(&buf, binary.LittleEndian, uint64(len(b)))
+	}
+	buf.Write(strtab)
+	for _, b := range blobs {
+		buf.Write(b)
+	}
+	return buf.Bytes(), fileHash
+}
@@ -0,0 +1,42 @@
+package gocov
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// RunAndCollect runs 'cmd', a binary built with "go build -cover", and
+// reads the coverage data it wrote. The command is run with GOCOVERDIR
+// set to a temporary directory, which is removed afterwards; any
+// GOCOVERDIR of the environment of 'cmd' is overridden. The command
+// must not have been started yet.
+func RunAndCollect(cmd *exec.Cmd, matchPkgs []string) (*Coverage, error) {
+	dir, err := os.MkdirTemp("", "gocov")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	// The last value of a variable takes precedence.
+	cmd.Env = append(env[:len(env):len(env)], "GOCOVERDIR="+dir)
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("running %s: %v", cmd.Path, err)
+	}
+
+	data, err := ReadDir(dir, matchPkgs)
+	if err != nil {
+		return nil, err
+	}
+	if len(data.PodData) == 0 {
+		return nil, fmt.Errorf("%s wrote no coverage data (not built with -cover?)", cmd.Path)
+	}
+	return &Coverage{
+		config: CoverageConfig{MatchPkgs: matchPkgs},
+		Data:   data,
+	}, nil
+}
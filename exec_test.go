@@ -0,0 +1,83 @@
+package gocov
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// buildProg builds the program of the coverage data fixtures, in set
+// mode, and returns the path of the executable.
+func buildProg(t *testing.T) string {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping build of instrumented program in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	exe := filepath.Join(t.TempDir(), "prog")
+	cmd := exec.Command("go", "build", "-cover", "-covermode=set", "-o", exe, ".")
+	cmd.Dir = filepath.Join(srcDir, "example.com", "prog")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("building prog: %v\n%s", err, out)
+	}
+	return exe
+}
+
+func TestRunAndCollect(t *testing.T) {
+	exe := buildProg(t)
+	// Keep RunAndCollect's temporary directory where the test can see
+	// that it is removed.
+	tmp := t.TempDir()
+	t.Setenv("TMPDIR", tmp)
+
+	// A run with an argument covers what the two runs of the fixture
+	// do. Any GOCOVERDIR of the command's environment is overridden.
+	var stdout strings.Builder
+	cmd := exec.Command(exe, "arg")
+	cmd.Env = append(os.Environ(), "GOCOVERDIR=/nonexistent")
+	cmd.Stdout = &stdout
+	c, err := RunAndCollect(cmd, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, want := stdout.String(), "3\nnever\n1\n"; out != want {
+		t.Errorf("prog printed %q, want %q", out, want)
+	}
+	want := readTestCoverage(t, setDir)
+	if got := c.GetPercent(); got != want.GetPercent() {
+		t.Errorf("GetPercent = %v, want %v", got, want.GetPercent())
+	}
+	if got := c.FilePercents(); !reflect.DeepEqual(got, want.FilePercents()) {
+		t.Errorf("FilePercents = %v, want %v", got, want.FilePercents())
+	}
+
+	c, err = RunAndCollect(exec.Command(exe), []string{"example.com/prog/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			if pack.ImportPath != "example.com/prog/lib" && len(pack.Funcs) != 0 {
+				t.Errorf("read functions of package %s not matching example.com/prog/lib", pack.ImportPath)
+			}
+		}
+	}
+
+	// A command that writes no coverage data, or can't be run, is an
+	// error.
+	if _, err := RunAndCollect(exec.Command("go", "env", "GOOS"), nil); err == nil || !strings.Contains(err.Error(), "wrote no coverage data") {
+		t.Errorf("RunAndCollect of uninstrumented command: error %v", err)
+	}
+	if _, err := RunAndCollect(exec.Command(filepath.Join(tmp, "nonexistent")), nil); err == nil {
+		t.Error("RunAndCollect of a missing command succeeded")
+	}
+
+	if ents, err := os.ReadDir(tmp); err != nil || len(ents) != 0 {
+		t.Errorf("temporary directory holds %v (%v), want nothing", ents, err)
+	}
+}
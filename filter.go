@@ -0,0 +1,86 @@
+package gocov
+
+// filter returns a copy of the coverage data that holds only the units
+// for which 'keep' returns true. Functions and packages left without
+// any units are dropped, as are pods left without any packages.
+func (c *CoverageData) filter(keep func(pack *Package, fn *Func, u *FuncUnit) bool) *CoverageData {
+	out := &CoverageData{
+		PodData:    make(map[string]*PodData),
+		overflowed: c.overflowed,
+		overflows:  c.overflows,
+	}
+	for pName, p := range c.PodData {
+		for packName, pa := range p.Packages {
+			for fName, f := range pa.Funcs {
+				var units []*FuncUnit
+				for _, u := range f.Units {
+					if keep(pa, f, u) {
+						unit := *u
+						units = append(units, &unit)
+					}
+				}
+				if len(units) == 0 {
+					continue
+				}
+				outPod, ok := out.PodData[pName]
+				if !ok {
+					outPod = &PodData{
+						CounterGranularity: p.CounterGranularity,
+						CounterMode:        p.CounterMode,
+						Packages:           make(map[uint32]*Package),
+						Provenance:         p.Provenance,
+					}
+					out.PodData[pName] = outPod
+				}
+				outPack, ok := outPod.Packages[packName]
+				if !ok {
+					outPack = &Package{
+						ID:         pa.ID,
+						Name:       pa.Name,
+						ImportPath: pa.ImportPath,
+						ModulePath: pa.ModulePath,
+						NumFuncs:   pa.NumFuncs,
+						MetaHash:   pa.MetaHash,
+						Funcs:      make(map[uint32]*Func),
+					}
+					outPod.Packages[packName] = outPack
+				}
+				outPack.Funcs[fName] = &Func{
+					Name:         f.Name,
+					SrcFile:      f.SrcFile,
+					Units:        units,
+					Lit:          f.Lit,
+					Instrumented: f.Instrumented,
+					SrcHash:      f.SrcHash,
+				}
+			}
+		}
+	}
+	return out
+}
+
+// LineRange is an inclusive range of source lines.
+type LineRange struct {
+	Start, End int
+}
+
+// RestrictToRanges returns a copy of the coverage restricted to the
+// given line ranges of each source file, for example the hunks of a
+// diff. A unit is kept, with all of its statements, if any of the lines
+// it spans falls within one of the ranges for its file; statement
+// counts are not prorated for units that only partially overlap.
+// Files without ranges are dropped entirely.
+func (c *Coverage) RestrictToRanges(ranges map[string][]LineRange) *Coverage {
+	data := c.Data.filter(func(pack *Package, fn *Func, u *FuncUnit) bool {
+		for _, r := range ranges[fn.SrcFile] {
+			if int(u.StLine) <= r.End && int(u.EnLine) >= r.Start {
+				return true
+			}
+		}
+		return false
+	})
+	return &Coverage{
+		config: c.config,
+		Data:   data,
+	}
+}
@@ -0,0 +1,57 @@
+package gocov
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRestrictToRanges(t *testing.T) {
+	spanning := testFunc("Spanning", "/src/pkg/a.go", []uint32{10}, []uint32{1})
+	spanning.Units[0].EnLine, spanning.Units[0].NxStmts = 14, 4
+	c := &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+		"pod": {
+			CounterMode:        CtrModeSet,
+			CounterGranularity: CtrGranularityPerBlock,
+			Packages: map[uint32]*Package{
+				0: testPackage("example.com/test/pkg",
+					testFunc("Lines", "/src/pkg/a.go", []uint32{3, 4, 5}, []uint32{1, 0, 1}),
+					spanning,
+					testFunc("Other", "/src/pkg/b.go", []uint32{4}, []uint32{1}),
+				),
+			},
+		},
+	}}}
+
+	// A hunk covering line 4 and one overlapping the end of the
+	// spanning unit.
+	got := c.RestrictToRanges(map[string][]LineRange{
+		"/src/pkg/a.go": {{Start: 4, End: 4}, {Start: 12, End: 20}},
+	})
+	pack := got.Data.PodData["pod"].Packages[0]
+	if len(pack.Funcs) != 2 {
+		t.Fatalf("kept %d funcs, want 2", len(pack.Funcs))
+	}
+	lines := pack.Funcs[0]
+	if len(lines.Units) != 1 || lines.Units[0].StLine != 4 {
+		t.Errorf("Lines: kept units %+v, want the one on line 4", lines.Units)
+	}
+	// The partially overlapping unit is kept whole.
+	if u := pack.Funcs[1].Units; len(u) != 1 || *u[0] != *spanning.Units[0] {
+		t.Errorf("Spanning: kept units %+v, want %+v", u, spanning.Units)
+	}
+	// Statements: 1 of 1 on line 4 is uncovered, 4 of 4 in the
+	// spanning unit are covered.
+	if pct := got.GetPercent(); pct != 80 {
+		t.Errorf("restricted coverage is %.2f%%, want 80%%", pct)
+	}
+
+	// The original coverage is left alone.
+	if n := len(c.Data.PodData["pod"].Packages[0].Funcs[0].Units); n != 3 {
+		t.Errorf("original Lines has %d units after restricting, want 3", n)
+	}
+
+	// Without ranges nothing is kept.
+	if got := c.RestrictToRanges(nil); !reflect.DeepEqual(got.Data.PodData, map[string]*PodData{}) {
+		t.Errorf("restricting to no ranges kept %v", got.Data.PodData)
+	}
+}
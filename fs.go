@@ -0,0 +1,47 @@
+package gocov
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+)
+
+// ReadFS is like ReadDir, but reads the coverage data files in 'dir'
+// from the file system 'fsys', for example an embed.FS holding fixture
+// coverage data. The files are buffered in memory before decoding.
+func ReadFS(fsys fs.FS, dir string, matchPkgs []string) (*CoverageData, error) {
+	dents, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string][]byte)
+	names := []string{}
+	for _, e := range dents {
+		if e.IsDir() {
+			continue
+		}
+		name := path.Join(dir, e.Name())
+		b, err := fs.ReadFile(fsys, name)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", name, err)
+		}
+		files[name] = b
+		names = append(names, name)
+	}
+
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+
+	vis := &covDataVisitor{
+		cm:   &merger{},
+		data: data,
+	}
+	reader := makeCovDataPodReader(vis, collectPodsImpl(names), matchPkgs...)
+	reader.files = files
+	err = reader.Visit()
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
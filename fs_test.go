@@ -0,0 +1,29 @@
+package gocov
+
+import (
+	"embed"
+	"testing"
+)
+
+//go:embed testdata/covdata
+var covdataFS embed.FS
+
+func TestReadFS(t *testing.T) {
+	for _, dir := range []string{setDir, countDir, variantDir} {
+		got, err := ReadFS(covdataFS, dir, nil)
+		if err != nil {
+			t.Fatalf("ReadFS(%s): %v", dir, err)
+		}
+		samePods(t, got, readTestDir(t, dir))
+	}
+
+	got, err := ReadFS(covdataFS, countDir, []string{"example.com/prog/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, countDir, "example.com/prog/lib"))
+
+	if _, err := ReadFS(covdataFS, "testdata/covdata/missing", nil); err == nil {
+		t.Error("ReadFS of a missing directory succeeded")
+	}
+}
@@ -0,0 +1,479 @@
+package gocov
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// FuncRef identifies an instrumented function independently of the
+// pod (binary) it was read from.
+type FuncRef struct {
+	ImportPath string
+	Name       string
+	SrcFile    string
+	// Covered is true if any unit of the function was executed.
+	Covered bool
+}
+
+type funcKey struct {
+	importPath, name, srcFile string
+}
+
+// funcRefs returns the functions of 'c' keyed by import path, name and
+// source file. A function is covered if it is covered in any pod.
+func (c *CoverageData) funcRefs() map[funcKey]*FuncRef {
+	refs := make(map[funcKey]*FuncRef)
+	for _, p := range c.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				key := funcKey{pack.ImportPath, fn.Name, fn.SrcFile}
+				ref, ok := refs[key]
+				if !ok {
+					ref = &FuncRef{
+						ImportPath: pack.ImportPath,
+						Name:       fn.Name,
+						SrcFile:    fn.SrcFile,
+					}
+					refs[key] = ref
+				}
+				for _, u := range fn.Units {
+					ref.Covered = ref.Covered || u.Covered()
+				}
+			}
+		}
+	}
+	return refs
+}
+
+// AllFunctions returns the union of the functions of all pods, with
+// functions that appear in several pods reported once.
+func (c *CoverageData) AllFunctions() []FuncRef {
+	out := []FuncRef{}
+	for _, ref := range c.funcRefs() {
+		out = append(out, *ref)
+	}
+	sortFuncRefs(out)
+	return out
+}
+
+func sortFuncRefs(refs []FuncRef) {
+	sort.Slice(refs, func(i, j int) bool {
+		ri, rj := refs[i], refs[j]
+		if ri.ImportPath != rj.ImportPath {
+			return ri.ImportPath < rj.ImportPath
+		}
+		if ri.SrcFile != rj.SrcFile {
+			return ri.SrcFile < rj.SrcFile
+		}
+		return ri.Name < rj.Name
+	})
+}
+
+// FuncCoverageByName returns a copy of the function 'funcName' of the
+// package with import path 'pkgPath', taken from the first pod, in
+// meta-data hash order, that contains it. Methods are named as in the
+// meta-data, e.g. "*T.Method". ReadFuncByName does the same lookup
+// directly on a coverage data directory, without decoding all of it.
+func (c *Coverage) FuncCoverageByName(pkgPath, funcName string) (*Func, bool) {
+	for _, hash := range sortedKeys(c.Data.PodData) {
+		p := c.Data.PodData[hash]
+		for _, pkIdx := range sortedKeys(p.Packages) {
+			pack := p.Packages[pkIdx]
+			if pack.ImportPath != pkgPath {
+				continue
+			}
+			for _, fnIdx := range sortedKeys(pack.Funcs) {
+				fn := pack.Funcs[fnIdx]
+				if fn.Name != funcName {
+					continue
+				}
+				out := &Func{
+					Name:         fn.Name,
+					SrcFile:      fn.SrcFile,
+					Units:        make([]*FuncUnit, len(fn.Units)),
+					Lit:          fn.Lit,
+					Instrumented: fn.Instrumented,
+					SrcHash:      fn.SrcHash,
+				}
+				for i, u := range fn.Units {
+					unit := *u
+					out.Units[i] = &unit
+				}
+				return out, true
+			}
+		}
+	}
+	return nil, false
+}
+
+// ReadFuncByName reads the function 'funcName' of the package with
+// import path 'pkgPath' from the coverage data directory 'dir', as
+// FuncCoverageByName would find it in the result of ReadDir, without
+// building the whole tree: packages are skipped by their path, and the
+// function is located by name before only it is decoded. Function
+// literals are found by the names FuncCoverageByName knows them by,
+// such as "Outer.func1", which takes decoding the other functions of
+// their package to name them. Only its counter payloads are merged across the counter data files of the
+// pod, which is the first one, in meta-data hash order, to contain it.
+func ReadFuncByName(dir, pkgPath, funcName string) (*Func, bool, error) {
+	pods, err := collectPods(dir)
+	if err != nil {
+		return nil, false, fmt.Errorf("reading inputs: %v", err)
+	}
+	sort.Slice(pods, func(i, j int) bool {
+		return pods[i].hash() < pods[j].hash()
+	})
+	for _, p := range pods {
+		fn, ok, err := readPodFunc(p, pkgPath, funcName)
+		if err != nil || ok {
+			return fn, ok, err
+		}
+	}
+	return nil, false, nil
+}
+
+// readPodFunc reads the function 'funcName' of package 'pkgPath' from
+// the pod 'p', if the pod has it.
+func readPodFunc(p Pod, pkgPath, funcName string) (*Func, bool, error) {
+	b, err := os.ReadFile(p.MetaFile)
+	if err != nil {
+		return nil, false, fmt.Errorf("unable to open meta-file %s", p.MetaFile)
+	}
+	if len(b) < int(unsafe.Sizeof(metaFileHeader{})) {
+		fmt.Fprintf(os.Stderr, "warning: skipping meta-file %s: too short (%d bytes) to contain a header\n", p.MetaFile, len(b))
+		return nil, false, nil
+	}
+	mfr, err := newCoverageMetaFileReader(bytes.NewReader(b), b)
+	if err != nil {
+		return nil, false, fmt.Errorf("decoding meta-file %s: %s", p.MetaFile, err)
+	}
+	var payload []byte
+	for pkIdx := uint32(0); pkIdx < uint32(mfr.NumPackages()); pkIdx++ {
+		path, _, pp, err := mfr.PeekPackagePath(pkIdx, payload)
+		if err != nil {
+			return nil, false, fmt.Errorf("reading pkg %d from meta-file %s: %s", pkIdx, p.MetaFile, err)
+		}
+		payload = pp
+		if path != pkgPath {
+			continue
+		}
+		pd, err := newCoverageMetaDataDecoder(pp, true, nil)
+		if err != nil {
+			return nil, false, fmt.Errorf("reading pkg %d from meta-file %s: %s", pkIdx, p.MetaFile, err)
+		}
+		var fd funcDesc
+		fnIdx, ok := pd.FindFunc(funcName)
+		if ok {
+			if err := pd.ReadFunc(fnIdx, &fd); err != nil {
+				return nil, false, fmt.Errorf("reading meta-data file: %v", err)
+			}
+			// Literals are known by the names nameLiterals gives them,
+			// not by the names they have in the meta-data.
+			ok = !fd.Lit
+		}
+		if !ok && strings.Contains(funcName, ".func") {
+			if fnIdx, ok, err = findLiteral(pd, funcName, &fd); err != nil {
+				return nil, false, fmt.Errorf("reading meta-data file: %v", err)
+			}
+		}
+		if !ok {
+			return nil, false, nil
+		}
+
+		m := &merger{}
+		if err := m.SetModeAndGranularity(mfr.CounterMode(), mfr.CounterGranularity()); err != nil {
+			return nil, false, err
+		}
+		var counters, runs []uint32
+		r := &covDataReader{}
+		for _, cdf := range p.CounterDataFiles {
+			cf, err := r.readCounterDataFile(cdf)
+			if err != nil {
+				return nil, false, err
+			}
+			for _, data := range cf.payloads {
+				if data.PkgIdx != pkIdx || data.FuncIdx != fnIdx {
+					continue
+				}
+				if counters == nil {
+					counters = make([]uint32, len(data.Counters))
+					runs = make([]uint32, len(data.Counters))
+				}
+				if err, _ := m.MergeCounters(counters, data.Counters); err != nil {
+					return nil, false, fmt.Errorf("reading counter data file %s: %v", cdf, err)
+				}
+				for i, c := range data.Counters {
+					if c != 0 {
+						runs[i]++
+					}
+				}
+			}
+		}
+		return &Func{
+			Name:         funcName,
+			SrcFile:      fd.Srcfile,
+			Units:        funcUnits(&fd, counters, runs, mfr.CounterGranularity() == CtrGranularityPerFunc),
+			Lit:          fd.Lit,
+			Instrumented: true,
+		}, true, nil
+	}
+	return nil, false, nil
+}
+
+// findLiteral returns the index of the function literal of the package
+// decoded by 'pd' that nameLiterals names 'name', reading it into 'fd'.
+// Naming literals takes the positions of all functions of the package,
+// so they are all decoded.
+func findLiteral(pd *coverageMetaDataDecoder, name string, fd *funcDesc) (uint32, bool, error) {
+	pack := &Package{Funcs: make(map[uint32]*Func)}
+	for fnIdx := uint32(0); fnIdx < pd.NumFuncs(); fnIdx++ {
+		if err := pd.ReadFunc(fnIdx, fd); err != nil {
+			return 0, false, err
+		}
+		pack.Funcs[fnIdx] = &Func{
+			Name:    fd.Funcname,
+			SrcFile: fd.Srcfile,
+			Units:   funcUnits(fd, nil, nil, false),
+			Lit:     fd.Lit,
+		}
+	}
+	pack.nameLiterals()
+	for _, fnIdx := range sortedKeys(pack.Funcs) {
+		if fn := pack.Funcs[fnIdx]; fn.Lit && fn.Name == name {
+			return fnIdx, true, pd.ReadFunc(fnIdx, fd)
+		}
+	}
+	return 0, false, nil
+}
+
+// UncoveredFuncs returns the functions that no pod covers, that is
+// whose units have a zero count in every binary, with functions that
+// appear in several pods reported once.
+func (c *Coverage) UncoveredFuncs() []FuncRef {
+	out := []FuncRef{}
+	for _, ref := range c.Data.funcRefs() {
+		if !ref.Covered {
+			out = append(out, *ref)
+		}
+	}
+	sortFuncRefs(out)
+	return out
+}
+
+// NewUncoveredFuncs returns the functions of 'head' that are absent from
+// 'base', matched by import path, name and source file, and that 'head'
+// does not cover, i.e. new code that no test exercises.
+func NewUncoveredFuncs(base, head *CoverageData) []FuncRef {
+	baseRefs := base.funcRefs()
+	out := []FuncRef{}
+	for key, ref := range head.funcRefs() {
+		if _, ok := baseRefs[key]; ok || ref.Covered {
+			continue
+		}
+		out = append(out, *ref)
+	}
+	sortFuncRefs(out)
+	return out
+}
+
+// FuncStat summarizes the statement coverage of a function.
+type FuncStat struct {
+	Name    string
+	SrcFile string
+	// StartLine is the first line of the function's units.
+	StartLine int
+	// Covered and Total are the numbers of covered and of all
+	// statements; the function was executed if Covered is non-zero.
+	Covered, Total int
+}
+
+// FuncStats returns the statement coverage of every function of the
+// package, sorted by source file and start line.
+func (p *Package) FuncStats() []FuncStat {
+	stats := make([]FuncStat, 0, len(p.Funcs))
+	for _, fn := range p.Funcs {
+		st := FuncStat{Name: fn.Name, SrcFile: fn.SrcFile, StartLine: int(fn.StartLine())}
+		for _, u := range fn.Units {
+			st.Total += u.Weight()
+			if u.Covered() {
+				st.Covered += u.Weight()
+			}
+		}
+		stats = append(stats, st)
+	}
+	sort.Slice(stats, func(i, j int) bool {
+		si, sj := stats[i], stats[j]
+		if si.SrcFile != sj.SrcFile {
+			return si.SrcFile < sj.SrcFile
+		}
+		if si.StartLine != sj.StartLine {
+			return si.StartLine < sj.StartLine
+		}
+		return si.Name < sj.Name
+	})
+	return stats
+}
+
+// funcStmts returns the statement counts of every function, keyed as
+// by funcRefs. Units are identified by position, so a function present
+// in several pods counts each unit once, as covered if any pod covers
+// it.
+func (c *CoverageData) funcStmts() map[funcKey]*stmtCount {
+	units := make(map[funcKey]map[funit]bool)
+	stmts := make(map[funcKey]*stmtCount)
+	for _, p := range c.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				key := funcKey{pack.ImportPath, fn.Name, fn.SrcFile}
+				if _, ok := units[key]; !ok {
+					units[key] = make(map[funit]bool)
+					stmts[key] = &stmtCount{}
+				}
+				for _, u := range fn.Units {
+					uKey := funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}
+					units[key][uKey] = units[key][uKey] || u.Covered()
+				}
+			}
+		}
+	}
+	for key, us := range units {
+		sc := stmts[key]
+		for u, hit := range us {
+			sc.total += int(u.nstmts)
+			if hit {
+				sc.covered += int(u.nstmts)
+			}
+		}
+	}
+	return stmts
+}
+
+// FuncPercents returns the percentage of statements covered in every
+// function, keyed by "importpath.funcname". Functions without any
+// statements are left out, unless the IncludeEmptyFuncs option is set,
+// in which case they are reported as fully covered.
+func (c *Coverage) FuncPercents() map[string]float64 {
+	percents := make(map[string]float64)
+	for key, sc := range c.Data.funcStmts() {
+		name := key.importPath + "." + key.name
+		if sc.total == 0 {
+			if c.config.IncludeEmptyFuncs {
+				percents[name] = 100
+			}
+			continue
+		}
+		percents[name] = sc.percent()
+	}
+	return percents
+}
+
+// FunctionCoverage returns the number of functions with at least one
+// covered statement and the total number of functions. Functions
+// without any statements are left out, unless the IncludeEmptyFuncs
+// option is set, in which case they are counted as covered.
+func (c *Coverage) FunctionCoverage() (covered, total int) {
+	for _, sc := range c.Data.funcStmts() {
+		if sc.total == 0 && !c.config.IncludeEmptyFuncs {
+			continue
+		}
+		total++
+		if sc.total == 0 || sc.covered != 0 {
+			covered++
+		}
+	}
+	return covered, total
+}
+
+// FuncUncovered is a function along with the number of its statements
+// that no pod covers.
+type FuncUncovered struct {
+	ImportPath     string
+	Name           string
+	SrcFile        string
+	UncoveredStmts int
+}
+
+// UncoveredRanking returns the functions with uncovered statements,
+// those with the most uncovered statements first, so that a large
+// function with many uncovered statements ranks above a small one that
+// isn't covered at all. Functions that appear in several pods are
+// reported once.
+func (c *Coverage) UncoveredRanking() []FuncUncovered {
+	out := []FuncUncovered{}
+	for key, sc := range c.Data.funcStmts() {
+		if sc.covered == sc.total {
+			continue
+		}
+		out = append(out, FuncUncovered{
+			ImportPath:     key.importPath,
+			Name:           key.name,
+			SrcFile:        key.srcFile,
+			UncoveredStmts: sc.total - sc.covered,
+		})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		fi, fj := out[i], out[j]
+		if fi.UncoveredStmts != fj.UncoveredStmts {
+			return fi.UncoveredStmts > fj.UncoveredStmts
+		}
+		if fi.ImportPath != fj.ImportPath {
+			return fi.ImportPath < fj.ImportPath
+		}
+		if fi.SrcFile != fj.SrcFile {
+			return fi.SrcFile < fj.SrcFile
+		}
+		return fi.Name < fj.Name
+	})
+	return out
+}
+
+// nameLiterals gives each function literal of the package a name
+// derived from its position, in the style of the compiler: the name of
+// the innermost function enclosing it, or "glob" for none, followed by
+// ".func" and its 1-based rank by start line among the literals of
+// that function (nested literals are numbered in the same sequence).
+// Literals otherwise tend to share names, or have none, which makes
+// them clash when functions are aggregated by name.
+func (p *Package) nameLiterals() {
+	var lits, parents []uint32
+	for _, idx := range sortedKeys(p.Funcs) {
+		if p.Funcs[idx].Lit {
+			lits = append(lits, idx)
+		} else if len(p.Funcs[idx].Units) != 0 {
+			parents = append(parents, idx)
+		}
+	}
+	sort.SliceStable(lits, func(i, j int) bool {
+		fi, fj := p.Funcs[lits[i]], p.Funcs[lits[j]]
+		if fi.SrcFile != fj.SrcFile {
+			return fi.SrcFile < fj.SrcFile
+		}
+		return fi.StartLine() < fj.StartLine()
+	})
+	seq := make(map[string]int)
+	for _, idx := range lits {
+		lit := p.Funcs[idx]
+		var enclosing *Func
+		line := lit.StartLine()
+		for _, pIdx := range parents {
+			fn := p.Funcs[pIdx]
+			if fn.SrcFile != lit.SrcFile || line < fn.StartLine() || line > fn.EndLine() {
+				continue
+			}
+			if enclosing == nil || fn.StartLine() > enclosing.StartLine() {
+				enclosing = fn
+			}
+		}
+		parent := "glob"
+		if enclosing != nil {
+			parent = enclosing.Name
+		}
+		key := lit.SrcFile + "\x00" + parent
+		seq[key]++
+		lit.Name = fmt.Sprintf("%s.func%d", parent, seq[key])
+	}
+}
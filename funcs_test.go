@@ -0,0 +1,329 @@
+package gocov
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestAllFunctions(t *testing.T) {
+	data := &CoverageData{PodData: map[string]*PodData{
+		"pod1": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("F", "/src/a/a.go", []uint32{3}, []uint32{1}),
+				testFunc("G", "/src/a/a.go", []uint32{7}, []uint32{0}),
+			),
+		}},
+		"pod2": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("G", "/src/a/a.go", []uint32{7}, []uint32{1}),
+				testFunc("H", "/src/a/h.go", []uint32{3}, []uint32{0}),
+			),
+			1: testPackage("example.com/test/b",
+				testFunc("F", "/src/b/b.go", []uint32{3}, []uint32{0}),
+			),
+		}},
+	}}
+	want := []FuncRef{
+		{ImportPath: "example.com/test/a", Name: "F", SrcFile: "/src/a/a.go", Covered: true},
+		{ImportPath: "example.com/test/a", Name: "G", SrcFile: "/src/a/a.go", Covered: true},
+		{ImportPath: "example.com/test/a", Name: "H", SrcFile: "/src/a/h.go", Covered: false},
+		{ImportPath: "example.com/test/b", Name: "F", SrcFile: "/src/b/b.go", Covered: false},
+	}
+	if got := data.AllFunctions(); !reflect.DeepEqual(got, want) {
+		t.Errorf("AllFunctions() = %+v, want %+v", got, want)
+	}
+	if got := (&CoverageData{}).AllFunctions(); len(got) != 0 {
+		t.Errorf("AllFunctions() of no data = %+v", got)
+	}
+}
+
+func TestFindFunc(t *testing.T) {
+	b, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	mfr, err := newCoverageMetaFileReader(bytes.NewReader(b), b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := readTestDir(t, setDir)
+	for pkIdx, pack := range data.PodData[setHash].Packages {
+		pd, err := mfr.packageDecoder(pkIdx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for fnIdx, fn := range pack.Funcs {
+			if got, ok := pd.FindFunc(fn.Name); !ok || got != fnIdx {
+				t.Errorf("%s: FindFunc(%q) = %d, %v; want %d, true", pack.ImportPath, fn.Name, got, ok, fnIdx)
+			}
+		}
+		if _, ok := pd.FindFunc("Missing"); ok {
+			t.Errorf("%s: FindFunc found a missing function", pack.ImportPath)
+		}
+	}
+}
+
+func TestFuncByName(t *testing.T) {
+	// The pods are searched in meta-data hash order, so the count pod
+	// comes first.
+	dir := mergeTestDirs(t, setDir, countDir)
+	c := readTestCoverage(t, dir)
+	want := findFunc(c.Data, countHash, "example.com/prog/lib", "*T.Method")
+
+	got, ok := c.FuncCoverageByName("example.com/prog/lib", "*T.Method")
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("FuncCoverageByName = %+v, %v; want %+v", got, ok, want)
+	} else if got == want || got.Units[0] == want.Units[0] {
+		t.Error("FuncCoverageByName returned the function itself rather than a copy")
+	}
+
+	got, ok, err := ReadFuncByName(dir, "example.com/prog/lib", "*T.Method")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadFuncByName = %+v, %v; want %+v", got, ok, want)
+	}
+
+	for _, name := range [][2]string{{"example.com/prog/lib", "Missing"}, {"example.com/missing", "Add"}} {
+		if _, ok := c.FuncCoverageByName(name[0], name[1]); ok {
+			t.Errorf("FuncCoverageByName(%q, %q) found a function", name[0], name[1])
+		}
+		if _, ok, err := ReadFuncByName(dir, name[0], name[1]); ok || err != nil {
+			t.Errorf("ReadFuncByName(%q, %q) = %v, %v; want false, nil", name[0], name[1], ok, err)
+		}
+	}
+}
+
+func TestNewUncoveredFuncs(t *testing.T) {
+	base := &CoverageData{PodData: map[string]*PodData{
+		"base": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("Old", "/src/a/a.go", []uint32{3}, []uint32{0}),
+			),
+		}},
+	}}
+	head := &CoverageData{PodData: map[string]*PodData{
+		"head": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				// Existing code is not reported, covered or not.
+				testFunc("Old", "/src/a/a.go", []uint32{3}, []uint32{0}),
+				testFunc("NewCovered", "/src/a/a.go", []uint32{7}, []uint32{1}),
+				testFunc("NewUncovered", "/src/a/a.go", []uint32{11}, []uint32{0}),
+				// A function moved to another file counts as new.
+				testFunc("Old", "/src/a/moved.go", []uint32{3}, []uint32{0}),
+			),
+		}},
+	}}
+	want := []FuncRef{
+		{ImportPath: "example.com/test/a", Name: "NewUncovered", SrcFile: "/src/a/a.go"},
+		{ImportPath: "example.com/test/a", Name: "Old", SrcFile: "/src/a/moved.go"},
+	}
+	if got := NewUncoveredFuncs(base, head); !reflect.DeepEqual(got, want) {
+		t.Errorf("NewUncoveredFuncs = %+v, want %+v", got, want)
+	}
+
+	// The variant build adds Also to util, which no run covers.
+	want = []FuncRef{{ImportPath: "example.com/prog/util", Name: "Also", SrcFile: "example.com/prog/util/util.go"}}
+	if got := NewUncoveredFuncs(readTestDir(t, setDir), readTestDir(t, variantDir)); !reflect.DeepEqual(got, want) {
+		t.Errorf("variant build: NewUncoveredFuncs = %+v, want %+v", got, want)
+	}
+}
+
+func TestFuncStats(t *testing.T) {
+	later := testFunc("Later", "/src/a.go", []uint32{20, 21, 22}, []uint32{1, 1, 0})
+	later.Units[0].NxStmts = 3
+	pack := testPackage("example.com/test/a",
+		later,
+		testFunc("Other", "/src/b.go", []uint32{1}, []uint32{1}),
+		testFunc("Earlier", "/src/a.go", []uint32{3, 4}, []uint32{0, 0}),
+	)
+	want := []FuncStat{
+		{Name: "Earlier", SrcFile: "/src/a.go", StartLine: 3, Covered: 0, Total: 2},
+		{Name: "Later", SrcFile: "/src/a.go", StartLine: 20, Covered: 4, Total: 5},
+		{Name: "Other", SrcFile: "/src/b.go", StartLine: 1, Covered: 1, Total: 1},
+	}
+	if got := pack.FuncStats(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FuncStats() = %+v, want %+v", got, want)
+	}
+}
+
+func TestEmptyFuncs(t *testing.T) {
+	empty := testFunc("Empty", "/src/a.go", []uint32{9}, []uint32{0})
+	empty.Units[0].NxStmts = 0
+	data := &CoverageData{PodData: map[string]*PodData{
+		"pod": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("A", "/src/a.go", []uint32{3, 4}, []uint32{1, 0}),
+				testFunc("B", "/src/a.go", []uint32{6}, []uint32{0}),
+				empty,
+				// A function without any unit at all.
+				&Func{Name: "NoUnits", SrcFile: "/src/a.go", Instrumented: true},
+			),
+		}},
+	}}
+
+	c := &Coverage{Data: data}
+	if covered, total := c.FunctionCoverage(); covered != 1 || total != 2 {
+		t.Errorf("FunctionCoverage() = %d, %d; want 1, 2", covered, total)
+	}
+	want := map[string]float64{"example.com/test/a.A": 50, "example.com/test/a.B": 0}
+	if got := c.FuncPercents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FuncPercents() = %v, want %v", got, want)
+	}
+
+	c = &Coverage{config: CoverageConfig{IncludeEmptyFuncs: true}, Data: data}
+	if covered, total := c.FunctionCoverage(); covered != 3 || total != 4 {
+		t.Errorf("with IncludeEmptyFuncs: FunctionCoverage() = %d, %d; want 3, 4", covered, total)
+	}
+	want["example.com/test/a.Empty"] = 100
+	want["example.com/test/a.NoUnits"] = 100
+	if got := c.FuncPercents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("with IncludeEmptyFuncs: FuncPercents() = %v, want %v", got, want)
+	}
+}
+
+func TestFuncSpan(t *testing.T) {
+	data := readTestDir(t, setDir)
+	tests := []struct {
+		pkg, name  string
+		start, end uint32
+	}{
+		// The units of Add are out of line order: 4, 7, then 5-6.
+		{"example.com/prog/lib", "Add", 4, 7},
+		{"example.com/prog/lib", "unused", 11, 14},
+		{"example.com/prog", "main", 12, 16},
+	}
+	for _, tt := range tests {
+		fn := findFunc(data, setHash, tt.pkg, tt.name)
+		if start, end := fn.StartLine(), fn.EndLine(); start != tt.start || end != tt.end {
+			t.Errorf("%s spans lines %d-%d, want %d-%d", tt.name, start, end, tt.start, tt.end)
+		}
+	}
+	var empty Func
+	if start, end := empty.StartLine(), empty.EndLine(); start != 0 || end != 0 {
+		t.Errorf("function without units spans lines %d-%d, want 0-0", start, end)
+	}
+}
+
+func TestUncoveredFuncs(t *testing.T) {
+	// *T.Method and Never are covered in the set pod only, and Also,
+	// only built into the variant, is covered nowhere.
+	got := readTestCoverage(t, mergeTestDirs(t, setDir, variantDir)).UncoveredFuncs()
+	const lib, util = "example.com/prog/lib", "example.com/prog/util"
+	want := []FuncRef{
+		{ImportPath: lib, Name: "u.Exp", SrcFile: lib + "/lib.go"},
+		{ImportPath: lib, Name: "unused", SrcFile: lib + "/lib.go"},
+		{ImportPath: util, Name: "Also", SrcFile: util + "/util.go"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("UncoveredFuncs = %+v, want %+v", got, want)
+	}
+	if got := readTestCoverage(t, variantDir).UncoveredFuncs(); len(got) != 5 {
+		t.Errorf("variant pod alone: UncoveredFuncs = %+v, want 5 funcs", got)
+	}
+}
+
+func TestUncoveredRanking(t *testing.T) {
+	big := testFunc("Big", "p.go", make([]uint32, 10), make([]uint32, 10))
+	for i, u := range big.Units {
+		u.StLine, u.EnLine = uint32(10+i), uint32(10+i)
+		if i < 5 {
+			u.Count = 1
+		}
+	}
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/p",
+				testFunc("Tiny", "p.go", []uint32{3}, []uint32{0}),
+				big,
+				testFunc("Mid", "p.go", []uint32{30, 31, 32, 33}, []uint32{1, 0, 0, 0}),
+				testFunc("Full", "p.go", []uint32{40, 41}, []uint32{1, 1}),
+			),
+		},
+	})
+	const pkg = "example.com/test/p"
+	want := []FuncUncovered{
+		{ImportPath: pkg, Name: "Big", SrcFile: "p.go", UncoveredStmts: 5},
+		{ImportPath: pkg, Name: "Mid", SrcFile: "p.go", UncoveredStmts: 3},
+		{ImportPath: pkg, Name: "Tiny", SrcFile: "p.go", UncoveredStmts: 1},
+	}
+	if got := readTestCoverage(t, dir).UncoveredRanking(); !reflect.DeepEqual(got, want) {
+		t.Errorf("UncoveredRanking = %+v, want %+v", got, want)
+	}
+
+	// Functions of several pods are ranked once.
+	single := readTestCoverage(t, setDir).UncoveredRanking()
+	if got := readTestCoverage(t, mergeTestDirs(t, setDir, countDir)).UncoveredRanking(); len(got) != len(single) {
+		t.Errorf("two pods: UncoveredRanking = %+v, want %d funcs", got, len(single))
+	}
+}
+
+func TestLiteralNames(t *testing.T) {
+	outer := testFunc("Outer", "p.go", []uint32{3, 10}, []uint32{1, 1})
+	lit := func(line, count uint32) *Func {
+		fn := testFunc("", "p.go", []uint32{line}, []uint32{count})
+		fn.Lit = true
+		return fn
+	}
+	// The literals come in no particular order of index.
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/p", lit(8, 0), outer, lit(20, 1), lit(5, 1)),
+		},
+	})
+	c := readTestCoverage(t, dir)
+	want := map[string]float64{
+		"example.com/test/p.Outer":       100,
+		"example.com/test/p.Outer.func1": 100,
+		"example.com/test/p.Outer.func2": 0,
+		"example.com/test/p.glob.func1":  100,
+	}
+	if got := c.FuncPercents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FuncPercents = %v, want %v", got, want)
+	}
+	raw := c.RawCounters()
+	for name := range want {
+		if _, ok := raw[name]; !ok {
+			t.Errorf("RawCounters has no %s among %v", name, raw)
+		}
+	}
+	if len(raw) != len(want) {
+		t.Errorf("RawCounters = %v, want %d funcs", raw, len(want))
+	}
+
+	// Literals read through the cache, filled or not, get the same
+	// names.
+	cacheDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		data, err := ReadDirCached(dir, cacheDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (&Coverage{Data: data}).FuncPercents(); !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadDirCached %d: FuncPercents = %v, want %v", i, got, want)
+		}
+	}
+
+	// ReadFuncByName finds literals by the same names, and not by the
+	// names they have in the meta-data.
+	for _, name := range []string{"Outer", "Outer.func1", "Outer.func2", "glob.func1"} {
+		want, _ := c.FuncCoverageByName("example.com/test/p", name)
+		got, ok, err := ReadFuncByName(dir, "example.com/test/p", name)
+		if err != nil || !ok || !reflect.DeepEqual(got, want) {
+			t.Errorf("ReadFuncByName(%q) = %+v, %v, %v; want %+v", name, got, ok, err, want)
+		}
+	}
+	for _, name := range []string{"", "Outer.func3"} {
+		if _, ok, err := ReadFuncByName(dir, "example.com/test/p", name); ok || err != nil {
+			t.Errorf("ReadFuncByName(%q) = %v, %v; want false, nil", name, ok, err)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package gocov
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// ServeCoverageHandler returns an http.Handler that takes a fresh
+// snapshot of the coverage data of the running program on every
+// request. The response is a text profile, or the JSON encoding of
+// the coverage data if the request accepts "application/json". Every
+// request snapshots into its own buffers, so concurrent requests are
+// safe. As with GetCoverage, the program must be built with -cover
+// and -covermode=atomic for counters to be readable while it runs.
+// The response is encoded in full before it is sent, so that encoding
+// errors are reported with an error status; errors writing it out are
+// only logged.
+func ServeCoverageHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := GetCoverage(CoverageConfig{})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		var buf bytes.Buffer
+		contentType := "text/plain; charset=utf-8"
+		if strings.Contains(r.Header.Get("Accept"), "application/json") {
+			contentType = "application/json"
+			err = json.NewEncoder(&buf).Encode(c.Data)
+		} else {
+			err = c.WriteTextProfile(&buf)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("encoding coverage data: %v", err), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: writing coverage response: %v\n", err)
+		}
+	})
+}
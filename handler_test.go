@@ -0,0 +1,27 @@
+package gocov
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServeCoverageHandlerNotInstrumented(t *testing.T) {
+	// The test binary isn't built with -cover, so there is no coverage
+	// data to serve.
+	rec := httptest.NewRecorder()
+	ServeCoverageHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("status %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+	if body := rec.Body.String(); !strings.Contains(body, "no meta-data available") {
+		t.Errorf("body %q doesn't report the missing meta-data", body)
+	}
+}
+
+func TestServeCoverageHandlerConcurrent(t *testing.T) {
+	if out := runCovClient(t, "handler"); out != "ok\n" {
+		t.Errorf("covclient handler printed %q, want %q", out, "ok\n")
+	}
+}
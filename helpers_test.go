@@ -0,0 +1,193 @@
+package gocov
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// The coverage data under testdata/covdata was produced by the program
+// in testdata/src/example.com/prog:
+//
+//	set:     set mode, one run without and one with arguments
+//	count:   count mode, two runs without and one with arguments
+//	variant: set mode, one run without arguments, of a build whose util
+//	         package has an extra function (Also)
+const (
+	setDir     = "testdata/covdata/set"
+	countDir   = "testdata/covdata/count"
+	variantDir = "testdata/covdata/variant"
+	srcDir     = "testdata/src"
+
+	setHash     = "d70588dc733b3fe3303ca12cccf24685"
+	countHash   = "bf38ebf153191bc69b3407d42fc65602"
+	variantHash = "14d8df95ad3f87abd62465a40cf3df41"
+)
+
+// readTestDir reads 'dir' with ReadDir, failing the test on error.
+func readTestDir(t testing.TB, dir string, matchPkgs ...string) *CoverageData {
+	t.Helper()
+	data, err := ReadDir(dir, matchPkgs)
+	if err != nil {
+		t.Fatalf("ReadDir(%s): %v", dir, err)
+	}
+	return data
+}
+
+// readTestCoverage is like readTestDir, but wraps the data in a
+// Coverage.
+func readTestCoverage(t testing.TB, dir string, matchPkgs ...string) *Coverage {
+	t.Helper()
+	return &Coverage{
+		config: CoverageConfig{MatchPkgs: matchPkgs},
+		Data:   readTestDir(t, dir, matchPkgs...),
+	}
+}
+
+// copyTestDir copies the files of 'dir' into a new temporary
+// directory, for tests that modify them.
+func copyTestDir(t testing.TB, dir string) string {
+	t.Helper()
+	out := t.TempDir()
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		b, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(out, e.Name()), b, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return out
+}
+
+// findFunc returns the function 'name' of package 'pkgPath' in the pod
+// 'hash' of 'data', or nil if there is none.
+func findFunc(data *CoverageData, hash, pkgPath, name string) *Func {
+	p, ok := data.PodData[hash]
+	if !ok {
+		return nil
+	}
+	for _, pack := range p.Packages {
+		if pack.ImportPath != pkgPath {
+			continue
+		}
+		for _, fn := range pack.Funcs {
+			if fn.Name == name {
+				return fn
+			}
+		}
+	}
+	return nil
+}
+
+// unitCounts returns the counts of the units of 'fn'.
+func unitCounts(fn *Func) []uint32 {
+	counts := make([]uint32, len(fn.Units))
+	for i, u := range fn.Units {
+		counts[i] = u.Count
+	}
+	return counts
+}
+
+// samePods fails the test unless 'got' and 'want' hold the same pods,
+// packages, functions and units.
+func samePods(t testing.TB, got, want *CoverageData) {
+	t.Helper()
+	if !reflect.DeepEqual(got.PodData, want.PodData) {
+		gb, _ := json.Marshal(got.PodData)
+		wb, _ := json.Marshal(want.PodData)
+		t.Fatalf("pod data differs:\ngot  %s\nwant %s", gb, wb)
+	}
+}
+
+// writeCounterFile adds a counter data file holding 'payloads' and the
+// args section 'args' for the pod 'hash' to 'dir', named as if written
+// by process 'pid'.
+func writeCounterFile(t testing.TB, dir, hash string, pid int, args map[string]string, payloads []funcPayload) string {
+	t.Helper()
+	var metaHash [16]byte
+	if _, err := hex.Decode(metaHash[:], []byte(hash)); err != nil {
+		t.Fatal(err)
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s.%s.%d.%d", counterFilePref, hash, pid, pid))
+	if err := os.WriteFile(name, encodeCounterFile(metaHash, args, payloads), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+// writeTestPod writes 'p' as a GOCOVERDIR directory of its own and
+// returns the directory and the meta-data hash of the pod.
+func writeTestPod(t testing.TB, p *PodData) (dir, hash string) {
+	t.Helper()
+	dir = t.TempDir()
+	if err := writePod(dir, p); err != nil {
+		t.Fatal(err)
+	}
+	metas, err := filepath.Glob(filepath.Join(dir, metaFilePref+".*"))
+	if err != nil || len(metas) != 1 {
+		t.Fatalf("found meta-data files %v (%v), want one", metas, err)
+	}
+	return dir, Pod{MetaFile: metas[0]}.hash()
+}
+
+// testFunc returns a function of source file 'file' with a unit of one
+// statement on each of 'lines', whose counts are 'counts'.
+func testFunc(name, file string, lines []uint32, counts []uint32) *Func {
+	fn := &Func{Name: name, SrcFile: file, Instrumented: true}
+	for i, l := range lines {
+		fn.Units = append(fn.Units, &FuncUnit{StLine: l, StCol: 2, EnLine: l, EnCol: 20, NxStmts: 1, Count: counts[i]})
+	}
+	return fn
+}
+
+// testPackage returns a package holding 'funcs', indexed in order.
+func testPackage(importPath string, funcs ...*Func) *Package {
+	pack := &Package{
+		Name:       importPath[strings.LastIndex(importPath, "/")+1:],
+		ImportPath: importPath,
+		ModulePath: "example.com/test",
+		NumFuncs:   uint32(len(funcs)),
+		Funcs:      make(map[uint32]*Func),
+	}
+	for i, fn := range funcs {
+		pack.Funcs[uint32(i)] = fn
+	}
+	return pack
+}
+
+// captureStderr returns what 'f' writes to os.Stderr.
+func captureStderr(t testing.TB, f func()) string {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := os.Stderr
+	os.Stderr = w
+	done := make(chan []byte)
+	go func() {
+		b, _ := io.ReadAll(r)
+		done <- b
+	}()
+	defer func() {
+		os.Stderr = saved
+		w.Close()
+		r.Close()
+	}()
+	f()
+	os.Stderr = saved
+	w.Close()
+	return string(<-done)
+}
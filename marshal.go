@@ -0,0 +1,262 @@
+package gocov
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// The binary encoding of CoverageData is independent of the coverage
+// file formats. It is a magic string followed by a version and the
+// tree of pods, packages, functions and units, where every integer is
+// ULEB128 encoded, every string is its length followed by its bytes,
+// every hash is its 16 bytes, and every list or map is its length
+// followed by its elements (maps in increasing key order, so that
+// equal data encodes identically):
+//
+//	"gcvd" version flags
+//	pods: hash mode granularity provenance[key value] packages
+//	packages: id name importpath modulepath metahash numfuncs funcs
+//	funcs: index name srcfile flags srchash units
+//	units: stline stcol enline encol nxstmts count runscovered
+//
+// The flags of the data record whether a counter overflowed, and those
+// of a function whether it is a literal and whether it is instrumented.
+// Version 1 had no metahash and srchash; it is still decoded, with
+// zero hashes.
+const (
+	binMagic   = "gcvd"
+	binVersion = 2
+
+	binOverflowed = 1 << 0
+
+	binLit          = 1 << 0
+	binInstrumented = 1 << 1
+)
+
+// MarshalBinary encodes the coverage data in a compact binary format,
+// meant for shipping it between processes. Read statistics and the
+// sites of counter overflows are not encoded.
+func (c *CoverageData) MarshalBinary() ([]byte, error) {
+	b := append([]byte(nil), binMagic...)
+	b = appendULEB128(b, binVersion)
+	var flags uint64
+	if c.overflowed {
+		flags |= binOverflowed
+	}
+	b = appendULEB128(b, flags)
+
+	b = appendULEB128(b, uint64(len(c.PodData)))
+	for _, hash := range sortedKeys(c.PodData) {
+		p := c.PodData[hash]
+		b = appendString(b, hash)
+		b = appendULEB128(b, uint64(p.CounterMode))
+		b = appendULEB128(b, uint64(p.CounterGranularity))
+		b = appendULEB128(b, uint64(len(p.Provenance)))
+		for _, k := range sortedKeys(p.Provenance) {
+			b = appendString(b, k)
+			b = appendString(b, p.Provenance[k])
+		}
+		b = appendULEB128(b, uint64(len(p.Packages)))
+		for _, pkIdx := range sortedKeys(p.Packages) {
+			pack := p.Packages[pkIdx]
+			b = appendULEB128(b, uint64(pkIdx))
+			b = appendString(b, pack.Name)
+			b = appendString(b, pack.ImportPath)
+			b = appendString(b, pack.ModulePath)
+			b = append(b, pack.MetaHash[:]...)
+			b = appendULEB128(b, uint64(pack.NumFuncs))
+			b = appendULEB128(b, uint64(len(pack.Funcs)))
+			for _, fnIdx := range sortedKeys(pack.Funcs) {
+				fn := pack.Funcs[fnIdx]
+				b = appendULEB128(b, uint64(fnIdx))
+				b = appendString(b, fn.Name)
+				b = appendString(b, fn.SrcFile)
+				var fnFlags uint64
+				if fn.Lit {
+					fnFlags |= binLit
+				}
+				if fn.Instrumented {
+					fnFlags |= binInstrumented
+				}
+				b = appendULEB128(b, fnFlags)
+				b = append(b, fn.SrcHash[:]...)
+				b = appendULEB128(b, uint64(len(fn.Units)))
+				for _, u := range fn.Units {
+					for _, v := range [...]uint32{u.StLine, u.StCol, u.EnLine, u.EnCol, u.NxStmts, u.Count, u.RunsCovered} {
+						b = appendULEB128(b, uint64(v))
+					}
+				}
+			}
+		}
+	}
+	return b, nil
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendULEB128(b, uint64(len(s)))
+	return append(b, s...)
+}
+
+// UnmarshalBinary decodes coverage data encoded by MarshalBinary,
+// replacing the contents of 'c'.
+func (c *CoverageData) UnmarshalBinary(data []byte) error {
+	if len(data) < len(binMagic) || string(data[:len(binMagic)]) != binMagic {
+		return fmt.Errorf("invalid coverage data encoding: bad magic string")
+	}
+	d := &binDecoder{b: data[len(binMagic):]}
+	version := d.uleb()
+	if d.err == nil && (version < 1 || version > binVersion) {
+		return fmt.Errorf("coverage data encoding with unknown version %d (expected at most %d)", version, binVersion)
+	}
+	hashes := version >= 2
+	flags := d.uleb()
+
+	out := &CoverageData{
+		PodData:    make(map[string]*PodData),
+		overflowed: flags&binOverflowed != 0,
+	}
+	for np := d.count(); d.err == nil && np > 0; np-- {
+		hash := d.str()
+		p := &PodData{
+			CounterMode:        counterMode(d.uint32()),
+			CounterGranularity: CounterGranularity(d.uint32()),
+			Packages:           make(map[uint32]*Package),
+		}
+		if n := d.count(); n > 0 {
+			p.Provenance = make(map[string]string, n)
+			for ; d.err == nil && n > 0; n-- {
+				k := d.str()
+				p.Provenance[k] = d.str()
+			}
+		}
+		for npk := d.count(); d.err == nil && npk > 0; npk-- {
+			pkIdx := d.uint32()
+			pack := &Package{
+				ID:         pkIdx,
+				Name:       d.str(),
+				ImportPath: d.str(),
+				ModulePath: d.str(),
+				Funcs:      make(map[uint32]*Func),
+			}
+			if hashes {
+				pack.MetaHash = d.hash()
+			}
+			pack.NumFuncs = d.uint32()
+			for nf := d.count(); d.err == nil && nf > 0; nf-- {
+				fnIdx := d.uint32()
+				fn := &Func{
+					Name:    d.str(),
+					SrcFile: d.str(),
+				}
+				fnFlags := d.uleb()
+				fn.Lit = fnFlags&binLit != 0
+				fn.Instrumented = fnFlags&binInstrumented != 0
+				if hashes {
+					fn.SrcHash = d.hash()
+				}
+				nu := d.count()
+				fn.Units = make([]*FuncUnit, 0, nu)
+				for ; d.err == nil && nu > 0; nu-- {
+					fn.Units = append(fn.Units, &FuncUnit{
+						StLine:      d.uint32(),
+						StCol:       d.uint32(),
+						EnLine:      d.uint32(),
+						EnCol:       d.uint32(),
+						NxStmts:     d.uint32(),
+						Count:       d.uint32(),
+						RunsCovered: d.uint32(),
+					})
+				}
+				pack.Funcs[fnIdx] = fn
+			}
+			p.Packages[pkIdx] = pack
+		}
+		out.PodData[hash] = p
+	}
+	if d.err == nil && len(d.b) != 0 {
+		d.err = fmt.Errorf("%d bytes of trailing data", len(d.b))
+	}
+	if d.err != nil {
+		return fmt.Errorf("invalid coverage data encoding: %v", d.err)
+	}
+	*c = *out
+	return nil
+}
+
+// binDecoder reads the values of the binary encoding of CoverageData,
+// recording the first error; once it has failed, it returns only zero
+// values.
+type binDecoder struct {
+	b   []byte
+	err error
+}
+
+var errShortData = errors.New("unexpected end of data")
+
+func (d *binDecoder) uleb() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.b)
+	if n <= 0 {
+		if n == 0 {
+			d.err = errShortData
+		} else {
+			d.err = errors.New("integer overflows 64 bits")
+		}
+		return 0
+	}
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *binDecoder) uint32() uint32 {
+	v := d.uleb()
+	if v > math.MaxUint32 {
+		if d.err == nil {
+			d.err = fmt.Errorf("value %d overflows 32 bits", v)
+		}
+		return 0
+	}
+	return uint32(v)
+}
+
+// count reads the length of a list. As every element takes at least a
+// byte, a length beyond the remaining data is an error, which keeps a
+// corrupt length from causing a huge allocation.
+func (d *binDecoder) count() int {
+	v := d.uleb()
+	if v > uint64(len(d.b)) {
+		if d.err == nil {
+			d.err = errShortData
+		}
+		return 0
+	}
+	return int(v)
+}
+
+func (d *binDecoder) hash() [16]byte {
+	var h [16]byte
+	if d.err != nil {
+		return h
+	}
+	if len(d.b) < len(h) {
+		d.err = errShortData
+		return h
+	}
+	copy(h[:], d.b)
+	d.b = d.b[len(h):]
+	return h
+}
+
+func (d *binDecoder) str() string {
+	n := d.count()
+	if d.err != nil {
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
@@ -0,0 +1,125 @@
+package gocov
+
+import (
+	"encoding/json"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMarshalBinary(t *testing.T) {
+	for _, dir := range []string{setDir, countDir, variantDir} {
+		data := readTestDir(t, dir)
+		data.HashSources(os.DirFS(srcDir))
+		b, err := data.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got CoverageData
+		if err := got.UnmarshalBinary(b); err != nil {
+			t.Fatalf("%s: %v", dir, err)
+		}
+		samePods(t, &got, data)
+
+		// Equal data encodes identically.
+		again, err := got.MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(again) != string(b) {
+			t.Errorf("%s: decoded data encodes differently", dir)
+		}
+
+		// Every truncation of the encoding is an error.
+		for n := 0; n < len(b); n++ {
+			if err := new(CoverageData).UnmarshalBinary(b[:n]); err == nil {
+				t.Errorf("%s: %d of %d bytes decoded without error", dir, n, len(b))
+				break
+			}
+		}
+		if err := new(CoverageData).UnmarshalBinary(append(b, 0)); err == nil || !strings.Contains(err.Error(), "trailing data") {
+			t.Errorf("%s: trailing byte: error %v", dir, err)
+		}
+	}
+
+	data := readTestDir(t, countDir)
+	data.overflowed = true
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got CoverageData
+	if err := got.UnmarshalBinary(b); err != nil || !got.overflowed {
+		t.Errorf("overflowed data decoded with overflowed %v, error %v", got.overflowed, err)
+	}
+}
+
+func TestUnmarshalBinaryVersion1(t *testing.T) {
+	// A pod with a package holding a literal of one unit, in the
+	// version 1 encoding, without hashes.
+	b := []byte(binMagic)
+	for _, v := range []uint64{1, 0, 1} { // version, flags, pods
+		b = appendULEB128(b, v)
+	}
+	b = appendString(b, "pod")
+	for _, v := range []uint64{uint64(CtrModeCount), uint64(CtrGranularityPerBlock), 0, 1, 7} { // mode, granularity, provenance, packages, id
+		b = appendULEB128(b, v)
+	}
+	for _, s := range []string{"p", "example.com/p", "example.com"} {
+		b = appendString(b, s)
+	}
+	b = appendULEB128(b, 1) // numfuncs
+	b = appendULEB128(b, 1) // funcs
+	b = appendULEB128(b, 0) // index
+	b = appendString(b, "F")
+	b = appendString(b, "p.go")
+	for _, v := range []uint64{binLit | binInstrumented, 1, 3, 2, 3, 20, 1, 5, 1} { // flags, units, unit
+		b = appendULEB128(b, v)
+	}
+
+	var got CoverageData
+	if err := got.UnmarshalBinary(b); err != nil {
+		t.Fatal(err)
+	}
+	want := &CoverageData{PodData: map[string]*PodData{
+		"pod": {
+			CounterMode:        CtrModeCount,
+			CounterGranularity: CtrGranularityPerBlock,
+			Packages: map[uint32]*Package{
+				7: {ID: 7, Name: "p", ImportPath: "example.com/p", ModulePath: "example.com", NumFuncs: 1, Funcs: map[uint32]*Func{
+					0: {Name: "F", SrcFile: "p.go", Lit: true, Instrumented: true, Units: []*FuncUnit{
+						{StLine: 3, StCol: 2, EnLine: 3, EnCol: 20, NxStmts: 1, Count: 5, RunsCovered: 1},
+					}},
+				}},
+			},
+		},
+	}}
+	if !reflect.DeepEqual(&got, want) {
+		t.Errorf("decoded %+v, want %+v", got.PodData["pod"], want.PodData["pod"])
+	}
+
+	b[len(binMagic)] = binVersion + 1
+	if err := got.UnmarshalBinary(b); err == nil || !strings.Contains(err.Error(), "unknown version") {
+		t.Errorf("future version: error %v", err)
+	}
+	if err := got.UnmarshalBinary([]byte("gcvx")); err == nil {
+		t.Error("bad magic string decoded without error")
+	}
+}
+
+func TestMarshalBinarySize(t *testing.T) {
+	data := benchData(200, 10)
+	b, err := data.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Marshal(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Logf("binary encoding %d bytes, JSON %d bytes", len(b), len(j))
+	if 4*len(b) > len(j) {
+		t.Errorf("binary encoding of %d bytes not a quarter the size of JSON's %d", len(b), len(j))
+	}
+}
@@ -5,6 +5,7 @@
 package gocov
 
 import (
+	"fmt"
 	"io"
 	"os"
 
@@ -38,11 +39,11 @@ func newMreader(f *os.File) (*mReader, error) {
 
 func (r *mReader) Read(p []byte) (int, error) {
 	if r.fileView != nil {
-		amt := len(p)
-		toread := r.fileView[r.off:]
-		if len(toread) < 1 {
+		if r.off >= int64(len(r.fileView)) {
 			return 0, io.EOF
 		}
+		amt := len(p)
+		toread := r.fileView[r.off:]
 		if len(toread) < amt {
 			amt = len(toread)
 		}
@@ -55,11 +56,10 @@ func (r *mReader) Read(p []byte) (int, error) {
 
 func (r *mReader) ReadByte() (byte, error) {
 	if r.fileView != nil {
-		toread := r.fileView[r.off:]
-		if len(toread) < 1 {
+		if r.off >= int64(len(r.fileView)) {
 			return 0, io.EOF
 		}
-		rv := toread[0]
+		rv := r.fileView[r.off]
 		r.off++
 		return rv, nil
 	}
@@ -70,15 +70,20 @@ func (r *mReader) Seek(offset int64, whence int) (int64, error) {
 	if r.fileView == nil {
 		return r.rdr.MustSeek(offset, whence), nil
 	}
+	var off int64
 	switch whence {
 	case io.SeekStart:
-		r.off = offset
-		return offset, nil
+		off = offset
 	case io.SeekCurrent:
-		return r.off, nil
+		off = r.off + offset
 	case io.SeekEnd:
-		r.off = int64(len(r.fileView)) + offset
-		return r.off, nil
+		off = int64(len(r.fileView)) + offset
+	default:
+		panic("other modes not implemented")
+	}
+	if off < 0 {
+		return r.off, fmt.Errorf("seek to negative offset %d", off)
 	}
-	panic("other modes not implemented")
+	r.off = off
+	return off, nil
 }
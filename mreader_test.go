@@ -0,0 +1,37 @@
+package gocov
+
+import (
+	"io"
+	"math"
+	"testing"
+)
+
+func TestMReaderLargeOffset(t *testing.T) {
+	// A file view as mapped for files of 16KiB and more.
+	r := &mReader{fileView: make([]byte, 64)}
+	// An offset beyond what an int holds on 32-bit platforms reads as
+	// the end of the file rather than panicking.
+	for _, off := range []int64{1 << 40, math.MaxInt64} {
+		if _, err := r.Seek(off, io.SeekStart); err != nil {
+			t.Fatalf("Seek(%d): %v", off, err)
+		}
+		if n, err := r.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+			t.Errorf("Read at offset %d = %d, %v; want 0, EOF", off, n, err)
+		}
+		if _, err := r.ReadByte(); err != io.EOF {
+			t.Errorf("ReadByte at offset %d: error %v, want EOF", off, err)
+		}
+	}
+	if _, err := r.Seek(-1, io.SeekStart); err == nil {
+		t.Error("Seek to a negative offset succeeded")
+	}
+}
+
+func TestIntLen(t *testing.T) {
+	if n, err := intLen(math.MaxInt); n != math.MaxInt || err != nil {
+		t.Errorf("intLen(MaxInt) = %d, %v", n, err)
+	}
+	if _, err := intLen(math.MaxInt + 1); err == nil {
+		t.Error("intLen(MaxInt+1) succeeded")
+	}
+}
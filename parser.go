@@ -4,13 +4,25 @@
 
 package gocov
 
-import "bytes"
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
 
 type PodData struct {
 	CounterGranularity CounterGranularity
 	CounterMode        counterMode
 	// Number of functions in each package
 	Packages map[uint32]*Package
+	// Build configuration recorded in the counter data files, if any
+	Provenance map[string]string
 }
 
 type Package struct {
@@ -20,12 +32,63 @@ type Package struct {
 	ModulePath string
 	NumFuncs   uint32
 	Funcs      map[uint32]*Func
+	// MetaHash is the hash of the package's meta-data, identical
+	// across builds in which the package is unchanged.
+	MetaHash [16]byte
+}
+
+// QualifiedFile returns the source file of function 'fn' of the
+// package, qualified with the package import path if it is a bare file
+// name, so that same-named files of different packages (say, two
+// main.go) are told apart. Source file names with a directory are
+// returned as they are.
+func (p *Package) QualifiedFile(fn *Func) string {
+	if strings.ContainsAny(fn.SrcFile, `/\`) {
+		return fn.SrcFile
+	}
+	return p.ImportPath + "/" + fn.SrcFile
 }
 
 type Func struct {
 	Name    string
 	SrcFile string
 	Units   []*FuncUnit
+	// Lit is true if this is a function literal
+	Lit bool
+	// Instrumented is true for functions read from a meta-data file,
+	// whether or not any counter data refers to them, which tells
+	// them apart from functions made up by callers.
+	Instrumented bool
+	// SrcHash is the MD5 hash of the current content of SrcFile, read
+	// from the SourceFS of the coverage config or the file system given
+	// to HashSources, or zero if there is none or the file can't be read
+	// there. Comparing it against a known hash tells if the source
+	// changed since coverage was collected.
+	SrcHash [16]byte
+}
+
+// StartLine returns the first source line of the function's units, or
+// 0 if it has none.
+func (f *Func) StartLine() uint32 {
+	var line uint32
+	for i, u := range f.Units {
+		if i == 0 || u.StLine < line {
+			line = u.StLine
+		}
+	}
+	return line
+}
+
+// EndLine returns the last source line of the function's units, or 0
+// if it has none.
+func (f *Func) EndLine() uint32 {
+	var line uint32
+	for _, u := range f.Units {
+		if u.EnLine > line {
+			line = u.EnLine
+		}
+	}
+	return line
 }
 
 type FuncUnit struct {
@@ -33,13 +96,86 @@ type FuncUnit struct {
 	EnLine, EnCol uint32
 	NxStmts       uint32
 	Count         uint32
+	// RunsCovered is the number of runs (counter data file segments)
+	// that executed the unit, as opposed to Count, which in count mode
+	// is the total number of executions.
+	RunsCovered uint32
+}
+
+// Covered reports whether the unit was executed, that is whether its
+// count is nonzero.
+func (u *FuncUnit) Covered() bool {
+	return u.Count != 0
+}
+
+// Weight returns the number of statements of the unit, by which it
+// counts towards statement coverage.
+func (u *FuncUnit) Weight() int {
+	return int(u.NxStmts)
 }
 
 type CoverageData struct {
 	PodData map[string]*PodData
+
+	// overflowed records whether any counter saturated while reading
+	// or merging this data.
+	overflowed bool
+	// overflows records where counters saturated, as far as known.
+	overflows []OverflowSite
+	// stats records how much counter data was read to produce this
+	// data.
+	stats ReadStats
 }
 
 func ReadDir(dir string, matchPkgs []string) (*CoverageData, error) {
+	return readDir(dir, CoverageConfig{MatchPkgs: matchPkgs})
+}
+
+func readDir(dir string, c CoverageConfig) (*CoverageData, error) {
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+
+	vis, err := newCovDataVisitor(data, c)
+	if err != nil {
+		return nil, err
+	}
+	reader := makeCovDataDirReader(vis, dir, c.MatchPkgs...)
+	if c.InternStrings {
+		reader.intern = make(map[string]string)
+	}
+	reader.dupPolicy = c.DuplicateFuncs
+	reader.workers = c.CounterFileWorkers
+	err = reader.Visit()
+	if err != nil {
+		return nil, err
+	}
+	if vis.err != nil {
+		return nil, vis.err
+	}
+	if err := checkMatched(reader, c); err != nil {
+		return nil, err
+	}
+	applySourceOptions(data, c)
+	return data, nil
+}
+
+// ErrNoPackagesMatched is returned when the RequireMatch option is set
+// and the MatchPkgs patterns match no package.
+var ErrNoPackagesMatched = errors.New("no packages matched")
+
+// checkMatched enforces the RequireMatch option of 'c' after 'reader'
+// has visited the coverage data.
+func checkMatched(reader *covDataReader, c CoverageConfig) error {
+	if c.RequireMatch && len(c.MatchPkgs) != 0 && reader.matched == 0 {
+		return fmt.Errorf("%w by %s", ErrNoPackagesMatched, strings.Join(c.MatchPkgs, ","))
+	}
+	return nil
+}
+
+// ReadPods reads the coverage data from exactly the pods in 'pods',
+// without looking for any other coverage output files.
+func ReadPods(pods []Pod, matchPkgs []string) (*CoverageData, error) {
 	data := &CoverageData{
 		PodData: make(map[string]*PodData),
 	}
@@ -48,7 +184,7 @@ func ReadDir(dir string, matchPkgs []string) (*CoverageData, error) {
 		cm:   &merger{},
 		data: data,
 	}
-	reader := makeCovDataDirReader(vis, dir, matchPkgs...)
+	reader := makeCovDataPodReader(vis, pods, matchPkgs...)
 	err := reader.Visit()
 	if err != nil {
 		return nil, err
@@ -56,7 +192,75 @@ func ReadDir(dir string, matchPkgs []string) (*CoverageData, error) {
 	return data, nil
 }
 
-func ReadFromBuffer(meta, counters *bytes.Buffer, matchPkgs []string) (*CoverageData, error) {
+// MergeDirs reads the coverage data in each of 'dirs' and merges it
+// into a single data set, as Merge would. The directories are read one
+// pod at a time, so besides the merged data only a single pod is held
+// in memory at any point.
+func MergeDirs(dirs []string, matchPkgs []string) (*Coverage, error) {
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+	for _, dir := range dirs {
+		pods, err := collectPods(dir)
+		if err != nil {
+			return nil, fmt.Errorf("reading inputs from %s: %v", dir, err)
+		}
+		for _, p := range pods {
+			podData, err := ReadPods([]Pod{p}, matchPkgs)
+			if err != nil {
+				return nil, err
+			}
+			data.Merge(podData)
+			data.stats.FilesRead += podData.stats.FilesRead
+			data.stats.BytesRead += podData.stats.BytesRead
+		}
+	}
+	return &Coverage{
+		config: CoverageConfig{MatchPkgs: matchPkgs},
+		Data:   data,
+	}, nil
+}
+
+// ReadByHash reads the single pod in 'dir' whose meta-data hash starts
+// with 'hashPrefix'. It fails if no pod or more than one pod matches.
+func ReadByHash(dir, hashPrefix string, matchPkgs []string) (*Coverage, error) {
+	pods, err := collectPods(dir)
+	if err != nil {
+		return nil, fmt.Errorf("reading inputs: %v", err)
+	}
+	var found []Pod
+	for _, p := range pods {
+		if strings.HasPrefix(p.hash(), hashPrefix) {
+			found = append(found, p)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, fmt.Errorf("no meta-data file in %s matches hash prefix %q", dir, hashPrefix)
+	case 1:
+	default:
+		hashes := make([]string, len(found))
+		for i, p := range found {
+			hashes[i] = p.hash()
+		}
+		return nil, fmt.Errorf("hash prefix %q is ambiguous, matches %s", hashPrefix, strings.Join(hashes, ", "))
+	}
+	data, err := ReadPods(found, matchPkgs)
+	if err != nil {
+		return nil, err
+	}
+	return &Coverage{
+		config: CoverageConfig{MatchPkgs: matchPkgs},
+		Data:   data,
+	}, nil
+}
+
+// ReadMetaOnly reads only the meta-data files in 'dir', skipping all
+// counter data files. The resulting packages, functions and units are
+// the same as for ReadDir, but every unit has a zero count; this is
+// much cheaper when only an inventory of the instrumented code is
+// needed.
+func ReadMetaOnly(dir string, matchPkgs []string) (*CoverageData, error) {
 	data := &CoverageData{
 		PodData: make(map[string]*PodData),
 	}
@@ -65,10 +269,139 @@ func ReadFromBuffer(meta, counters *bytes.Buffer, matchPkgs []string) (*Coverage
 		cm:   &merger{},
 		data: data,
 	}
-	reader := makeCovDataBufferReader(vis, counters, meta, matchPkgs...)
+	reader := makeCovDataDirReader(vis, dir, matchPkgs...)
+	reader.metaOnly = true
 	err := reader.Visit()
 	if err != nil {
 		return nil, err
 	}
 	return data, nil
 }
+
+// MetaInfo describes a meta-data file as recorded in its header.
+type MetaInfo struct {
+	Mode        string
+	Granularity string
+	NumPackages uint64
+	Hash        [16]byte
+	Version     uint32
+	// StrTabOffset and StrTabLength locate the file-level string
+	// table, and StrTabEntries is the number of strings it holds, for
+	// checking meta-data files written by other producers.
+	StrTabOffset  uint32
+	StrTabLength  uint32
+	StrTabEntries int
+}
+
+// ReadMetaHeader reads only the header of the meta-data file 'path',
+// and the entry count that leads its string table, without decoding
+// any package, which is much cheaper than a full read when listing the
+// pods of a directory.
+func ReadMetaHeader(path string) (MetaInfo, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return MetaInfo{}, err
+	}
+	defer f.Close()
+	hdr, err := readMetaFileHeader(bufio.NewReader(f))
+	if err != nil {
+		return MetaInfo{}, fmt.Errorf("reading %s: %v", path, err)
+	}
+	info := MetaInfo{
+		Mode:         hdr.CMode.String(),
+		Granularity:  hdr.CGranularity.String(),
+		NumPackages:  hdr.Entries,
+		Hash:         hdr.MetaFileHash,
+		Version:      hdr.Version,
+		StrTabOffset: hdr.StrTabOffset,
+		StrTabLength: hdr.StrTabLength,
+	}
+	if hdr.StrTabLength != 0 {
+		if _, err := f.Seek(int64(hdr.StrTabOffset), io.SeekStart); err != nil {
+			return MetaInfo{}, fmt.Errorf("reading %s: %v", path, err)
+		}
+		n, err := binary.ReadUvarint(bufio.NewReader(io.LimitReader(f, int64(hdr.StrTabLength))))
+		if err != nil {
+			return MetaInfo{}, fmt.Errorf("reading %s: string table: %v", path, err)
+		}
+		if n > uint64(hdr.StrTabLength) {
+			return MetaInfo{}, fmt.Errorf("reading %s: string table of %d bytes claims %d entries", path, hdr.StrTabLength, n)
+		}
+		info.StrTabEntries = int(n)
+	}
+	return info, nil
+}
+
+func ReadFromBuffer(meta, counters *bytes.Buffer, matchPkgs []string) (*CoverageData, error) {
+	return readFromBuffer(meta, counters, CoverageConfig{MatchPkgs: matchPkgs})
+}
+
+func readFromBuffer(meta, counters *bytes.Buffer, c CoverageConfig) (*CoverageData, error) {
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+
+	vis, err := newCovDataVisitor(data, c)
+	if err != nil {
+		return nil, err
+	}
+	reader := makeCovDataBufferReader(vis, counters, meta, c.MatchPkgs...)
+	if c.InternStrings {
+		reader.intern = make(map[string]string)
+	}
+	reader.dupPolicy = c.DuplicateFuncs
+	err = reader.Visit()
+	if err != nil {
+		return nil, err
+	}
+	if vis.err != nil {
+		return nil, vis.err
+	}
+	if err := checkMatched(reader, c); err != nil {
+		return nil, err
+	}
+	applySourceOptions(data, c)
+	return data, nil
+}
+
+// SourceFiles returns the sorted set of source files containing
+// instrumented functions.
+func (c *CoverageData) SourceFiles() []string {
+	files := make(map[string]bool)
+	for _, p := range c.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				files[fn.SrcFile] = true
+			}
+		}
+	}
+	return sortedKeys(files)
+}
+
+// sortedKeys returns the keys of 'm' in increasing order.
+func sortedKeys[K ~string | ~uint32, V any](m map[K]V) []K {
+	keys := make([]K, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i] < keys[j]
+	})
+	return keys
+}
+
+// PodOf returns the sorted hashes of the pods (binaries) that contain
+// the package with import path 'importPath', and false if there are
+// none.
+func (c *CoverageData) PodOf(importPath string) ([]string, bool) {
+	var hashes []string
+	for _, hash := range sortedKeys(c.PodData) {
+		for _, pack := range c.PodData[hash].Packages {
+			if pack.ImportPath == importPath {
+				hashes = append(hashes, hash)
+				break
+			}
+		}
+	}
+	return hashes, len(hashes) != 0
+}
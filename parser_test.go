@@ -0,0 +1,442 @@
+package gocov
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestReadMetaOnly(t *testing.T) {
+	for _, dir := range []string{setDir, countDir, variantDir} {
+		got, err := ReadMetaOnly(dir, nil)
+		if err != nil {
+			t.Fatalf("ReadMetaOnly(%s): %v", dir, err)
+		}
+		want := readTestDir(t, dir)
+		for _, p := range want.PodData {
+			for _, pack := range p.Packages {
+				for _, fn := range pack.Funcs {
+					for _, u := range fn.Units {
+						u.Count, u.RunsCovered = 0, 0
+					}
+				}
+			}
+		}
+		samePods(t, got, want)
+		if s := got.stats; s.FilesRead != 0 || s.BytesRead != 0 {
+			t.Errorf("%s: read %d counter files (%d bytes), want none", dir, s.FilesRead, s.BytesRead)
+		}
+	}
+}
+
+func TestReadMetaOnlySkipsCounterFiles(t *testing.T) {
+	dir := copyTestDir(t, setDir)
+	// A counter data file that can't be decoded isn't even opened.
+	bad := filepath.Join(dir, counterFilePref+"."+setHash+".1.1")
+	if err := os.WriteFile(bad, bytes.Repeat([]byte("garbage "), 16), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDir(dir, nil); err == nil {
+		t.Fatal("ReadDir with a garbage counter data file succeeded")
+	}
+	if _, err := ReadMetaOnly(dir, nil); err != nil {
+		t.Fatalf("ReadMetaOnly: %v", err)
+	}
+}
+
+func TestReadPods(t *testing.T) {
+	metaFile := filepath.Join(setDir, metaFilePref+"."+setHash)
+	counterFiles, err := filepath.Glob(filepath.Join(setDir, counterFilePref+".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadPods([]Pod{{MetaFile: metaFile, CounterDataFiles: counterFiles}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, setDir))
+
+	// Only the listed counter data files are read: the run with
+	// arguments alone covers Never.
+	got, err = ReadPods([]Pod{{MetaFile: metaFile, CounterDataFiles: []string{setArgsCounterFile}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fn := findFunc(got, setHash, "example.com/prog/util", "Never"); !fn.Units[0].Covered() {
+		t.Error("Never not covered by the run with arguments")
+	}
+	if got.stats.FilesRead != 1 {
+		t.Errorf("read %d counter data files, want 1", got.stats.FilesRead)
+	}
+}
+
+func TestReadPodsAcrossDirs(t *testing.T) {
+	var pods []Pod
+	for _, dir := range []string{setDir, countDir} {
+		p, err := collectPods(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pods = append(pods, p...)
+	}
+	got, err := ReadPods(pods, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.PodData) != 2 || got.PodData[setHash] == nil || got.PodData[countHash] == nil {
+		t.Fatalf("got pods %v, want %s and %s", sortedKeys(got.PodData), setHash, countHash)
+	}
+	samePods(t, &CoverageData{PodData: map[string]*PodData{countHash: got.PodData[countHash]}}, readTestDir(t, countDir))
+}
+
+func TestReadPodsMissingMetaFile(t *testing.T) {
+	_, err := ReadPods([]Pod{{MetaFile: filepath.Join(t.TempDir(), metaFilePref+"."+setHash)}}, nil)
+	if err == nil {
+		t.Error("ReadPods of a missing meta-data file succeeded")
+	}
+}
+
+func TestSourceFiles(t *testing.T) {
+	data := &CoverageData{PodData: map[string]*PodData{
+		"pod1": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("A1", "/src/a/a.go", []uint32{3}, []uint32{1}),
+				testFunc("A2", "/src/a/a.go", []uint32{9}, []uint32{0}),
+				testFunc("Shared", "/src/shared.go", []uint32{5}, []uint32{1}),
+			),
+		}},
+		"pod2": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/b",
+				testFunc("B", "/src/b/b.go", []uint32{3}, []uint32{1}),
+				testFunc("Shared", "/src/shared.go", []uint32{5}, []uint32{0}),
+			),
+		}},
+	}}
+	want := []string{"/src/a/a.go", "/src/b/b.go", "/src/shared.go"}
+	if got := data.SourceFiles(); !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceFiles() = %q, want %q", got, want)
+	}
+}
+
+func TestInternStrings(t *testing.T) {
+	dir := mergeTestDirs(t, setDir, countDir)
+	got, err := readDir(dir, CoverageConfig{InternStrings: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, dir))
+
+	// The two pods share a single copy of each name.
+	a := findFunc(got, setHash, "example.com/prog/lib", "Add")
+	b := findFunc(got, countHash, "example.com/prog/lib", "Add")
+	if unsafe.StringData(a.SrcFile) != unsafe.StringData(b.SrcFile) {
+		t.Errorf("source file %q not shared between pods", a.SrcFile)
+	}
+	if unsafe.StringData(a.Name) != unsafe.StringData(b.Name) {
+		t.Errorf("function name %q not shared between pods", a.Name)
+	}
+}
+
+// BenchmarkReadDirInternStrings reads a directory of pods of builds
+// that share most of their packages, as a long-running test campaign
+// accumulates, and reports the heap retained by the result.
+func BenchmarkReadDirInternStrings(b *testing.B) {
+	const npods, nfuncs = 20, 500
+	dir := b.TempDir()
+	for i := 0; i < npods; i++ {
+		var funcs []*Func
+		for j := 0; j < nfuncs; j++ {
+			file := fmt.Sprintf("/src/example.com/test/big/file%02d.go", j%20)
+			funcs = append(funcs, testFunc(fmt.Sprintf("Func%04d", j), file, []uint32{3, 4}, []uint32{1, 0}))
+		}
+		// A package of its own gives every build a distinct hash.
+		build := testFunc(fmt.Sprintf("Build%02d", i), "/src/example.com/test/build.go", []uint32{3}, []uint32{1})
+		if err := writePod(dir, &PodData{
+			CounterMode:        CtrModeSet,
+			CounterGranularity: CtrGranularityPerBlock,
+			Packages: map[uint32]*Package{
+				0: testPackage("example.com/test/big", funcs...),
+				1: testPackage("example.com/test/build", build),
+			},
+		}); err != nil {
+			b.Fatal(err)
+		}
+	}
+	for _, intern := range []bool{false, true} {
+		b.Run(fmt.Sprintf("intern=%v", intern), func(b *testing.B) {
+			var ms runtime.MemStats
+			var retained uint64
+			for i := 0; i < b.N; i++ {
+				runtime.GC()
+				runtime.ReadMemStats(&ms)
+				before := ms.HeapAlloc
+				data, err := readDir(dir, CoverageConfig{InternStrings: intern})
+				if err != nil {
+					b.Fatal(err)
+				}
+				runtime.GC()
+				runtime.ReadMemStats(&ms)
+				retained += ms.HeapAlloc - before
+				runtime.KeepAlive(data)
+			}
+			b.ReportMetric(float64(retained)/float64(b.N), "retained-B/op")
+		})
+	}
+}
+
+// writeManyPackagesPod writes a pod of 'npkgs' packages of 'nfuncs'
+// functions each and returns its directory and hash.
+func writeManyPackagesPod(t testing.TB, npkgs, nfuncs int) (dir, hash string) {
+	t.Helper()
+	p := &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages:           make(map[uint32]*Package),
+	}
+	for i := 0; i < npkgs; i++ {
+		path := fmt.Sprintf("example.com/test/pkg%03d", i)
+		var funcs []*Func
+		for j := 0; j < nfuncs; j++ {
+			file := fmt.Sprintf("/src/%s/file%d.go", path, j%5)
+			funcs = append(funcs, testFunc(fmt.Sprintf("Func%03d", j), file, []uint32{3, 4, 5}, []uint32{1, 0, uint32(j % 2)}))
+		}
+		p.Packages[uint32(i)] = testPackage(path, funcs...)
+	}
+	return writeTestPod(t, p)
+}
+
+func TestReadDirMatchOnePackage(t *testing.T) {
+	dir, hash := writeManyPackagesPod(t, 20, 10)
+	const match = "example.com/test/pkg007"
+	all := readTestDir(t, dir)
+	got := readTestDir(t, dir, match)
+	for pkIdx, pack := range got.PodData[hash].Packages {
+		if pack.ImportPath == match {
+			if !reflect.DeepEqual(pack, all.PodData[hash].Packages[pkIdx]) {
+				t.Errorf("%s differs from the unfiltered read", match)
+			}
+		} else if len(pack.Funcs) != 0 {
+			t.Errorf("unmatched package %s has %d funcs", pack.ImportPath, len(pack.Funcs))
+		}
+	}
+}
+
+func BenchmarkReadDirMatchOnePackage(b *testing.B) {
+	dir, _ := writeManyPackagesPod(b, 200, 20)
+	for _, match := range [][]string{nil, {"example.com/test/pkg100"}} {
+		name := "all"
+		if match != nil {
+			name = "one"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := ReadDir(dir, match); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeDirs(t *testing.T) {
+	// A second directory of the count binary, with one more run.
+	more := copyTestDir(t, countDir)
+	writeCounterFile(t, more, countHash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0, 5}},
+	})
+	c, err := MergeDirs([]string{countDir, more, setDir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c.Data.PodData) != 2 {
+		t.Fatalf("merged %d pods, want 2", len(c.Data.PodData))
+	}
+	// Add ran 3+3+1 times, its second unit 3+3 times and its third 5
+	// times.
+	if got, want := unitCounts(findFunc(c.Data, countHash, "example.com/prog/lib", "Add")), []uint32{7, 6, 5}; !reflect.DeepEqual(got, want) {
+		t.Errorf("count pod: Add counts %v, want %v", got, want)
+	}
+	if got, want := unitCounts(findFunc(c.Data, countHash, "example.com/prog", "main")), []uint32{6, 2}; !reflect.DeepEqual(got, want) {
+		t.Errorf("count pod: main counts %v, want %v", got, want)
+	}
+	samePods(t, &CoverageData{PodData: map[string]*PodData{setHash: c.Data.PodData[setHash]}}, readTestDir(t, setDir))
+}
+
+func TestReadByHash(t *testing.T) {
+	dir := mergeTestDirs(t, setDir, countDir, variantDir)
+	for _, prefix := range []string{countHash[:4], countHash} {
+		c, err := ReadByHash(dir, prefix, nil)
+		if err != nil {
+			t.Fatalf("prefix %q: %v", prefix, err)
+		}
+		samePods(t, c.Data, readTestDir(t, countDir))
+	}
+
+	c, err := ReadByHash(dir, setHash[:6], []string{"example.com/prog/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, c.Data, readTestDir(t, setDir, "example.com/prog/lib"))
+
+	// The empty prefix matches all three pods.
+	if _, err := ReadByHash(dir, "", nil); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("ambiguous prefix: error %v", err)
+	}
+	if _, err := ReadByHash(dir, "ffff", nil); err == nil || !strings.Contains(err.Error(), "matches hash prefix") {
+		t.Errorf("unmatched prefix: error %v", err)
+	}
+}
+
+func TestPodOf(t *testing.T) {
+	testDir, testHash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/only",
+				testFunc("F", "only.go", []uint32{3}, []uint32{1}),
+			),
+		},
+	})
+	data := readTestDir(t, mergeTestDirs(t, setDir, countDir, testDir))
+	want := []string{countHash, setHash}
+	sort.Strings(want)
+	tests := []struct {
+		importPath string
+		want       []string
+	}{
+		{"example.com/prog/lib", want},
+		{"example.com/test/only", []string{testHash}},
+		{"example.com/test/none", nil},
+	}
+	for _, tt := range tests {
+		got, ok := data.PodOf(tt.importPath)
+		if !reflect.DeepEqual(got, tt.want) || ok != (tt.want != nil) {
+			t.Errorf("PodOf(%q) = %v, %v; want %v, %v", tt.importPath, got, ok, tt.want, tt.want != nil)
+		}
+	}
+}
+
+func TestInstrumented(t *testing.T) {
+	meta, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := os.ReadFile(setArgsCounterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	reads := map[string]func() (*CoverageData, error){
+		"ReadDir": func() (*CoverageData, error) {
+			return ReadDir(setDir, nil)
+		},
+		"ReadDirCached": func() (*CoverageData, error) {
+			return ReadDirCached(setDir, cacheDir, nil)
+		},
+		"ReadMetaOnly": func() (*CoverageData, error) {
+			return ReadMetaOnly(setDir, nil)
+		},
+		"ReadFromBuffer": func() (*CoverageData, error) {
+			return ReadFromBuffer(bytes.NewBuffer(meta), bytes.NewBuffer(counters), nil)
+		},
+	}
+	// ReadDirCached runs twice, to read from the cache it filled.
+	for _, name := range []string{"ReadDir", "ReadDirCached", "ReadDirCached", "ReadMetaOnly", "ReadFromBuffer"} {
+		data, err := reads[name]()
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		n := 0
+		for _, pack := range data.PodData[setHash].Packages {
+			for _, fn := range pack.Funcs {
+				n++
+				if !fn.Instrumented {
+					t.Errorf("%s: func %s not marked instrumented", name, fn.Name)
+				}
+			}
+		}
+		if n != 6 {
+			t.Errorf("%s: read %d funcs, want 6", name, n)
+		}
+	}
+
+	// Functions made up from a text profile aren't instrumented ones.
+	profile := filepath.Join(t.TempDir(), "cover.out")
+	if err := os.WriteFile(profile, []byte("mode: set\nexample.com/prog/x.go:3.2,3.20 1 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := ReadTextProfile(profile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				n++
+				if fn.Instrumented {
+					t.Errorf("text profile func of %s marked instrumented", fn.SrcFile)
+				}
+			}
+		}
+	}
+	if n != 1 {
+		t.Errorf("read %d funcs from the text profile, want 1", n)
+	}
+}
+
+func TestFuncUnitCoveredWeight(t *testing.T) {
+	tests := []struct {
+		u       FuncUnit
+		covered bool
+		weight  int
+	}{
+		{FuncUnit{Count: 0, NxStmts: 3}, false, 3},
+		{FuncUnit{Count: 1, NxStmts: 1}, true, 1},
+		{FuncUnit{Count: math.MaxUint32, NxStmts: 2}, true, 2},
+		{FuncUnit{Count: 5, NxStmts: 0}, true, 0},
+	}
+	for _, tt := range tests {
+		if got := tt.u.Covered(); got != tt.covered {
+			t.Errorf("%+v: Covered() = %v, want %v", tt.u, got, tt.covered)
+		}
+		if got := tt.u.Weight(); got != tt.weight {
+			t.Errorf("%+v: Weight() = %d, want %d", tt.u, got, tt.weight)
+		}
+	}
+
+	// In count mode too, a unit is covered by any nonzero count, and
+	// the weights of the units add up to the statements of GetPercent.
+	data := readTestDir(t, countDir)
+	add := findFunc(data, countHash, "example.com/prog/lib", "Add")
+	var covered []bool
+	for _, u := range add.Units {
+		covered = append(covered, u.Covered())
+	}
+	if want := []bool{true, true, false}; !reflect.DeepEqual(covered, want) {
+		t.Errorf("Add units covered %v, want %v (counts %v)", covered, want, unitCounts(add))
+	}
+	var cov, total int
+	for _, pack := range data.PodData[countHash].Packages {
+		for _, fn := range pack.Funcs {
+			for _, u := range fn.Units {
+				total += u.Weight()
+				if u.Covered() {
+					cov += u.Weight()
+				}
+			}
+		}
+	}
+	if cov != 10 || total != 14 {
+		t.Errorf("units weigh %d covered of %d, want 10 of 14", cov, total)
+	}
+}
@@ -12,35 +12,75 @@ import (
 // matchSimplePattern returns a function that can be used to check
 // whether a given name matches a pattern, where pattern is a limited
 // glob pattern in which '...' means 'any string', with no other
-// special syntax. There is one special case for MatchPatternSimple:
-// according to the rules in "go help packages": a /... at the end of
-// the pattern can match an empty string, so that net/... matches both
-// net and packages in its subdirectories, like net/http.
+// special syntax. Wildcards respect path segments: a '...' element
+// that makes up a whole segment matches zero or more whole segments,
+// so that .../util matches util and a/b/util but not myutil, while a
+// '...' embedded in a segment (as in foo...) only matches within that
+// segment. As in "go help packages", a /... at the end of the pattern
+// can match an empty string, so that net/... matches both net and
+// packages in its subdirectories, like net/http.
 func matchSimplePattern(pattern string, toMatch string) bool {
-	// Convert pattern to regular expression.
-	// The strategy for the trailing /... is to nest it in an explicit ? expression.
-	// The strategy for the vendor exclusion is to change the unmatchable
-	// vendor strings to a disallowed code point (vendorChar) and to use
-	// "(anything but that codepoint)*" as the implementation of the ... wildcard.
-	// This is a bit complicated but the obvious alternative,
-	// namely a hand-written search like in most shell glob matchers,
-	// is too easy to make accidentally exponential.
-	// Using package regexp guarantees linear-time matching.
+	// Convert pattern to regular expression, one path segment at a
+	// time. Using package regexp guarantees linear-time matching, where
+	// a hand-written search like in most shell glob matchers is too
+	// easy to make accidentally exponential.
 
 	const vendorChar = "\x00"
 
 	if strings.Contains(pattern, vendorChar) {
 		return false
 	}
+	// Without wildcards a pattern only matches itself; this is by far
+	// the common case, and avoids compiling a regexp for every package
+	// of every meta-data file read.
+	if !strings.Contains(pattern, "...") {
+		return pattern == toMatch
+	}
 
-	re := regexp.QuoteMeta(pattern)
-	wild := `.*`
-	if strings.HasSuffix(re, `/\.\.\.`) {
-		re = strings.TrimSuffix(re, `/\.\.\.`) + `(/\.\.\.)?`
+	re := ""
+	sep := false
+	parts := strings.Split(pattern, "/")
+	for i, part := range parts {
+		if part == "..." {
+			switch {
+			case len(parts) == 1:
+				re = `.*`
+			case i == 0:
+				re += `(?:.*/)?`
+				sep = false
+			default:
+				re += `(?:/.*)?`
+				sep = true
+			}
+			continue
+		}
+		if sep {
+			re += `/`
+		}
+		re += strings.ReplaceAll(regexp.QuoteMeta(part), `\.\.\.`, `[^/]*`)
+		sep = true
 	}
-	re = strings.ReplaceAll(re, `\.\.\.`, wild)
 
 	reg := regexp.MustCompile(`^` + re + `$`)
 
 	return reg.MatchString(toMatch)
 }
+
+// MatchPackages matches each of 'patterns' against 'paths', using the
+// same pattern syntax as the MatchPkgs option. It returns the paths
+// matched by each pattern that matches any, and, in their original
+// order, the patterns that match none (likely typos).
+func MatchPackages(patterns []string, paths []string) (matched map[string][]string, unmatched []string) {
+	matched = make(map[string][]string)
+	for _, pattern := range patterns {
+		for _, path := range paths {
+			if matchSimplePattern(pattern, path) {
+				matched[pattern] = append(matched[pattern], path)
+			}
+		}
+		if _, ok := matched[pattern]; !ok {
+			unmatched = append(unmatched, pattern)
+		}
+	}
+	return matched, unmatched
+}
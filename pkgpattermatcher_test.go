@@ -0,0 +1,76 @@
+package gocov
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchSimplePattern(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{"example.com/prog", "example.com/prog", true},
+		{"example.com/prog", "example.com/prog/lib", false},
+		{"example.com/prog", "example.com/program", false},
+
+		// A trailing /... matches the package itself and everything
+		// below it, but not siblings sharing a prefix.
+		{"example.com/prog/...", "example.com/prog", true},
+		{"example.com/prog/...", "example.com/prog/lib", true},
+		{"example.com/prog/...", "example.com/prog/lib/sub", true},
+		{"example.com/prog/...", "example.com/program", false},
+
+		// A leading .../ matches whole leading segments only.
+		{".../util", "util", true},
+		{".../util", "example.com/prog/util", true},
+		{".../util", "example.com/prog/myutil", false},
+		{".../util", "example.com/prog/util/sub", false},
+
+		// An inner /.../ matches zero or more whole segments.
+		{"example.com/.../util", "example.com/util", true},
+		{"example.com/.../util", "example.com/prog/util", true},
+		{"example.com/.../util", "example.com/prog/myutil", false},
+
+		// A ... within a segment stays within that segment.
+		{"example.com/prog/u...", "example.com/prog/util", true},
+		{"example.com/prog/u...", "example.com/prog/util/sub", false},
+		{"example.com/p.../util", "example.com/prog/util", true},
+		{"example.com/p.../util", "example.com/prog/x/util", false},
+
+		{"...", "anything/at/all", true},
+		{"...", "", true},
+
+		// Other regexp metacharacters are literal.
+		{"example.com/prog", "exampleXcom/prog", false},
+		{"a+b", "a+b", true},
+		{"a+b", "aab", false},
+
+		{"example.com/prog\x00", "example.com/prog\x00", false},
+	}
+	for _, tt := range tests {
+		if got := matchSimplePattern(tt.pattern, tt.path); got != tt.want {
+			t.Errorf("matchSimplePattern(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestMatchPkgsSegments(t *testing.T) {
+	data := readTestDir(t, setDir, ".../util")
+	for _, pack := range data.PodData[setHash].Packages {
+		if got, want := len(pack.Funcs) != 0, pack.ImportPath == "example.com/prog/util"; got != want {
+			t.Errorf("package %s read with functions = %v, want %v", pack.ImportPath, got, want)
+		}
+	}
+}
+
+func TestMatchPackages(t *testing.T) {
+	paths := []string{"example.com/prog", "example.com/prog/lib", "example.com/prog/util"}
+	matched, unmatched := MatchPackages([]string{"example.com/prog/...", "example.com/porg/..."}, paths)
+	if want := map[string][]string{"example.com/prog/...": paths}; !reflect.DeepEqual(matched, want) {
+		t.Errorf("matched %v, want %v", matched, want)
+	}
+	if want := []string{"example.com/porg/..."}; !reflect.DeepEqual(unmatched, want) {
+		t.Errorf("unmatched %v, want %v", unmatched, want)
+	}
+}
@@ -10,9 +10,10 @@ import (
 	"path/filepath"
 	"regexp"
 	"sort"
+	"strings"
 )
 
-// pod encapsulates a set of files emitted during the executions of a
+// Pod encapsulates a set of files emitted during the executions of a
 // coverage-instrumented binary. Each pod contains a single meta-data
 // file, and then 0 or more counter data files that refer to that
 // meta-data file. Pods are intended to simplify processing of
@@ -25,11 +26,31 @@ import (
 // data file (within the slice of input dirs handed to CollectPods).
 // The ProcessIDs field will be populated with the process ID of each
 // data file in the CounterDataFiles slice.
-type pod struct {
+type Pod struct {
 	MetaFile         string
 	CounterDataFiles []string
 }
 
+// hash returns the meta-data hash of the pod, as found in the name of
+// its meta-data file after the prefix and a dot.
+func (p Pod) hash() string {
+	base := filepath.Base(p.MetaFile)
+	if i := strings.LastIndex(base, "."); i >= 0 {
+		return base[i+1:]
+	}
+	return ""
+}
+
+// NewPod returns a pod made up of the meta-data file 'meta' and the
+// counter data files 'counters', for callers that already know how
+// their coverage output files are grouped.
+func NewPod(meta string, counters []string) Pod {
+	return Pod{
+		MetaFile:         meta,
+		CounterDataFiles: append([]string(nil), counters...),
+	}
+}
+
 // collectPods visits the files contained within the directories in
 // the list 'dirs', collects any coverage-related files, partitions
 // them into pods, and returns a list of the pods to the caller, along
@@ -43,7 +64,23 @@ type pod struct {
 // corresponding meta-data file). If "warn" is true, collectPods will
 // issue warnings to stderr when it encounters non-fatal problems (for
 // orphans or a directory with no meta-data files).
-func collectPods(dir string) ([]pod, error) {
+func collectPods(dir string) ([]Pod, error) {
+	return CollectPodsWith(dir, PodDiscoveryConfig{})
+}
+
+// PodDiscoveryConfig sets the file name prefixes by which meta-data
+// and counter data files are recognized, for files renamed by tools
+// (for example "job123-covmeta.<hash>"). Empty prefixes default to
+// "covmeta" and "covcounters".
+type PodDiscoveryConfig struct {
+	MetaPrefix    string
+	CounterPrefix string
+}
+
+// CollectPodsWith groups the coverage data files in 'dir' into pods
+// like ReadDir does, recognizing the files by the prefixes in 'cfg'.
+// The pods can be read with ReadPods.
+func CollectPodsWith(dir string, cfg PodDiscoveryConfig) ([]Pod, error) {
 	files := []string{}
 	dents, err := os.ReadDir(dir)
 	if err != nil {
@@ -55,7 +92,7 @@ func collectPods(dir string) ([]pod, error) {
 		}
 		files = append(files, filepath.Join(dir, e.Name()))
 	}
-	return collectPodsImpl(files), nil
+	return collectPodsWith(files, cfg), nil
 }
 
 type protoPod struct {
@@ -96,8 +133,24 @@ type protoPod struct {
 // first pod (with meta-file M1) will have four counter data files
 // (C1, C2, C3, C4) and the second pod will have two counter data files
 // (C5, C6).
-func collectPodsImpl(files []string) []pod {
-	metaRE := regexp.MustCompile(fmt.Sprintf(`^%s\.(\S+)$`, metaFilePref))
+func collectPodsImpl(files []string) []Pod {
+	return collectPodsWith(files, PodDiscoveryConfig{})
+}
+
+// collectPodsWith is collectPodsImpl with the file name prefixes taken
+// from 'cfg'.
+func collectPodsWith(files []string, cfg PodDiscoveryConfig) []Pod {
+	metaPref, counterPref := metaFilePref, counterFilePref
+	if cfg.MetaPrefix != "" {
+		metaPref = cfg.MetaPrefix
+	}
+	if cfg.CounterPrefix != "" {
+		counterPref = cfg.CounterPrefix
+	}
+	// Some tools emit a meta-data file without a hash suffix (and
+	// counter data files with an empty hash field); these still form a
+	// pod, with an empty tag.
+	metaRE := regexp.MustCompile(fmt.Sprintf(`^%s(?:\.(\S+))?$`, regexp.QuoteMeta(metaPref)))
 	mm := make(map[string]protoPod)
 	for _, f := range files {
 		base := filepath.Base(f)
@@ -113,7 +166,7 @@ func collectPodsImpl(files []string) []pod {
 			// the duplicate.
 		}
 	}
-	counterRE := regexp.MustCompile(fmt.Sprintf(counterFileRegexp, counterFilePref))
+	counterRE := regexp.MustCompile(fmt.Sprintf(counterFileRegexp, regexp.QuoteMeta(counterPref)))
 	for _, f := range files {
 		base := filepath.Base(f)
 		if m := counterRE.FindStringSubmatch(base); m != nil {
@@ -124,12 +177,12 @@ func collectPodsImpl(files []string) []pod {
 			}
 		}
 	}
-	pods := make([]pod, 0, len(mm))
+	pods := make([]Pod, 0, len(mm))
 	for _, pp := range mm {
 		sort.Slice(pp.elements, func(i, j int) bool {
 			return pp.elements[i] < pp.elements[j]
 		})
-		p := pod{
+		p := Pod{
 			MetaFile:         pp.mf,
 			CounterDataFiles: make([]string, 0, len(pp.elements)),
 		}
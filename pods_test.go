@@ -0,0 +1,87 @@
+package gocov
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCollectPodsUnhashedMetaFile(t *testing.T) {
+	files := []string{
+		"d/covmeta",
+		"d/covcounters..100.1",
+		"d/covcounters..101.2",
+		"d/covmeta.abc",
+		"d/covcounters.abc.102.3",
+		"d/covcounters.def.103.4",
+	}
+	want := []Pod{
+		{MetaFile: "d/covmeta", CounterDataFiles: []string{"d/covcounters..100.1", "d/covcounters..101.2"}},
+		{MetaFile: "d/covmeta.abc", CounterDataFiles: []string{"d/covcounters.abc.102.3"}},
+	}
+	if got := collectPodsImpl(files); !reflect.DeepEqual(got, want) {
+		t.Errorf("collectPodsImpl(%q) = %+v, want %+v", files, got, want)
+	}
+}
+
+func TestReadDirUnhashedMetaFile(t *testing.T) {
+	dir := copyTestDir(t, setDir)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		name := strings.Replace(e.Name(), "."+setHash, ".", 1)
+		if name == metaFilePref+"." {
+			name = metaFilePref
+		}
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(dir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The pod is still keyed by the hash recorded in the meta-data file.
+	want := readTestDir(t, setDir)
+	samePods(t, readTestDir(t, dir), want)
+
+	cacheDir := t.TempDir()
+	for i := 0; i < 2; i++ {
+		got, err := ReadDirCached(dir, cacheDir, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		samePods(t, got, want)
+	}
+}
+
+func TestCollectPodsWith(t *testing.T) {
+	dir := copyTestDir(t, setDir)
+	ents, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range ents {
+		if err := os.Rename(filepath.Join(dir, e.Name()), filepath.Join(dir, "job123-"+e.Name())); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if pods, err := collectPods(dir); err != nil || len(pods) != 0 {
+		t.Errorf("collectPods of renamed files = %+v, %v; want no pods", pods, err)
+	}
+	pods, err := CollectPodsWith(dir, PodDiscoveryConfig{
+		MetaPrefix:    "job123-" + metaFilePref,
+		CounterPrefix: "job123-" + counterFilePref,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || len(pods[0].CounterDataFiles) != 2 {
+		t.Fatalf("CollectPodsWith found %+v, want one pod with two counter data files", pods)
+	}
+	got, err := ReadPods(pods, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	samePods(t, got, readTestDir(t, setDir))
+}
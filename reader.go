@@ -6,9 +6,13 @@ package gocov
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"sync"
+	"unsafe"
 
 	"github.com/zeu5/gocov/bio"
 )
@@ -22,11 +26,53 @@ import (
 // merging or intersecting data files, analyzing data files, or
 // dumping data files.
 type covDataReader struct {
-	vis            *covDataVisitor
-	dir            string
+	vis  covVisitor
+	dir  string
+	pods []Pod
+	// files, if set, holds the contents of the files named in 'pods',
+	// which are then read from memory instead of the file system.
+	files          map[string][]byte
 	counterBuffer  *bytes.Buffer
 	metadataBuffer *bytes.Buffer
 	pkgs           []string
+	// metaOnly skips reading counter data files altogether.
+	metaOnly bool
+	// stats records how much counter data was read.
+	stats ReadStats
+	// intern, if set, interns the strings of all meta-data files read.
+	intern map[string]string
+	// dupPolicy selects how duplicate function payloads within a
+	// counter data file are handled.
+	dupPolicy DuplicatePolicy
+	// matched counts the packages that matched 'pkgs'.
+	matched int
+	// workers is the number of counter data files of a pod decoded
+	// concurrently.
+	workers int
+}
+
+// DuplicatePolicy selects how several payloads for the same function
+// within a single counter data file, as written by a buggy producer,
+// are handled.
+type DuplicatePolicy uint8
+
+const (
+	// DuplicateMerge merges the payloads like those of different
+	// counter data files.
+	DuplicateMerge DuplicatePolicy = iota
+	// DuplicateLastWins keeps only the last payload.
+	DuplicateLastWins
+	// DuplicateError fails the read.
+	DuplicateError
+)
+
+// ReadStats describes how much counter data was read to produce a
+// set of coverage data.
+type ReadStats struct {
+	// FilesRead is the number of counter data files read.
+	FilesRead int
+	// BytesRead is the total size of the counter data files read.
+	BytesRead int64
 }
 
 // MakeCovDataReader creates a CovDataReader object to process the
@@ -38,7 +84,7 @@ type covDataReader struct {
 // indicate what to do if errors are detected, and 'matchpkg' is a
 // caller-provided function that can be used to select specific
 // packages by name (if nil, then all packages are included).
-func makeCovDataDirReader(vis *covDataVisitor, dir string, pkgs ...string) *covDataReader {
+func makeCovDataDirReader(vis covVisitor, dir string, pkgs ...string) *covDataReader {
 	return &covDataReader{
 		vis:  vis,
 		dir:  dir,
@@ -46,7 +92,17 @@ func makeCovDataDirReader(vis *covDataVisitor, dir string, pkgs ...string) *covD
 	}
 }
 
-func makeCovDataBufferReader(vis *covDataVisitor, counter, metadata *bytes.Buffer, pkgs ...string) *covDataReader {
+// makeCovDataPodReader creates a covDataReader that processes exactly
+// the pods in 'pods', bypassing directory scanning.
+func makeCovDataPodReader(vis covVisitor, pods []Pod, pkgs ...string) *covDataReader {
+	return &covDataReader{
+		vis:  vis,
+		pods: pods,
+		pkgs: pkgs,
+	}
+}
+
+func makeCovDataBufferReader(vis covVisitor, counter, metadata *bytes.Buffer, pkgs ...string) *covDataReader {
 	return &covDataReader{
 		vis:            vis,
 		counterBuffer:  counter,
@@ -55,11 +111,9 @@ func makeCovDataBufferReader(vis *covDataVisitor, counter, metadata *bytes.Buffe
 	}
 }
 
-// CovDataVisitor defines hooks for clients of CovDataReader. When the
-// coverage data reader makes its way through a coverage meta-data
-// file and counter data files, it will invoke the methods below to
-// hand off info to the client. The normal sequence of expected
-// visitor method invocations is:
+// covVisitor defines the hooks covDataReader invokes as it makes its
+// way through a coverage meta-data file and counter data files. The
+// normal sequence of expected visitor method invocations is:
 //
 //	for each pod P {
 //		BeginPod(p)
@@ -70,41 +124,55 @@ func makeCovDataBufferReader(vis *covDataVisitor, counter, metadata *bytes.Buffe
 //			for each live function F in D {
 //				VisitFuncCounterData(F)
 //			}
-//			EndCounterDataFile(D)
 //		}
-//		EndCounters(MF)
 //		for each package PK in MF {
-//			BeginPackage(PK)
-//			if <PK matched according to package pattern and/or modpath> {
+//			if <PK matched according to package pattern> {
+//				BeginPackage(PK)
 //				for each function PF in PK {
 //					VisitFunc(PF)
 //				}
 //			}
-//			EndPackage(PK)
 //		}
-//		EndPod(p)
 //	}
-//	Finish()
+//
+// covDataVisitor is the implementation that collects CoverageData;
+// visitorAdapter drives a caller-supplied CovDataVisitor.
+type covVisitor interface {
+	BeginPod(p Pod)
+	VisitMetaDataFile(mfr *coverageMetaFileReader) error
+	BeginCounterDataFile(cdf string, cdr *counterDataReader)
+	VisitFuncCounterData(data funcPayload) error
+	BeginPackage(pd *coverageMetaDataDecoder, pkgIdx uint32)
+	VisitFunc(pkgIdx uint32, fnIdx uint32, fd *funcDesc)
+}
 
 func (r *covDataReader) Visit() error {
+	defer func() {
+		if d, ok := r.vis.(*covDataVisitor); ok {
+			d.data.stats = r.stats
+		}
+	}()
+	if r.metadataBuffer != nil {
+		return r.visitSinglePod()
+	}
+	podlist := r.pods
 	if r.dir != "" {
-		podlist, err := collectPods(r.dir)
+		var err error
+		podlist, err = collectPods(r.dir)
 		if err != nil {
 			return fmt.Errorf("reading inputs: %v", err)
 		}
-		for _, p := range podlist {
-			if err := r.visitPod(p); err != nil {
-				return err
-			}
+	}
+	for _, p := range podlist {
+		if err := r.visitPod(p); err != nil {
+			return err
 		}
-	} else {
-		return r.visitSinglePod()
 	}
 	return nil
 }
 
 func (r *covDataReader) visitSinglePod() error {
-	r.vis.BeginPod(pod{})
+	r.vis.BeginPod(Pod{})
 
 	f := bytes.NewReader(r.metadataBuffer.Bytes())
 	fileView := r.metadataBuffer.Bytes()
@@ -113,132 +181,338 @@ func (r *covDataReader) visitSinglePod() error {
 	if err != nil {
 		return fmt.Errorf("decoding meta-file: %s", err)
 	}
+	mfr.intern = r.intern
 	err = r.vis.VisitMetaDataFile(mfr)
 	if err != nil {
 		return err
 	}
 
 	mr := bytes.NewReader(r.counterBuffer.Bytes())
+	r.stats.FilesRead++
+	r.stats.BytesRead += int64(r.counterBuffer.Len())
 	var cdr *counterDataReader
 	cdr, err = newCounterDataReader(mr)
 	if err != nil {
 		return fmt.Errorf("reading counter data file: %s", err)
 	}
-	var data funcPayload
-	for {
-		ok, err := cdr.NextFunc(&data)
-		if err != nil {
-			return fmt.Errorf("reading counter data file: %v", err)
-		}
-		if !ok {
-			break
-		}
-		err = r.vis.VisitFuncCounterData(data)
-		if err != nil {
+	payloads, err := readSegments(cdr, r.dupPolicy)
+	if err != nil {
+		return fmt.Errorf("reading counter data file: %v", err)
+	}
+	r.vis.BeginCounterDataFile("", cdr)
+	for _, payload := range payloads {
+		if err := r.vis.VisitFuncCounterData(payload); err != nil {
 			return err
 		}
 	}
 
-	np := uint32(mfr.NumPackages())
-	payload := []byte{}
-	for pkIdx := uint32(0); pkIdx < np; pkIdx++ {
-		var pd *coverageMetaDataDecoder
-		pd, payload, err = mfr.GetPackageDecoder(pkIdx, payload)
-		if err != nil {
-			return fmt.Errorf("reading pkg %d from meta-file: %s", pkIdx, err)
-		}
-		r.processPackage(pd, pkIdx)
-	}
-
-	return nil
+	return r.visitPackages(mfr, "")
 }
 
 // visitPod examines a coverage data 'pod', that is, a meta-data file and
 // zero or more counter data files that refer to that meta-data file.
-func (r *covDataReader) visitPod(p pod) error {
+func (r *covDataReader) visitPod(p Pod) error {
 	r.vis.BeginPod(p)
 
 	// Open meta-file
-	f, err := os.Open(p.MetaFile)
-	if err != nil {
-		return fmt.Errorf("unable to open meta-file %s", p.MetaFile)
+	var (
+		f        io.ReadSeeker
+		fileView []byte
+		size     int64
+	)
+	if r.files != nil {
+		b, ok := r.files[p.MetaFile]
+		if !ok {
+			return fmt.Errorf("unable to open meta-file %s", p.MetaFile)
+		}
+		f, fileView, size = bytes.NewReader(b), b, int64(len(b))
+	} else {
+		mf, err := os.Open(p.MetaFile)
+		if err != nil {
+			return fmt.Errorf("unable to open meta-file %s", p.MetaFile)
+		}
+		defer mf.Close()
+		br := bio.NewReader(mf)
+		fi, err := mf.Stat()
+		if err != nil {
+			return fmt.Errorf("unable to stat metafile %s: %v", p.MetaFile, err)
+		}
+		size = fi.Size()
+		fileView = br.SliceRO(uint64(size))
+		br.MustSeek(0, io.SeekStart)
+		f = mf
 	}
-	defer f.Close()
-	br := bio.NewReader(f)
-	fi, err := f.Stat()
-	if err != nil {
-		return fmt.Errorf("unable to stat metafile %s: %v", p.MetaFile, err)
+	// A meta-data file too short to hold a header (for example one left
+	// behind by a crashed program) can't be decoded; skip it rather than
+	// losing the remaining pods.
+	if size < int64(unsafe.Sizeof(metaFileHeader{})) {
+		fmt.Fprintf(os.Stderr, "warning: skipping meta-file %s: too short (%d bytes) to contain a header\n", p.MetaFile, size)
+		return nil
 	}
-	fileView := br.SliceRO(uint64(fi.Size()))
-	br.MustSeek(0, io.SeekStart)
 
-	var mfr *coverageMetaFileReader
-	mfr, err = newCoverageMetaFileReader(f, fileView)
+	mfr, err := newCoverageMetaFileReader(f, fileView)
 	if err != nil {
 		return fmt.Errorf("decoding meta-file %s: %s", p.MetaFile, err)
 	}
+	mfr.intern = r.intern
 	err = r.vis.VisitMetaDataFile(mfr)
 	if err != nil {
 		return err
 	}
 
 	// Read counter data files.
-	for _, cdf := range p.CounterDataFiles {
-		cf, err := os.Open(cdf)
+	if !r.metaOnly {
+		if err := r.visitCounterDataFiles(p.CounterDataFiles); err != nil {
+			return err
+		}
+	}
+
+	// NB: packages in the meta-file will be in dependency order (basically
+	// the order in which init files execute). Do we want an additional sort
+	// pass here, say by packagepath?
+	return r.visitPackages(mfr, p.MetaFile)
+}
+
+// visitPackages hands the packages of the meta-data file 'mfr' that
+// match the requested patterns off to the visitor. The package path is
+// checked before a decoder is built, so unmatched packages cost little
+// more than reading their header.
+func (r *covDataReader) visitPackages(mfr *coverageMetaFileReader, metaFile string) error {
+	where := "meta-file"
+	if metaFile != "" {
+		where += " " + metaFile
+	}
+	np := uint32(mfr.NumPackages())
+	for pkIdx := uint32(0); pkIdx < np; pkIdx++ {
+		mp, err := mfr.peekPackage(pkIdx)
+		if err != nil {
+			return fmt.Errorf("reading pkg %d from %s: %s", pkIdx, where, err)
+		}
+		if !r.matchpkg(mp.path) {
+			continue
+		}
+		r.matched++
+		pd, err := mfr.packageDecoder(pkIdx)
+		if err != nil {
+			return fmt.Errorf("reading pkg %d from %s: %s", pkIdx, where, err)
+		}
+		r.processPackage(pd, pkIdx)
+	}
+	return nil
+}
+
+// visitCounterDataFile reads the counter data file 'cdf' and hands
+// each of the function payloads it contains off to the visitor. A
+// truncated counter data file, for example one still being written by
+// a running program, is skipped with a warning; any other decoding
+// error fails the read.
+func (r *covDataReader) visitCounterDataFile(cdf string) error {
+	cf, err := r.readCounterDataFile(cdf)
+	if err != nil {
+		return err
+	}
+	return r.visitCounterData(cf)
+}
+
+// counterFile is the decoded content of a counter data file.
+type counterFile struct {
+	name string
+	// size is the size of the file, or -1 if it couldn't be opened.
+	size     int64
+	cdr      *counterDataReader
+	payloads []funcPayload
+}
+
+// readCounterDataFile decodes the counter data file 'cdf' without
+// visiting it, which makes it safe to call concurrently. A truncated
+// file is warned about and yields no reader.
+func (r *covDataReader) readCounterDataFile(cdf string) (counterFile, error) {
+	cf := counterFile{name: cdf, size: -1}
+	var mr io.ReadSeeker
+	if r.files != nil {
+		b, ok := r.files[cdf]
+		if !ok {
+			return cf, fmt.Errorf("opening counter data file %s: %s", cdf, fs.ErrNotExist)
+		}
+		cf.size = int64(len(b))
+		mr = bytes.NewReader(b)
+	} else {
+		f, err := os.Open(cdf)
 		if err != nil {
-			return fmt.Errorf("opening counter data file %s: %s", cdf, err)
+			return cf, fmt.Errorf("opening counter data file %s: %s", cdf, err)
 		}
-		defer func(f *os.File) {
-			f.Close()
-		}(cf)
-		var mr *mReader
-		mr, err = newMreader(cf)
+		defer f.Close()
+		fi, err := f.Stat()
 		if err != nil {
-			return fmt.Errorf("creating reader for counter data file %s: %s", cdf, err)
+			return cf, fmt.Errorf("unable to stat counter data file %s: %v", cdf, err)
 		}
-		var cdr *counterDataReader
-		cdr, err = newCounterDataReader(mr)
+		cf.size = fi.Size()
+		mr, err = newMreader(f)
 		if err != nil {
-			return fmt.Errorf("reading counter data file %s: %s", cdf, err)
+			return cf, fmt.Errorf("creating reader for counter data file %s: %s", cdf, err)
+		}
+	}
+	if minSize := int64(unsafe.Sizeof(counterFileHeader{}) + unsafe.Sizeof(counterFileFooter{})); cf.size < minSize {
+		fmt.Fprintf(os.Stderr, "warning: skipping counter data file %s: too short (%d bytes) to be complete\n", cdf, cf.size)
+		return cf, nil
+	}
+	cdr, err := newCounterDataReader(mr)
+	if err != nil {
+		return cf, r.counterFileError(cdf, err)
+	}
+	// Decode the whole file before visiting any of it, so that a
+	// truncated file contributes nothing rather than part of its data.
+	payloads, err := readSegments(cdr, r.dupPolicy)
+	if err != nil {
+		var dup *duplicateError
+		if errors.As(err, &dup) {
+			return cf, fmt.Errorf("reading counter data file %s: %v", cdf, err)
+		}
+		return cf, r.counterFileError(cdf, err)
+	}
+	cf.cdr, cf.payloads = cdr, payloads
+	return cf, nil
+}
+
+// counterFileError handles the error 'err' from decoding the counter
+// data file 'cdf': truncation is warned about and ignored, so that the
+// file is skipped, while anything else is returned.
+func (r *covDataReader) counterFileError(cdf string, err error) error {
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, errTruncated) {
+		fmt.Fprintf(os.Stderr, "warning: skipping counter data file %s: %v\n", cdf, err)
+		return nil
+	}
+	return fmt.Errorf("reading counter data file %s: %v", cdf, err)
+}
+
+// visitCounterData hands the decoded counter data file 'cf' to the
+// visitor.
+func (r *covDataReader) visitCounterData(cf counterFile) error {
+	if cf.size >= 0 {
+		r.stats.FilesRead++
+		r.stats.BytesRead += cf.size
+	}
+	if cf.cdr == nil {
+		return nil
+	}
+	r.vis.BeginCounterDataFile(cf.name, cf.cdr)
+	for _, payload := range cf.payloads {
+		if err := r.vis.VisitFuncCounterData(payload); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// visitCounterDataFiles visits the counter data files 'cdfs' of a pod.
+// With several workers, the files are decoded concurrently and then
+// visited one at a time in order, which keeps the visitor
+// single-threaded; merging counters is commutative, so the result is
+// the same as reading the files serially. All files of the pod are
+// then held in memory at once.
+func (r *covDataReader) visitCounterDataFiles(cdfs []string) error {
+	if r.workers <= 1 || len(cdfs) <= 1 {
+		for _, cdf := range cdfs {
+			if err := r.visitCounterDataFile(cdf); err != nil {
+				return err
+			}
 		}
-		var data funcPayload
+		return nil
+	}
+
+	files := make([]counterFile, len(cdfs))
+	errs := make([]error, len(cdfs))
+	sem := make(chan struct{}, r.workers)
+	var wg sync.WaitGroup
+	for i, cdf := range cdfs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, cdf string) {
+			defer wg.Done()
+			files[i], errs[i] = r.readCounterDataFile(cdf)
+			<-sem
+		}(i, cdf)
+	}
+	wg.Wait()
+	for i := range files {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if err := r.visitCounterData(files[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSegments decodes the function payloads of every segment of the
+// counter data file read by 'cdr', applying 'policy' to the duplicates
+// within each segment. Payloads of the same function in different
+// segments, which record different executions, are all kept.
+func readSegments(cdr *counterDataReader, policy DuplicatePolicy) ([]funcPayload, error) {
+	var payloads []funcPayload
+	var data funcPayload
+	for seg := uint32(0); seg < cdr.NumSegments(); seg++ {
+		if seg > 0 {
+			if _, err := cdr.BeginNextSegment(); err != nil {
+				return nil, err
+			}
+		}
+		start := len(payloads)
 		for {
 			ok, err := cdr.NextFunc(&data)
 			if err != nil {
-				return fmt.Errorf("reading counter data file %s: %v", cdf, err)
+				return nil, err
 			}
 			if !ok {
 				break
 			}
-			err = r.vis.VisitFuncCounterData(data)
+			payload := data
+			payload.Counters = append([]uint32(nil), data.Counters...)
+			payloads = append(payloads, payload)
+		}
+		if policy != DuplicateMerge {
+			kept, err := dedupPayloads(payloads[start:], policy)
 			if err != nil {
-				return err
+				return nil, fmt.Errorf("segment %d: %w", seg, err)
 			}
-
+			payloads = append(payloads[:start], kept...)
 		}
 	}
+	return payloads, nil
+}
 
-	// NB: packages in the meta-file will be in dependency order (basically
-	// the order in which init files execute). Do we want an additional sort
-	// pass here, say by packagepath?
-	np := uint32(mfr.NumPackages())
-	payload := []byte{}
-	for pkIdx := uint32(0); pkIdx < np; pkIdx++ {
-		var pd *coverageMetaDataDecoder
-		pd, payload, err = mfr.GetPackageDecoder(pkIdx, payload)
-		if err != nil {
-			return fmt.Errorf("reading pkg %d from meta-file %s: %s", pkIdx, p.MetaFile, err)
+// duplicateError reports two payloads for the same function within a
+// segment under the DuplicateError policy.
+type duplicateError struct {
+	pkgIdx, funcIdx uint32
+}
+
+func (e *duplicateError) Error() string {
+	return fmt.Sprintf("duplicate payload for pkg %d func %d", e.pkgIdx, e.funcIdx)
+}
+
+// dedupPayloads applies 'policy' to the function payloads of a single
+// segment of a counter data file.
+func dedupPayloads(payloads []funcPayload, policy DuplicatePolicy) ([]funcPayload, error) {
+	last := make(map[pkfunc]int, len(payloads))
+	for i, p := range payloads {
+		key := pkfunc{pk: p.PkgIdx, fcn: p.FuncIdx}
+		if _, ok := last[key]; ok && policy == DuplicateError {
+			return nil, &duplicateError{p.PkgIdx, p.FuncIdx}
 		}
-		r.processPackage(pd, pkIdx)
+		last[key] = i
 	}
-
-	return nil
+	out := payloads[:0]
+	for i, p := range payloads {
+		if last[pkfunc{pk: p.PkgIdx, fcn: p.FuncIdx}] == i {
+			out = append(out, p)
+		}
+	}
+	return out, nil
 }
 
 func (r *covDataReader) processPackage(pd *coverageMetaDataDecoder, pkgIdx uint32) error {
-	if !r.matchpkg(pd.PackagePath()) {
-		return nil
-	}
 	r.vis.BeginPackage(pd, pkgIdx)
 	nf := pd.NumFuncs()
 	var fd funcDesc
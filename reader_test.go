@@ -0,0 +1,299 @@
+package gocov
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mergeTestDirs copies the files of 'dirs' into a single new temporary
+// directory.
+func mergeTestDirs(t *testing.T, dirs ...string) string {
+	t.Helper()
+	out := t.TempDir()
+	for _, dir := range dirs {
+		tmp := copyTestDir(t, dir)
+		ents, err := os.ReadDir(tmp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, e := range ents {
+			if err := os.Rename(filepath.Join(tmp, e.Name()), filepath.Join(out, e.Name())); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return out
+}
+
+func TestShortMetaFileSkipped(t *testing.T) {
+	for _, size := range []int{0, 10} {
+		dir := mergeTestDirs(t, setDir, countDir)
+		metaFile := filepath.Join(dir, metaFilePref+"."+setHash)
+		if err := os.Truncate(metaFile, int64(size)); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadDir(dir, nil)
+		if err != nil {
+			t.Fatalf("%d byte meta-data file: %v", size, err)
+		}
+		if _, ok := got.PodData[setHash]; ok {
+			t.Errorf("%d byte meta-data file: pod %s read", size, setHash)
+		}
+		samePods(t, got, readTestDir(t, countDir))
+	}
+}
+
+func TestTruncatedCounterFileSkipped(t *testing.T) {
+	// The expected result is that of the pod without the truncated file.
+	want := copyTestDir(t, setDir)
+	if err := os.Remove(filepath.Join(want, filepath.Base(setArgsCounterFile))); err != nil {
+		t.Fatal(err)
+	}
+	wantData := readTestDir(t, want)
+
+	fi, err := os.Stat(setArgsCounterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Cut the file at several points after its header, keeping it long
+	// enough not to be dismissed outright as too short.
+	for _, size := range []int64{fi.Size() - 20, fi.Size() - 60, 50} {
+		dir := copyTestDir(t, setDir)
+		if err := os.Truncate(filepath.Join(dir, filepath.Base(setArgsCounterFile)), size); err != nil {
+			t.Fatal(err)
+		}
+		got, err := ReadDir(dir, nil)
+		if err != nil {
+			t.Fatalf("counter data file truncated to %d bytes: %v", size, err)
+		}
+		samePods(t, got, wantData)
+	}
+}
+
+func TestDuplicateFuncPolicy(t *testing.T) {
+	// A counter data file with two payloads for Add, in a pod without
+	// any other counter data files.
+	dir := copyTestDir(t, countDir)
+	cdfs, err := filepath.Glob(filepath.Join(dir, counterFilePref+".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cdf := range cdfs {
+		if err := os.Remove(cdf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	cdf := writeCounterFile(t, dir, countHash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{2, 1, 0}},
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0, 3}},
+	})
+	meta, err := os.ReadFile(filepath.Join(dir, metaFilePref+"."+countHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := os.ReadFile(cdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy DuplicatePolicy
+		want   []uint32
+	}{
+		{DuplicateMerge, []uint32{3, 1, 3}},
+		{DuplicateLastWins, []uint32{1, 0, 3}},
+		{DuplicateError, nil},
+	}
+	reads := map[string]func(c CoverageConfig) (*CoverageData, error){
+		"dir": func(c CoverageConfig) (*CoverageData, error) {
+			return readDir(dir, c)
+		},
+		"buffer": func(c CoverageConfig) (*CoverageData, error) {
+			return readFromBuffer(bytes.NewBuffer(meta), bytes.NewBuffer(counters), c)
+		},
+	}
+	for _, tt := range tests {
+		for name, read := range reads {
+			data, err := read(CoverageConfig{DuplicateFuncs: tt.policy})
+			if tt.want == nil {
+				if err == nil {
+					t.Errorf("%s, policy %d: duplicate payloads read without error", name, tt.policy)
+				}
+				continue
+			}
+			if err != nil {
+				t.Errorf("%s, policy %d: %v", name, tt.policy, err)
+				continue
+			}
+			if got := unitCounts(findFunc(data, countHash, "example.com/prog/lib", "Add")); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("%s, policy %d: Add counts %v, want %v", name, tt.policy, got, tt.want)
+			}
+		}
+	}
+}
+
+func TestDuplicateFuncPolicySegments(t *testing.T) {
+	// A counter data file with one payload for Add in each of two
+	// segments: the payloads record different runs, so no policy
+	// treats them as duplicates.
+	dir := copyTestDir(t, countDir)
+	cdfs, err := filepath.Glob(filepath.Join(dir, counterFilePref+".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, cdf := range cdfs {
+		if err := os.Remove(cdf); err != nil {
+			t.Fatal(err)
+		}
+	}
+	var metaHash [16]byte
+	if _, err := hex.Decode(metaHash[:], []byte(countHash)); err != nil {
+		t.Fatal(err)
+	}
+	counters := encodeCounterSegments(metaHash, []counterSegment{
+		{nil, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{2, 1, 0}}}},
+		{nil, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0, 3}}}},
+	})
+	cdf := filepath.Join(dir, fmt.Sprintf("%s.%s.1.1", counterFilePref, countHash))
+	if err := os.WriteFile(cdf, counters, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	meta, err := os.ReadFile(filepath.Join(dir, metaFilePref+"."+countHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reads := map[string]func(c CoverageConfig) (*CoverageData, error){
+		"dir": func(c CoverageConfig) (*CoverageData, error) {
+			return readDir(dir, c)
+		},
+		"buffer": func(c CoverageConfig) (*CoverageData, error) {
+			return readFromBuffer(bytes.NewBuffer(meta), bytes.NewBuffer(counters), c)
+		},
+	}
+	want := []uint32{3, 1, 3}
+	for _, policy := range []DuplicatePolicy{DuplicateMerge, DuplicateLastWins, DuplicateError} {
+		for name, read := range reads {
+			data, err := read(CoverageConfig{DuplicateFuncs: policy})
+			if err != nil {
+				t.Errorf("%s, policy %d: %v", name, policy, err)
+				continue
+			}
+			if got := unitCounts(findFunc(data, countHash, "example.com/prog/lib", "Add")); !reflect.DeepEqual(got, want) {
+				t.Errorf("%s, policy %d: Add counts %v, want %v", name, policy, got, want)
+			}
+		}
+	}
+
+	// A duplicate within the second segment is still reported.
+	counters = encodeCounterSegments(metaHash, []counterSegment{
+		{nil, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{2, 1, 0}}}},
+		{nil, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0, 3}},
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 0, 3}},
+		}},
+	})
+	if err := os.WriteFile(cdf, counters, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for name, read := range reads {
+		if _, err := read(CoverageConfig{DuplicateFuncs: DuplicateError}); err == nil || !strings.Contains(err.Error(), "segment 1") {
+			t.Errorf("%s: got error %v, want a duplicate in segment 1", name, err)
+		}
+	}
+}
+
+func TestRequireMatch(t *testing.T) {
+	meta, err := os.ReadFile(filepath.Join(setDir, metaFilePref+"."+setHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := os.ReadFile(setArgsCounterFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reads := map[string]func(c CoverageConfig) (*CoverageData, error){
+		"dir": func(c CoverageConfig) (*CoverageData, error) {
+			return readDir(setDir, c)
+		},
+		"buffer": func(c CoverageConfig) (*CoverageData, error) {
+			return readFromBuffer(bytes.NewBuffer(meta), bytes.NewBuffer(counters), c)
+		},
+	}
+	tests := []struct {
+		c       CoverageConfig
+		wantErr bool
+	}{
+		{CoverageConfig{RequireMatch: true, MatchPkgs: []string{"example.com/nothing/..."}}, true},
+		{CoverageConfig{RequireMatch: true, MatchPkgs: []string{"example.com/nothing/...", ".../util"}}, false},
+		{CoverageConfig{RequireMatch: true}, false},
+		{CoverageConfig{MatchPkgs: []string{"example.com/nothing/..."}}, false},
+	}
+	for _, tt := range tests {
+		for name, read := range reads {
+			_, err := read(tt.c)
+			if tt.wantErr != errors.Is(err, ErrNoPackagesMatched) || !tt.wantErr && err != nil {
+				t.Errorf("%s, RequireMatch %v, MatchPkgs %q: error %v, want ErrNoPackagesMatched %v",
+					name, tt.c.RequireMatch, tt.c.MatchPkgs, err, tt.wantErr)
+			}
+		}
+	}
+}
+
+func TestCounterFileWorkers(t *testing.T) {
+	dir := copyTestDir(t, countDir)
+	for i := 0; i < 30; i++ {
+		n := uint32(i)
+		writeCounterFile(t, dir, countHash, 1000+i, nil, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{n, n % 3, n % 2}},
+			{PkgIdx: 0, FuncIdx: 2, Counters: []uint32{1, 0, n}},
+			{PkgIdx: 1, FuncIdx: 0, Counters: []uint32{n * 1000}},
+		})
+	}
+	// A truncated file is skipped whichever way it is read.
+	cdf := writeCounterFile(t, dir, countHash, 2000, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{7, 7, 7}},
+	})
+	fi, err := os.Stat(cdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(cdf, fi.Size()-8); err != nil {
+		t.Fatal(err)
+	}
+
+	var want *CoverageData
+	captureStderr(t, func() {
+		want, err = readDir(dir, CoverageConfig{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{2, 4, 64} {
+		var got *CoverageData
+		captureStderr(t, func() {
+			got, err = readDir(dir, CoverageConfig{CounterFileWorkers: workers})
+		})
+		if err != nil {
+			t.Fatalf("%d workers: %v", workers, err)
+		}
+		samePods(t, got, want)
+	}
+
+	// A corrupt file fails the read whichever way it is read.
+	if err := os.WriteFile(cdf, bytes.Repeat([]byte{0xff}, int(fi.Size())), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	for _, workers := range []int{0, 4} {
+		if _, err := readDir(dir, CoverageConfig{CounterFileWorkers: workers}); err == nil {
+			t.Errorf("%d workers: corrupt counter data file read without error", workers)
+		}
+	}
+}
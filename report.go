@@ -0,0 +1,412 @@
+package gocov
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"golang.org/x/tools/cover"
+)
+
+// lineStatus returns, for every source file (as named by fileName),
+// the lines spanned by coverable units, mapped to whether any unit
+// spanning the line was executed.
+func (c *Coverage) lineStatus() map[string]map[uint32]bool {
+	files := make(map[string]map[uint32]bool)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				file := c.fileName(pack, fn)
+				lines, ok := files[file]
+				if !ok {
+					lines = make(map[uint32]bool)
+					files[file] = lines
+				}
+				for _, u := range fn.Units {
+					for l := u.StLine; l <= u.EnLine; l++ {
+						lines[l] = lines[l] || u.Covered()
+					}
+				}
+			}
+		}
+	}
+	return files
+}
+
+// lineCoverage returns the number of source lines spanned by
+// coverable units, and how many of them belong to at least one unit
+// that was executed.
+func (c *Coverage) lineCoverage() (covered, total int) {
+	for _, lines := range c.lineStatus() {
+		for _, hit := range lines {
+			total++
+			if hit {
+				covered++
+			}
+		}
+	}
+	return covered, total
+}
+
+// LineCount holds the covered and total coverable lines of a file.
+type LineCount struct {
+	Covered, Total int
+}
+
+// LineCounts returns the line counts of every instrumented source file.
+// Lines are identified by file name and line number across pods: when
+// a file is compiled into several pods, possibly with differing unit
+// sets (for example under different build tags), each line is counted
+// once, as coverable if a unit of any pod spans it and as covered if
+// any pod executed such a unit. Totals are therefore never inflated by
+// the number of pods.
+func (c *Coverage) LineCounts() map[string]LineCount {
+	counts := make(map[string]LineCount)
+	for file, lines := range c.lineStatus() {
+		var lc LineCount
+		for _, hit := range lines {
+			lc.Total++
+			if hit {
+				lc.Covered++
+			}
+		}
+		counts[file] = lc
+	}
+	return counts
+}
+
+// FilePercents returns the percentage of coverable lines covered in
+// every instrumented source file, counted as by LineCounts.
+func (c *Coverage) FilePercents() map[string]float64 {
+	percents := make(map[string]float64)
+	for file, lc := range c.LineCounts() {
+		percents[file] = stmtCount{lc.Covered, lc.Total}.percent()
+	}
+	return percents
+}
+
+// DirPercents returns the percentage of statements covered in every
+// directory of source files, keyed by the directory of the source file
+// name and summed across the files of the directory and across pods.
+// Bare file names, which carry no directory, are qualified with the
+// import path of their package first, as by Package.QualifiedFile, so
+// that they are keyed by package rather than all lumped under ".".
+// Subdirectories are not rolled up into their parents.
+func (c *Coverage) DirPercents() map[string]float64 {
+	dirs := make(map[string]*stmtCount)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				dir := filepath.Dir(pack.QualifiedFile(fn))
+				sc, ok := dirs[dir]
+				if !ok {
+					sc = &stmtCount{}
+					dirs[dir] = sc
+				}
+				for _, u := range fn.Units {
+					sc.total += u.Weight()
+					if u.Covered() {
+						sc.covered += u.Weight()
+					}
+				}
+			}
+		}
+	}
+	percents := make(map[string]float64, len(dirs))
+	for dir, sc := range dirs {
+		percents[dir] = sc.percent()
+	}
+	return percents
+}
+
+// stmtCoverage returns the total number of statements and the number
+// of statements that were executed.
+func (c *Coverage) stmtCoverage() (covered, total int) {
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				for _, u := range fn.Units {
+					nx := u.Weight()
+					total += nx
+					if u.Covered() {
+						covered += nx
+					}
+				}
+			}
+		}
+	}
+	return covered, total
+}
+
+// stmtCount holds covered and total statement counts.
+type stmtCount struct {
+	covered, total int
+}
+
+func (s stmtCount) percent() float64 {
+	if s.total == 0 {
+		return 0
+	}
+	return 100 * float64(s.covered) / float64(s.total)
+}
+
+// packageStmtCoverage returns the statement counts of every package,
+// keyed by import path and summed across pods.
+func (c *Coverage) packageStmtCoverage() map[string]*stmtCount {
+	pkgs := make(map[string]*stmtCount)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			sc, ok := pkgs[pack.ImportPath]
+			if !ok {
+				sc = &stmtCount{}
+				pkgs[pack.ImportPath] = sc
+			}
+			for _, fn := range pack.Funcs {
+				for _, u := range fn.Units {
+					nx := u.Weight()
+					sc.total += nx
+					if u.Covered() {
+						sc.covered += nx
+					}
+				}
+			}
+		}
+	}
+	return pkgs
+}
+
+// WriteTeamCity writes the line and statement coverage as TeamCity
+// service messages, which TeamCity picks up from the build log as
+// build statistics.
+func (c *Coverage) WriteTeamCity(w io.Writer) error {
+	lCovered, lTotal := c.lineCoverage()
+	sCovered, sTotal := c.stmtCoverage()
+	stats := []struct {
+		key   string
+		value string
+	}{
+		{"CodeCoverageAbsLCovered", fmt.Sprint(lCovered)},
+		{"CodeCoverageAbsLTotal", fmt.Sprint(lTotal)},
+		{"CodeCoverageL", teamCityPercent(lCovered, lTotal)},
+		{"CodeCoverageAbsSCovered", fmt.Sprint(sCovered)},
+		{"CodeCoverageAbsSTotal", fmt.Sprint(sTotal)},
+		{"CodeCoverageS", teamCityPercent(sCovered, sTotal)},
+	}
+	for _, s := range stats {
+		if _, err := fmt.Fprintf(w, "##teamcity[buildStatisticValue key='%s' value='%s']\n", s.key, s.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func teamCityPercent(covered, total int) string {
+	if total == 0 {
+		return "0"
+	}
+	return fmt.Sprintf("%.2f", 100*float64(covered)/float64(total))
+}
+
+// WriteTextProfile writes the coverage data in the text profile format
+// produced by "go test -coverprofile", with files and blocks sorted by
+// position.
+func (c *Coverage) WriteTextProfile(w io.Writer) error {
+	return writeTextProfile(w, c.GetProfiles())
+}
+
+// WriteTextProfileRel is like WriteTextProfile, but names files the
+// way "go test -coverprofile" does, so that tools like "go tool cover"
+// can resolve them: a source file below 'moduleRoot' (if set) is named
+// by the module path followed by its path relative to 'moduleRoot',
+// and any other file by its package import path followed by its base
+// name.
+func (c *Coverage) WriteTextProfileRel(w io.Writer, moduleRoot string) error {
+	return writeTextProfile(w, c.profiles(func(pack *Package, fn *Func) string {
+		if moduleRoot != "" && pack.ModulePath != "" {
+			if rel, err := filepath.Rel(moduleRoot, fn.SrcFile); err == nil && filepath.IsAbs(fn.SrcFile) && !strings.HasPrefix(rel, "..") {
+				return pack.ModulePath + "/" + filepath.ToSlash(rel)
+			}
+		}
+		base := fn.SrcFile
+		if i := strings.LastIndexAny(base, `/\`); i >= 0 {
+			base = base[i+1:]
+		}
+		return pack.ImportPath + "/" + base
+	}))
+}
+
+func writeTextProfile(w io.Writer, profiles []cover.Profile) error {
+	sort.Slice(profiles, func(i, j int) bool {
+		return profiles[i].FileName < profiles[j].FileName
+	})
+	mode := CtrModeSet.String()
+	if len(profiles) > 0 {
+		mode = profiles[0].Mode
+	}
+	if _, err := fmt.Fprintf(w, "mode: %s\n", mode); err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		sort.Slice(p.Blocks, func(i, j int) bool {
+			bi, bj := p.Blocks[i], p.Blocks[j]
+			if bi.StartLine != bj.StartLine {
+				return bi.StartLine < bj.StartLine
+			}
+			return bi.StartCol < bj.StartCol
+		})
+		for _, b := range p.Blocks {
+			if _, err := fmt.Fprintf(w, "%s:%d.%d,%d.%d %d %d\n", p.FileName,
+				b.StartLine, b.StartCol, b.EndLine, b.EndCol, b.NumStmt, b.Count); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type junitTestSuite struct {
+	XMLName   xml.Name        `xml:"testsuite"`
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	TestCases []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// WriteJUnitThresholds writes a JUnit XML report with one test case
+// per package, which fails if the package's statement coverage is
+// below 'threshold' percent. Packages without statements always pass.
+func (c *Coverage) WriteJUnitThresholds(w io.Writer, threshold float64) error {
+	pkgs := c.packageStmtCoverage()
+	suite := junitTestSuite{Name: "coverage"}
+	for _, path := range sortedKeys(pkgs) {
+		sc := pkgs[path]
+		tc := junitTestCase{ClassName: "coverage", Name: path}
+		if pct := sc.percent(); sc.total != 0 && pct < threshold {
+			tc.Failure = &junitFailure{
+				Message: fmt.Sprintf("coverage %.1f%% is below threshold %.1f%%", pct, threshold),
+			}
+			suite.Failures++
+		}
+		suite.Tests++
+		suite.TestCases = append(suite.TestCases, tc)
+	}
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(suite); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// WriteDebugDump writes the coverage data in a readable form modeled on
+// "go tool covdata debugdump": every pod with its counter mode and
+// granularity, followed by its packages, functions and units with
+// their counts, all in index order.
+func (c *Coverage) WriteDebugDump(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	for _, hash := range sortedKeys(c.Data.PodData) {
+		p := c.Data.PodData[hash]
+		fmt.Fprintf(bw, "Pod: %s\n", hash)
+		fmt.Fprintf(bw, "  mode: %s granularity: %s\n", p.CounterMode, p.CounterGranularity)
+		for _, pkIdx := range sortedKeys(p.Packages) {
+			pack := p.Packages[pkIdx]
+			fmt.Fprintf(bw, "\nPackage path: %s\n", pack.ImportPath)
+			fmt.Fprintf(bw, "Package name: %s\n", pack.Name)
+			fmt.Fprintf(bw, "Module path: %s\n", pack.ModulePath)
+			for _, fnIdx := range sortedKeys(pack.Funcs) {
+				fn := pack.Funcs[fnIdx]
+				fmt.Fprintf(bw, "\nFunc: %s\n", fn.Name)
+				fmt.Fprintf(bw, "Srcfile: %s\n", fn.SrcFile)
+				fmt.Fprintf(bw, "Literal: %v\n", fn.Lit)
+				for i, u := range fn.Units {
+					fmt.Fprintf(bw, "%d: L%d:C%d -- L%d:C%d NS=%d = %d\n",
+						i, u.StLine, u.StCol, u.EnLine, u.EnCol, u.NxStmts, u.Count)
+				}
+			}
+		}
+		fmt.Fprintln(bw)
+	}
+	return bw.Flush()
+}
+
+// treemapNode is a node of the tree written by WriteTreemapJSON.
+type treemapNode struct {
+	Name     string         `json:"name"`
+	Covered  int            `json:"covered"`
+	Total    int            `json:"total"`
+	Children []*treemapNode `json:"children,omitempty"`
+
+	byName map[string]*treemapNode
+}
+
+func (n *treemapNode) child(name string) *treemapNode {
+	if c, ok := n.byName[name]; ok {
+		return c
+	}
+	c := &treemapNode{Name: name}
+	if n.byName == nil {
+		n.byName = make(map[string]*treemapNode)
+	}
+	n.byName[name] = c
+	n.Children = append(n.Children, c)
+	return c
+}
+
+// sort orders the children of every node by name.
+func (n *treemapNode) sort() {
+	sort.Slice(n.Children, func(i, j int) bool {
+		return n.Children[i].Name < n.Children[j].Name
+	})
+	for _, c := range n.Children {
+		c.sort()
+	}
+}
+
+// WriteTreemapJSON writes the statement coverage as a tree of JSON
+// nodes, {"name", "covered", "total", "children"}, for treemap
+// visualizations. Below the root are modules, then packages, source
+// files and finally functions, which are the leaves; the counts of
+// every node are the sums over its children. Functions that appear in
+// several pods are counted once.
+func (c *Coverage) WriteTreemapJSON(w io.Writer) error {
+	modules := make(map[string]string)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			modules[pack.ImportPath] = pack.ModulePath
+		}
+	}
+	root := &treemapNode{Name: "root"}
+	for key, sc := range c.Data.funcStmts() {
+		path := []*treemapNode{root}
+		n := root
+		for _, name := range []string{modules[key.importPath], key.importPath, key.srcFile, key.name} {
+			n = n.child(name)
+			path = append(path, n)
+		}
+		for _, n := range path {
+			n.Covered += sc.covered
+			n.Total += sc.total
+		}
+	}
+	root.sort()
+	return json.NewEncoder(w).Encode(root)
+}
@@ -0,0 +1,360 @@
+package gocov
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "update the golden files of the tests")
+
+func TestWriteTeamCity(t *testing.T) {
+	var buf bytes.Buffer
+	if err := readTestCoverage(t, setDir).WriteTeamCity(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `##teamcity[buildStatisticValue key='CodeCoverageAbsLCovered' value='10']
+##teamcity[buildStatisticValue key='CodeCoverageAbsLTotal' value='17']
+##teamcity[buildStatisticValue key='CodeCoverageL' value='58.82']
+##teamcity[buildStatisticValue key='CodeCoverageAbsSCovered' value='10']
+##teamcity[buildStatisticValue key='CodeCoverageAbsSTotal' value='14']
+##teamcity[buildStatisticValue key='CodeCoverageS' value='71.43']
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTeamCity wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTeamCityEmpty(t *testing.T) {
+	var buf bytes.Buffer
+	c := readTestCoverage(t, setDir, "example.com/nomatch")
+	if err := c.WriteTeamCity(&buf); err != nil {
+		t.Fatal(err)
+	}
+	want := `##teamcity[buildStatisticValue key='CodeCoverageAbsLCovered' value='0']
+##teamcity[buildStatisticValue key='CodeCoverageAbsLTotal' value='0']
+##teamcity[buildStatisticValue key='CodeCoverageL' value='0']
+##teamcity[buildStatisticValue key='CodeCoverageAbsSCovered' value='0']
+##teamcity[buildStatisticValue key='CodeCoverageAbsSTotal' value='0']
+##teamcity[buildStatisticValue key='CodeCoverageS' value='0']
+`
+	if got := buf.String(); got != want {
+		t.Errorf("WriteTeamCity wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+// twoPackageCoverage returns coverage of a package example.com/test/good
+// with all and a package example.com/test/bad with a quarter of its
+// statements covered.
+func twoPackageCoverage(t *testing.T) *Coverage {
+	t.Helper()
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/good",
+				testFunc("A", "good/a.go", []uint32{3, 4}, []uint32{1, 1}),
+			),
+			1: testPackage("example.com/test/bad",
+				testFunc("B", "bad/b.go", []uint32{3, 4, 5, 6}, []uint32{1, 0, 0, 0}),
+			),
+		},
+	})
+	return readTestCoverage(t, dir)
+}
+
+func TestWriteJUnitThresholds(t *testing.T) {
+	var buf bytes.Buffer
+	if err := twoPackageCoverage(t).WriteJUnitThresholds(&buf, 50); err != nil {
+		t.Fatal(err)
+	}
+	var suite junitTestSuite
+	if err := xml.Unmarshal(buf.Bytes(), &suite); err != nil {
+		t.Fatalf("decoding report: %v\n%s", err, buf.Bytes())
+	}
+	if suite.Tests != 2 || suite.Failures != 1 {
+		t.Errorf("suite has tests=%d failures=%d, want 2 and 1", suite.Tests, suite.Failures)
+	}
+	if len(suite.TestCases) != 2 {
+		t.Fatalf("got %d test cases, want 2:\n%s", len(suite.TestCases), buf.Bytes())
+	}
+	for _, tc := range suite.TestCases {
+		switch tc.Name {
+		case "example.com/test/good":
+			if tc.Failure != nil {
+				t.Errorf("%s: unexpected failure %q", tc.Name, tc.Failure.Message)
+			}
+		case "example.com/test/bad":
+			want := "coverage 25.0% is below threshold 50.0%"
+			if tc.Failure == nil || tc.Failure.Message != want {
+				t.Errorf("%s: failure %+v, want message %q", tc.Name, tc.Failure, want)
+			}
+		default:
+			t.Errorf("unexpected test case %q", tc.Name)
+		}
+	}
+}
+
+func TestWriteTextProfileRel(t *testing.T) {
+	c := &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+		"pod": {
+			CounterMode:        CtrModeSet,
+			CounterGranularity: CtrGranularityPerBlock,
+			Packages: map[uint32]*Package{
+				0: testPackage("example.com/test/pkg",
+					testFunc("A", "/work/test/pkg/a.go", []uint32{3}, []uint32{1}),
+					testFunc("B", "b.go", []uint32{4}, []uint32{0}),
+					testFunc("C", `C:\gopath\src\example.com\test\pkg\c.go`, []uint32{5}, []uint32{1}),
+					testFunc("D", "/elsewhere/pkg/d.go", []uint32{6}, []uint32{1}),
+				),
+			},
+		},
+	}}}
+	var buf bytes.Buffer
+	if err := c.WriteTextProfileRel(&buf, "/work/test"); err != nil {
+		t.Fatal(err)
+	}
+	want := `mode: set
+example.com/test/pkg/a.go:3.2,3.20 1 1
+example.com/test/pkg/b.go:4.2,4.20 1 0
+example.com/test/pkg/c.go:5.2,5.20 1 1
+example.com/test/pkg/d.go:6.2,6.20 1 1
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got profile\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestWriteTextProfileSetModeCounts(t *testing.T) {
+	profile := func(mode counterMode) string {
+		c := &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+			"pod": {
+				CounterMode:        mode,
+				CounterGranularity: CtrGranularityPerBlock,
+				Packages: map[uint32]*Package{
+					0: testPackage("example.com/test/pkg",
+						testFunc("A", "/src/pkg/a.go", []uint32{3, 4}, []uint32{3, 0}),
+					),
+				},
+			},
+		}}}
+		var buf bytes.Buffer
+		if err := c.WriteTextProfile(&buf); err != nil {
+			t.Fatal(err)
+		}
+		return buf.String()
+	}
+
+	want := `mode: set
+/src/pkg/a.go:3.2,3.20 1 1
+/src/pkg/a.go:4.2,4.20 1 0
+`
+	if got := profile(CtrModeSet); got != want {
+		t.Errorf("set mode: got profile\n%s\nwant\n%s", got, want)
+	}
+	// Counts are only clamped in set mode.
+	want = `mode: count
+/src/pkg/a.go:3.2,3.20 1 3
+/src/pkg/a.go:4.2,4.20 1 0
+`
+	if got := profile(CtrModeCount); got != want {
+		t.Errorf("count mode: got profile\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLineCountsAcrossPods(t *testing.T) {
+	// The same file compiled into two pods with differing units, as
+	// under different build tags.
+	c := &Coverage{Data: &CoverageData{PodData: map[string]*PodData{
+		"pod1": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/pkg", testFunc("F", "/src/f.go", []uint32{3, 4, 5}, []uint32{1, 0, 0})),
+		}},
+		"pod2": {Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/pkg", testFunc("F", "/src/f.go", []uint32{4, 5, 6, 7}, []uint32{0, 0, 1, 0})),
+		}},
+	}}}
+	// Lines 3 to 7 are coverable, of which 3 and 6 are covered.
+	if got, want := c.LineCounts()["/src/f.go"], (LineCount{Covered: 2, Total: 5}); got != want {
+		t.Errorf("LineCounts = %+v, want %+v", got, want)
+	}
+	if got := c.FilePercents()["/src/f.go"]; got != 40 {
+		t.Errorf("FilePercents = %.2f, want 40", got)
+	}
+
+	// Pods of builds with the same units count each line once.
+	single := readTestCoverage(t, setDir).LineCounts()
+	both := readTestCoverage(t, mergeTestDirs(t, setDir, countDir)).LineCounts()
+	for file, lc := range single {
+		if both[file].Total != lc.Total {
+			t.Errorf("%s: %d lines in two pods, but %d in one", file, both[file].Total, lc.Total)
+		}
+	}
+}
+
+func TestWriteDebugDump(t *testing.T) {
+	var buf bytes.Buffer
+	if err := readTestCoverage(t, countDir).WriteDebugDump(&buf); err != nil {
+		t.Fatal(err)
+	}
+	golden := filepath.Join("testdata", "debugdump.golden")
+	if *update {
+		if err := os.WriteFile(golden, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	want, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := buf.String(); got != string(want) {
+		t.Errorf("WriteDebugDump wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSameNamedFiles(t *testing.T) {
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("main", "main.go", []uint32{3, 4}, []uint32{1, 1}),
+			),
+			1: testPackage("example.com/test/b",
+				testFunc("main", "main.go", []uint32{3, 4}, []uint32{1, 0}),
+			),
+		},
+	})
+	// Without QualifyFiles the two files are taken for one, whose
+	// lines are all covered by the first.
+	c := readTestCoverage(t, dir)
+	if got, want := c.FilePercents(), map[string]float64{"main.go": 100}; !reflect.DeepEqual(got, want) {
+		t.Errorf("FilePercents = %v, want %v", got, want)
+	}
+
+	c.config.QualifyFiles = true
+	want := map[string]float64{
+		"example.com/test/a/main.go": 100,
+		"example.com/test/b/main.go": 50,
+	}
+	if got := c.FilePercents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("FilePercents = %v, want %v", got, want)
+	}
+	files := make(map[string]bool)
+	for _, p := range c.GetProfiles() {
+		files[p.FileName] = true
+	}
+	if len(files) != 2 || !files["example.com/test/a/main.go"] || !files["example.com/test/b/main.go"] {
+		t.Errorf("profiles for files %v, want one for each main.go", files)
+	}
+}
+
+func TestWriteTreemapJSON(t *testing.T) {
+	type node struct {
+		Name     string  `json:"name"`
+		Covered  int     `json:"covered"`
+		Total    int     `json:"total"`
+		Children []*node `json:"children"`
+	}
+	treemap := func(c *Coverage) *node {
+		var buf bytes.Buffer
+		if err := c.WriteTreemapJSON(&buf); err != nil {
+			t.Fatal(err)
+		}
+		var root node
+		if err := json.Unmarshal(buf.Bytes(), &root); err != nil {
+			t.Fatalf("decoding treemap: %v\n%s", err, buf.Bytes())
+		}
+		return &root
+	}
+	// check verifies that the counts of every node are the sums over
+	// its children, and that functions are the leaves.
+	var check func(n *node, depth int)
+	check = func(n *node, depth int) {
+		if depth == 4 {
+			if len(n.Children) != 0 {
+				t.Errorf("function %s has children", n.Name)
+			}
+			return
+		}
+		if len(n.Children) == 0 {
+			t.Errorf("%s at depth %d has no children", n.Name, depth)
+		}
+		var covered, total int
+		for _, c := range n.Children {
+			covered += c.Covered
+			total += c.Total
+			check(c, depth+1)
+		}
+		if covered != n.Covered || total != n.Total {
+			t.Errorf("%s: %d/%d statements covered, but %d/%d in its children", n.Name, n.Covered, n.Total, covered, total)
+		}
+	}
+	child := func(n *node, name string) *node {
+		for _, c := range n.Children {
+			if c.Name == name {
+				return c
+			}
+		}
+		t.Fatalf("%s has no child %s", n.Name, name)
+		return nil
+	}
+
+	root := treemap(readTestCoverage(t, setDir))
+	check(root, 0)
+	if root.Covered != 10 || root.Total != 14 {
+		t.Errorf("root has %d/%d statements covered, want 10/14", root.Covered, root.Total)
+	}
+	lib := child(child(root, "example.com/prog"), "example.com/prog/lib")
+	add := child(child(lib, "example.com/prog/lib/lib.go"), "Add")
+	if add.Covered != 2 || add.Total != 3 {
+		t.Errorf("Add has %d/%d statements covered, want 2/3", add.Covered, add.Total)
+	}
+
+	// Functions in two pods are counted once.
+	both := treemap(readTestCoverage(t, mergeTestDirs(t, setDir, countDir)))
+	check(both, 0)
+	if both.Total != root.Total {
+		t.Errorf("root of two pods has %d statements, want %d", both.Total, root.Total)
+	}
+}
+
+func TestDirPercents(t *testing.T) {
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("X", "/src/a/x.go", []uint32{3, 4}, []uint32{1, 0}),
+				testFunc("Y", "/src/a/y.go", []uint32{3, 4}, []uint32{1, 1}),
+			),
+			1: testPackage("example.com/test/a/sub",
+				testFunc("W", "/src/a/sub/w.go", []uint32{3}, []uint32{1}),
+			),
+			2: testPackage("example.com/test/b",
+				testFunc("Z", "/src/b/z.go", []uint32{3, 4}, []uint32{0, 0}),
+			),
+			// Bare file names are keyed by their package.
+			3: testPackage("example.com/test/c",
+				testFunc("main", "main.go", []uint32{3, 4}, []uint32{1, 0}),
+			),
+			4: testPackage("example.com/test/d",
+				testFunc("main", "main.go", []uint32{3}, []uint32{1}),
+			),
+		},
+	})
+	want := map[string]float64{
+		filepath.FromSlash("/src/a"):             75,
+		filepath.FromSlash("/src/a/sub"):         100,
+		filepath.FromSlash("/src/b"):             0,
+		filepath.FromSlash("example.com/test/c"): 50,
+		filepath.FromSlash("example.com/test/d"): 100,
+	}
+	if got := readTestCoverage(t, dir).DirPercents(); !reflect.DeepEqual(got, want) {
+		t.Errorf("DirPercents = %v, want %v", got, want)
+	}
+}
@@ -6,6 +6,8 @@ package gocov
 
 import (
 	"encoding/binary"
+	"fmt"
+	"math"
 	"unsafe"
 )
 
@@ -53,15 +55,15 @@ func (r *reader) ReadUint8() uint8 {
 }
 
 func (r *reader) ReadUint32() uint32 {
-	end := int(r.off) + 4
-	rv := binary.LittleEndian.Uint32(r.b[int(r.off):end:end])
+	end := r.off + 4
+	rv := binary.LittleEndian.Uint32(r.b[r.off:end:end])
 	r.off += 4
 	return rv
 }
 
 func (r *reader) ReadUint64() uint64 {
-	end := int(r.off) + 8
-	rv := binary.LittleEndian.Uint64(r.b[int(r.off):end:end])
+	end := r.off + 8
+	rv := binary.LittleEndian.Uint64(r.b[r.off:end:end])
 	r.off += 8
 	return rv
 }
@@ -90,9 +92,25 @@ func (r *reader) ReadString(len int64) string {
 	return string(b)
 }
 
+// ReadBytes returns the next 'len' bytes, without copying them.
+func (r *reader) ReadBytes(len int64) []byte {
+	b := r.b[r.off : r.off+len]
+	r.off += len
+	return b
+}
+
 func toString(b []byte) string {
 	if len(b) == 0 {
 		return ""
 	}
 	return unsafe.String(&b[0], len(b))
 }
+
+// intLen converts the length 'n' recorded in a coverage data file to an
+// int, failing rather than truncating it where int is 32 bits wide.
+func intLen(n uint64) (int, error) {
+	if n > math.MaxInt {
+		return 0, fmt.Errorf("length %d too large for this platform", n)
+	}
+	return int(n), nil
+}
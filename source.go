@@ -0,0 +1,155 @@
+package gocov
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// readSource returns the contents of the source file 'name', read from
+// the configured SourceFS, or from the OS file system if there is none.
+func (c *Coverage) readSource(name string) ([]byte, error) {
+	return readSource(c.config.SourceFS, name)
+}
+
+// readSource returns the contents of the source file 'name', read from
+// 'fsys', or from the OS file system if 'fsys' is nil. Absolute names
+// are looked up in 'fsys' without their leading slash.
+func readSource(fsys fs.FS, name string) ([]byte, error) {
+	if fsys == nil {
+		return os.ReadFile(name)
+	}
+	name = strings.TrimPrefix(strings.ReplaceAll(name, `\`, "/"), "/")
+	return fs.ReadFile(fsys, name)
+}
+
+// applySourceOptions applies the options of 'c' that depend on the
+// source files, ExcludeGenerated and SourceFS, to freshly read 'data'.
+func applySourceOptions(data *CoverageData, c CoverageConfig) {
+	if c.ExcludeGenerated {
+		data.DropGenerated(c.SourceFS)
+	}
+	data.HashSources(c.SourceFS)
+}
+
+// HashSources sets the SrcHash of every function whose source file can
+// be read from 'fsys'. It does nothing if 'fsys' is nil. Readers that
+// take a CoverageConfig do this when its SourceFS is set; data read
+// otherwise, such as by ReadDir, ReadFS or ReadTar, can be hashed with
+// this method.
+func (c *CoverageData) HashSources(fsys fs.FS) {
+	if fsys == nil {
+		return
+	}
+	hashes := make(map[string]*[16]byte)
+	for _, p := range c.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				h, ok := hashes[fn.SrcFile]
+				if !ok {
+					if src, err := readSource(fsys, fn.SrcFile); err == nil {
+						sum := md5.Sum(src)
+						h = &sum
+					}
+					hashes[fn.SrcFile] = h
+				}
+				if h != nil {
+					fn.SrcHash = *h
+				}
+			}
+		}
+	}
+}
+
+// generatedRE matches the comment marking generated Go source files,
+// as described by "go help generate".
+var generatedRE = regexp.MustCompile(`^// Code generated .* DO NOT EDIT\.$`)
+
+// isGenerated reports whether 'src' bears the generated code marker,
+// which must come before the package clause.
+func isGenerated(src []byte) bool {
+	sc := bufio.NewScanner(bytes.NewReader(src))
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if generatedRE.MatchString(line) {
+			return true
+		}
+		if strings.HasPrefix(line, "package ") {
+			return false
+		}
+	}
+	return false
+}
+
+// DropGenerated removes the functions of generated source files, read
+// from 'fsys', or from the OS file system if 'fsys' is nil. Files that
+// can't be read are kept. Readers that take a CoverageConfig do this
+// when its ExcludeGenerated option is set.
+func (c *CoverageData) DropGenerated(fsys fs.FS) {
+	generated := make(map[string]bool)
+	for _, p := range c.PodData {
+		for _, pack := range p.Packages {
+			for fnIdx, fn := range pack.Funcs {
+				gen, ok := generated[fn.SrcFile]
+				if !ok {
+					src, err := readSource(fsys, fn.SrcFile)
+					gen = err == nil && isGenerated(src)
+					generated[fn.SrcFile] = gen
+				}
+				if gen {
+					delete(pack.Funcs, fnIdx)
+				}
+			}
+		}
+	}
+}
+
+// WithSourceFS returns a copy of 'c' that reads source files from
+// 'fsys' instead of the OS file system.
+func (c *Coverage) WithSourceFS(fsys fs.FS) *Coverage {
+	config := c.config
+	config.SourceFS = fsys
+	return &Coverage{
+		config: config,
+		Data:   c.Data,
+	}
+}
+
+// SourceLineCounts is like LineCounts, but takes the total number of
+// lines of each file from its source, read from the configured
+// SourceFS (or the OS file system), for tools that expect line coverage
+// over whole files rather than over the instrumented lines only. The
+// total is never less than the number of coverable lines. Sources are
+// read by their SrcFile, even where QualifyFiles reports them by
+// another name.
+func (c *Coverage) SourceLineCounts() (map[string]LineCount, error) {
+	srcFiles := make(map[string]string)
+	for _, p := range c.Data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				srcFiles[c.fileName(pack, fn)] = fn.SrcFile
+			}
+		}
+	}
+	counts := c.LineCounts()
+	for name, lc := range counts {
+		src, err := c.readSource(srcFiles[name])
+		if err != nil {
+			return nil, fmt.Errorf("reading source for %s: %v", name, err)
+		}
+		n := bytes.Count(src, []byte("\n"))
+		if len(src) > 0 && src[len(src)-1] != '\n' {
+			n++
+		}
+		if n > lc.Total {
+			lc.Total = n
+		}
+		counts[name] = lc
+	}
+	return counts, nil
+}
@@ -0,0 +1,207 @@
+package gocov
+
+import (
+	"crypto/md5"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestSourceLineCountsSourceFS(t *testing.T) {
+	c := readTestCoverage(t, setDir)
+	lines := c.LineCounts()
+	const lib, util, main = "example.com/prog/lib/lib.go", "example.com/prog/util/util.go", "example.com/prog/main.go"
+
+	// Sources of 40 lines for lib.go, an empty util.go (which has fewer
+	// lines than the coverable ones) and 17 lines without a final
+	// newline for main.go.
+	fsys := fstest.MapFS{
+		lib:  {Data: []byte(strings.Repeat("\n", 40))},
+		util: {Data: []byte{}},
+		main: {Data: []byte(strings.Repeat("\n", 16) + "}")},
+	}
+	got, err := c.WithSourceFS(fsys).SourceLineCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int{lib: 40, util: lines[util].Total, main: 17}
+	for name, total := range want {
+		if got[name].Total != total || got[name].Covered != lines[name].Covered {
+			t.Errorf("%s: %+v, want %d lines of which %d covered", name, got[name], total, lines[name].Covered)
+		}
+	}
+
+	// The sources of the fixture, through the OS file system.
+	got, err = c.WithSourceFS(os.DirFS(srcDir)).SourceLineCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[lib].Total != 26 || got[main].Total != 17 {
+		t.Errorf("fixture sources: %+v, want 26 lines for %s and 17 for %s", got, lib, main)
+	}
+
+	delete(fsys, util)
+	if _, err := c.WithSourceFS(fsys).SourceLineCounts(); err == nil {
+		t.Errorf("SourceLineCounts with %s missing succeeded", util)
+	}
+}
+
+func TestSourceLineCountsOS(t *testing.T) {
+	// Without a SourceFS, source files are read from the OS file
+	// system, here relative to the test's directory.
+	file := filepath.Join(srcDir, "example.com", "prog", "lib", "lib.go")
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/prog/lib",
+				testFunc("Add", file, []uint32{4, 5, 7}, []uint32{1, 0, 1}),
+			),
+		},
+	})
+	got, err := readTestCoverage(t, dir).SourceLineCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (LineCount{Covered: 2, Total: 26}); got[file] != want {
+		t.Errorf("%s: %+v, want %+v", file, got[file], want)
+	}
+}
+
+func TestHashSources(t *testing.T) {
+	const lib, util = "example.com/prog/lib/lib.go", "example.com/prog/util/util.go"
+	src, err := os.ReadFile(filepath.Join(srcDir, lib))
+	if err != nil {
+		t.Fatal(err)
+	}
+	libHash := md5.Sum(src)
+
+	// Read with a SourceFS, every function of lib.go carries the hash of
+	// its source.
+	data, err := readDir(setDir, CoverageConfig{SourceFS: os.DirFS(srcDir)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"Add", "unused", "*T.Method"} {
+		fn := findFunc(data, setHash, "example.com/prog/lib", name)
+		if fn == nil {
+			t.Fatalf("no function %s", name)
+		}
+		if fn.SrcHash != libHash {
+			t.Errorf("%s: SrcHash %x, want %x", name, fn.SrcHash, libHash)
+		}
+	}
+	// The hash is carried over by merging.
+	merged := &CoverageData{PodData: make(map[string]*PodData)}
+	merged.MergeUnion(data)
+	if fn := findFunc(merged, setHash, "example.com/prog/lib", "Add"); fn == nil || fn.SrcHash != libHash {
+		t.Errorf("merged Add: %+v, want SrcHash %x", fn, libHash)
+	}
+
+	// Without a SourceFS nothing is hashed, and files missing from the
+	// SourceFS are left unhashed.
+	data = readTestDir(t, setDir)
+	if fn := findFunc(data, setHash, "example.com/prog/lib", "Add"); fn.SrcHash != ([16]byte{}) {
+		t.Errorf("Add read without SourceFS has SrcHash %x", fn.SrcHash)
+	}
+	data.HashSources(fstest.MapFS{util: {Data: []byte("package util\n")}})
+	if fn := findFunc(data, setHash, "example.com/prog/lib", "Add"); fn.SrcHash != ([16]byte{}) {
+		t.Errorf("Add missing from SourceFS has SrcHash %x", fn.SrcHash)
+	}
+	if fn := findFunc(data, setHash, "example.com/prog/util", "Never"); fn.SrcHash != md5.Sum([]byte("package util\n")) {
+		t.Errorf("Never has SrcHash %x, want that of its source", fn.SrcHash)
+	}
+}
+
+func TestIsGenerated(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{"// Code generated by mockgen. DO NOT EDIT.\n\npackage p\n", true},
+		{"// Copyright 2023.\n\n// Code generated by protoc-gen-go. DO NOT EDIT.\r\n// source: a.proto\n\npackage p\n", true},
+		{"package p\n\n// Code generated by hand. DO NOT EDIT.\n", false},
+		{"// Code generated by mockgen. DO NOT EDIT\npackage p\n", false},
+		{"// code generated by mockgen. DO NOT EDIT.\npackage p\n", false},
+		{"package p\n", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := isGenerated([]byte(tt.src)); got != tt.want {
+			t.Errorf("isGenerated(%q) = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExcludeGenerated(t *testing.T) {
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/p",
+				testFunc("Hand", "p/hand.go", []uint32{3, 4}, []uint32{1, 0}),
+				testFunc("Gen", "p/gen.go", []uint32{5, 6, 7, 8}, []uint32{0, 0, 0, 0}),
+				testFunc("Missing", "p/missing.go", []uint32{3, 4}, []uint32{1, 1}),
+			),
+		},
+	})
+	fsys := fstest.MapFS{
+		"p/hand.go": {Data: []byte("package p\n")},
+		"p/gen.go":  {Data: []byte("// Code generated by mockgen. DO NOT EDIT.\n\npackage p\n")},
+	}
+	read := func(exclude bool) *Coverage {
+		c := CoverageConfig{SourceFS: fsys, ExcludeGenerated: exclude}
+		data, err := readDir(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &Coverage{config: c, Data: data}
+	}
+	// gen.go is dropped, missing.go can't be read and is kept.
+	if got := read(true).GetPercent(); got != 75 {
+		t.Errorf("GetPercent excluding generated files = %v, want 75", got)
+	}
+	if got := read(false).GetPercent(); got != 37.5 {
+		t.Errorf("GetPercent = %v, want 37.5", got)
+	}
+	if _, ok := read(true).FilePercents()["p/gen.go"]; ok {
+		t.Error("FilePercents has generated file p/gen.go")
+	}
+}
+
+func TestSourceLineCountsQualifyFiles(t *testing.T) {
+	// Bare file names are reported qualified by their package, but the
+	// sources are still read by the names the functions carry.
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/a",
+				testFunc("A", "a.go", []uint32{3, 4}, []uint32{1, 0}),
+			),
+			1: testPackage("example.com/test/b",
+				testFunc("B", "b.go", []uint32{3}, []uint32{1}),
+			),
+		},
+	})
+	c := readTestCoverage(t, dir)
+	c.config.QualifyFiles = true
+	fsys := fstest.MapFS{
+		"a.go": {Data: []byte(strings.Repeat("\n", 30))},
+		"b.go": {Data: []byte(strings.Repeat("\n", 10))},
+	}
+	got, err := c.WithSourceFS(fsys).SourceLineCounts()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]LineCount{
+		"example.com/test/a/a.go": {Covered: 1, Total: 30},
+		"example.com/test/b/b.go": {Covered: 1, Total: 10},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("SourceLineCounts = %+v, want %+v", got, want)
+	}
+}
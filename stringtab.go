@@ -8,6 +8,9 @@ package gocov
 type sReader struct {
 	r    *reader
 	strs []string
+	// intern, if set, is used to share a single copy of each distinct
+	// string across string tables.
+	intern map[string]string
 }
 
 // NewReader creates a stringtab.Reader to read the contents
@@ -25,10 +28,24 @@ func (str *sReader) Read() {
 	str.strs = make([]string, 0, numEntries)
 	for idx := 0; idx < numEntries; idx++ {
 		slen := str.r.ReadULEB128()
-		str.strs = append(str.strs, str.r.ReadString(int64(slen)))
+		if str.intern != nil {
+			str.strs = append(str.strs, str.internString(str.r.ReadBytes(int64(slen))))
+		} else {
+			str.strs = append(str.strs, str.r.ReadString(int64(slen)))
+		}
 	}
 }
 
+// internString returns the interned copy of the string in 'b'.
+func (str *sReader) internString(b []byte) string {
+	if s, ok := str.intern[string(b)]; ok {
+		return s
+	}
+	s := string(b)
+	str.intern[s] = s
+	return s
+}
+
 // Entries returns the number of decoded entries in a string table.
 func (str *sReader) Entries() int {
 	return len(str.strs)
@@ -38,3 +55,62 @@ func (str *sReader) Entries() int {
 func (str *sReader) Get(idx uint32) string {
 	return str.strs[idx]
 }
+
+// sWriter builds a string table for serialization; it is the
+// counterpart of sReader.
+type sWriter struct {
+	stab map[string]uint32
+	strs []string
+}
+
+// newSWriter creates an sWriter whose table already holds the empty
+// string at index 0, as the Go toolchain's writers do.
+func newSWriter() *sWriter {
+	stw := &sWriter{
+		stab: make(map[string]uint32),
+	}
+	stw.Lookup("")
+	return stw
+}
+
+// Lookup returns the index of string 's' in the table, adding a new
+// entry if need be.
+func (stw *sWriter) Lookup(s string) uint32 {
+	if idx, ok := stw.stab[s]; ok {
+		return idx
+	}
+	idx := uint32(len(stw.strs))
+	stw.stab[s] = idx
+	stw.strs = append(stw.strs, s)
+	return idx
+}
+
+// Entries returns the number of strings in the table.
+func (stw *sWriter) Entries() int {
+	return len(stw.strs)
+}
+
+// Append appends the serialized string table to 'b'.
+func (stw *sWriter) Append(b []byte) []byte {
+	b = appendULEB128(b, uint64(len(stw.strs)))
+	for _, s := range stw.strs {
+		b = appendULEB128(b, uint64(len(s)))
+		b = append(b, s...)
+	}
+	return b
+}
+
+// appendULEB128 appends 'v' to 'b' in unsigned LEB128 encoding.
+func appendULEB128(b []byte, v uint64) []byte {
+	for {
+		c := uint8(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		b = append(b, c)
+		if c&0x80 == 0 {
+			return b
+		}
+	}
+}
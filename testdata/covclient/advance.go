@@ -0,0 +1,62 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/zeu5/gocov"
+)
+
+//go:noinline
+func first() int { return 1 }
+
+//go:noinline
+func second() int { return 2 }
+
+// advance calls first and second between two calls of Advance and
+// prints which of them each reports as newly covered, followed by those
+// covered in the data held by the Coverage.
+func advance(args []string) error {
+	c, err := gocov.GetCoverage(gocov.CoverageConfig{})
+	if err != nil {
+		return err
+	}
+	first()
+	d, err := c.Advance()
+	if err != nil {
+		return err
+	}
+	fmt.Println("advance 1:", coveredFuncs(d))
+	first()
+	second()
+	if d, err = c.Advance(); err != nil {
+		return err
+	}
+	fmt.Println("advance 2:", coveredFuncs(d))
+	fmt.Println("held:", coveredFuncs(c.Data))
+	return nil
+}
+
+// coveredFuncs lists which of first and second have a covered unit in
+// 'data'.
+func coveredFuncs(data *gocov.CoverageData) string {
+	var names []string
+	for _, p := range data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				if fn.Name != "first" && fn.Name != "second" {
+					continue
+				}
+				for _, u := range fn.Units {
+					if u.Covered() {
+						names = append(names, fn.Name)
+						break
+					}
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+	return strings.Join(names, " ")
+}
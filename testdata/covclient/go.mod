@@ -0,0 +1,9 @@
+module example.com/covclient
+
+go 1.20
+
+require github.com/zeu5/gocov v0.0.0
+
+require golang.org/x/tools v0.12.0 // indirect
+
+replace github.com/zeu5/gocov => ../..
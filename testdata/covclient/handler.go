@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+
+	"github.com/zeu5/gocov"
+)
+
+// handler serves ServeCoverageHandler and fetches both response
+// formats from several goroutines at once.
+func handler(args []string) error {
+	srv := httptest.NewServer(gocov.ServeCoverageHandler())
+	defer srv.Close()
+
+	const n = 8
+	errs := make(chan error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(asJSON bool) {
+			defer wg.Done()
+			errs <- fetch(srv.URL, asJSON)
+		}(i%2 == 0)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	fmt.Println("ok")
+	return nil
+}
+
+func fetch(url string, asJSON bool) error {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		req.Header.Set("Accept", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s: %s", resp.Status, body)
+	}
+	ct := resp.Header.Get("Content-Type")
+	if asJSON {
+		var data gocov.CoverageData
+		if err := json.Unmarshal(body, &data); err != nil {
+			return fmt.Errorf("decoding JSON response: %v", err)
+		}
+		if ct != "application/json" || len(data.PodData) != 1 {
+			return fmt.Errorf("JSON response with content type %q and %d pods", ct, len(data.PodData))
+		}
+		return nil
+	}
+	if !strings.HasPrefix(ct, "text/plain") || !strings.HasPrefix(string(body), "mode: atomic\n") {
+		return fmt.Errorf("text response with content type %q starts %.20q", ct, body)
+	}
+	return nil
+}
@@ -0,0 +1,32 @@
+// Command covclient exercises the parts of gocov that read the coverage
+// data of the running program. It must be built with -cover; the tests
+// of gocov build and run it, with the scenario to run as argument, and
+// check what it prints.
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+var scenarios = map[string]func(args []string) error{
+	"advance": advance,
+	"handler": handler,
+	"usedir":  usedir,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprintln(os.Stderr, "usage: covclient scenario [args]")
+		os.Exit(2)
+	}
+	run, ok := scenarios[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "covclient: unknown scenario %q\n", os.Args[1])
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "covclient: %s: %v\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/zeu5/gocov"
+)
+
+// usedir calls GetCoverage twice with UseDir set to args[0], backdating
+// the meta-data file in between, and prints whether the second call
+// left it alone along with the number of files of each kind.
+func usedir(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("usage: usedir dir")
+	}
+	dir := args[0]
+	c := gocov.CoverageConfig{UseDir: dir}
+	if _, err := gocov.GetCoverage(c); err != nil {
+		return err
+	}
+	metas, err := filepath.Glob(filepath.Join(dir, "covmeta.*"))
+	if err != nil {
+		return err
+	}
+	if len(metas) != 1 {
+		return fmt.Errorf("found meta-data files %v after first call, want one", metas)
+	}
+	old := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(metas[0], old, old); err != nil {
+		return err
+	}
+	if _, err := gocov.GetCoverage(c); err != nil {
+		return err
+	}
+	fi, err := os.Stat(metas[0])
+	if err != nil {
+		return err
+	}
+	counters, err := filepath.Glob(filepath.Join(dir, "covcounters.*"))
+	if err != nil {
+		return err
+	}
+	metas, err = filepath.Glob(filepath.Join(dir, "covmeta.*"))
+	if err != nil {
+		return err
+	}
+	fmt.Println("meta rewritten:", !fi.ModTime().Equal(old))
+	fmt.Println("meta files:", len(metas))
+	fmt.Println("counter files:", len(counters))
+	return nil
+}
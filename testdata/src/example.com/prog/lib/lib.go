@@ -0,0 +1,26 @@
+package lib
+
+func Add(a, b int) int {
+	if a > 100 {
+		return 0
+	}
+	return a + b
+}
+
+func unused(x int) int {
+	for i := 0; i < x; i++ {
+		x--
+	}
+	return x
+}
+
+type T struct{}
+
+func (t *T) Method() int {
+	f := func() int { return 1 }
+	return f()
+}
+
+type u struct{}
+
+func (u) Exp() {}
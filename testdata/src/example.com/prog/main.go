@@ -0,0 +1,17 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"example.com/prog/lib"
+	"example.com/prog/util"
+)
+
+func main() {
+	fmt.Println(lib.Add(1, 2))
+	if len(os.Args) > 1 {
+		fmt.Println(util.Never())
+		fmt.Println((&lib.T{}).Method())
+	}
+}
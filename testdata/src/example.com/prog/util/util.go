@@ -0,0 +1,3 @@
+package util
+
+func Never() string { return "never" }
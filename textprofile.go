@@ -0,0 +1,197 @@
+package gocov
+
+import (
+	"fmt"
+	"math"
+	"path"
+
+	"golang.org/x/tools/cover"
+)
+
+// textProfilePod is the key of the pod holding the coverage read from
+// text profiles that doesn't match any unit of a coverage data
+// directory.
+const textProfilePod = "textprofile"
+
+// ReadTextProfile reads the text coverage profile 'file', as written
+// by "go test -coverprofile", keeping only the files of the packages
+// that match 'matchPkgs'. See ReadMixed for how the profile is turned
+// into coverage data.
+func ReadTextProfile(file string, matchPkgs []string) (*Coverage, error) {
+	return ReadMixed([]string{file}, nil, matchPkgs)
+}
+
+// ReadMixed reads the text profiles 'textProfiles' and the coverage
+// data directories 'covDirs', and merges them into a single data set.
+// The directories are merged as by Merge. Text profiles don't record
+// packages or functions, so their blocks are merged into the units of
+// the directories by source file and position instead; blocks that
+// match no unit go to a pod of their own, keyed "textprofile", with a
+// package per source directory and a nameless function per source
+// file. If any input is in set mode, the result is in set mode, with
+// counts of 0 or 1; otherwise counts are added up.
+func ReadMixed(textProfiles []string, covDirs []string, matchPkgs []string) (*Coverage, error) {
+	data := &CoverageData{
+		PodData: make(map[string]*PodData),
+	}
+	for _, dir := range covDirs {
+		d, err := readDir(dir, CoverageConfig{MatchPkgs: matchPkgs})
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", dir, err)
+		}
+		data.Merge(d)
+		data.stats.FilesRead += d.stats.FilesRead
+		data.stats.BytesRead += d.stats.BytesRead
+	}
+
+	var profiles []*cover.Profile
+	setMode := false
+	for _, file := range textProfiles {
+		ps, err := cover.ParseProfiles(file)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %v", file, err)
+		}
+		for _, p := range ps {
+			if len(matchPkgs) != 0 && !matchAnyPattern(matchPkgs, path.Dir(p.FileName)) {
+				continue
+			}
+			setMode = setMode || p.Mode == "set"
+			profiles = append(profiles, p)
+		}
+	}
+	for _, p := range data.PodData {
+		setMode = setMode || p.CounterMode == CtrModeSet
+	}
+	if setMode {
+		for _, p := range data.PodData {
+			p.CounterMode = CtrModeSet
+			for _, pack := range p.Packages {
+				for _, fn := range pack.Funcs {
+					for _, u := range fn.Units {
+						u.Count = setCount(u.Count)
+					}
+				}
+			}
+		}
+	}
+
+	units := make(map[fileUnit]*FuncUnit)
+	for _, p := range data.PodData {
+		for _, pack := range p.Packages {
+			for _, fn := range pack.Funcs {
+				file := pack.QualifiedFile(fn)
+				for _, u := range fn.Units {
+					units[fileUnit{file, funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}}] = u
+				}
+			}
+		}
+	}
+
+	m := &merger{}
+	mode := CtrModeCount
+	if setMode {
+		mode = CtrModeSet
+	}
+	m.SetModeAndGranularity(mode, CtrGranularityPerBlock)
+	for _, p := range profiles {
+		for _, b := range p.Blocks {
+			u := &FuncUnit{
+				StLine:  profileUint(b.StartLine),
+				StCol:   profileUint(b.StartCol),
+				EnLine:  profileUint(b.EndLine),
+				EnCol:   profileUint(b.EndCol),
+				NxStmts: profileUint(b.NumStmt),
+			}
+			count := profileUint(b.Count)
+			if count != 0 {
+				u.RunsCovered = 1
+			}
+			key := fileUnit{p.FileName, funit{u.StLine, u.EnLine, u.StCol, u.EnCol, u.NxStmts}}
+			cu, ok := units[key]
+			if !ok {
+				fn := data.textProfileFunc(p.FileName, mode)
+				fn.Units = append(fn.Units, u)
+				units[key] = u
+				cu = u
+			}
+			dst, src := []uint32{cu.Count}, []uint32{count}
+			if _, ovf := m.MergeCounters(dst, src); ovf {
+				data.overflowed = true
+			}
+			cu.Count = dst[0]
+			if cu != u {
+				cu.RunsCovered += u.RunsCovered
+			}
+		}
+	}
+
+	return &Coverage{
+		config: CoverageConfig{MatchPkgs: matchPkgs},
+		Data:   data,
+	}, nil
+}
+
+// textProfileFunc returns the function standing for source file 'file'
+// in the text profile pod, creating the pod, package and function as
+// needed.
+func (c *CoverageData) textProfileFunc(file string, mode counterMode) *Func {
+	pod, ok := c.PodData[textProfilePod]
+	if !ok {
+		pod = &PodData{
+			CounterGranularity: CtrGranularityPerBlock,
+			CounterMode:        mode,
+			Packages:           make(map[uint32]*Package),
+		}
+		c.PodData[textProfilePod] = pod
+	}
+	dir := path.Dir(file)
+	var pack *Package
+	for _, pa := range pod.Packages {
+		if pa.ImportPath == dir {
+			pack = pa
+			break
+		}
+	}
+	if pack == nil {
+		id := uint32(len(pod.Packages))
+		pack = &Package{
+			ID:         id,
+			Name:       path.Base(dir),
+			ImportPath: dir,
+			Funcs:      make(map[uint32]*Func),
+		}
+		pod.Packages[id] = pack
+	}
+	for _, fn := range pack.Funcs {
+		if fn.SrcFile == file {
+			return fn
+		}
+	}
+	fn := &Func{SrcFile: file}
+	pack.Funcs[pack.NumFuncs] = fn
+	pack.NumFuncs++
+	return fn
+}
+
+// profileUint converts a value of a text profile block to the uint32 of
+// coverage data, clamping it to the uint32 range.
+func profileUint(v int) uint32 {
+	switch {
+	case v < 0:
+		return 0
+	case uint64(v) > math.MaxUint32:
+		return math.MaxUint32
+	}
+	return uint32(v)
+}
+
+// matchAnyPattern reports whether 'path' matches any of the package
+// patterns in 'patterns'.
+func matchAnyPattern(patterns []string, path string) bool {
+	for _, p := range patterns {
+		if matchSimplePattern(p, path) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package gocov
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeProfileFile writes 'profile' to a file of its own and returns
+// its name.
+func writeProfileFile(t *testing.T, profile string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), "cover.out")
+	if err := os.WriteFile(name, []byte(profile), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestReadMixed(t *testing.T) {
+	// The first block hits a unit of Add that the directory covered,
+	// the second the one it didn't, and the third a file the
+	// directory knows nothing of.
+	profile := writeProfileFile(t, `mode: count
+example.com/prog/lib/lib.go:4.2,4.13 1 2
+example.com/prog/lib/lib.go:5.3,6.1 1 1
+example.com/prog/other/other.go:3.2,3.10 1 4
+`)
+	c, err := ReadMixed([]string{profile}, []string{countDir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := unitCounts(findFunc(c.Data, countHash, "example.com/prog/lib", "Add")), []uint32{5, 3, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Add counts %v, want %v", got, want)
+	}
+	if got, want := unitCounts(findFunc(c.Data, "textprofile", "example.com/prog/other", "")), []uint32{4}; !reflect.DeepEqual(got, want) {
+		t.Errorf("other.go counts %v, want %v", got, want)
+	}
+	// Of the 14 statements of the directory, 10 were covered; the text
+	// profile adds a covered one to each.
+	if got, want := c.GetPercent(), 100*12.0/15; got != want {
+		t.Errorf("GetPercent = %v, want %v", got, want)
+	}
+
+	// Package patterns apply to the text profile blocks as well.
+	c, err = ReadMixed([]string{profile}, []string{countDir}, []string{"example.com/prog/lib"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Data.PodData["textprofile"]; ok {
+		t.Error("text profile blocks of unmatched packages read")
+	}
+
+	// A set mode input turns the whole result into set mode.
+	setProfile := writeProfileFile(t, "mode: set\nexample.com/prog/lib/lib.go:5.3,6.1 1 1\n")
+	c, err = ReadMixed([]string{setProfile}, []string{countDir}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := c.Data.PodData[countHash].CounterMode; mode != CtrModeSet {
+		t.Errorf("counter mode %s, want set", mode)
+	}
+	if got, want := unitCounts(findFunc(c.Data, countHash, "example.com/prog/lib", "Add")), []uint32{1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("set mode Add counts %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,62 @@
+package gocov
+
+import "fmt"
+
+// Validate checks the internal consistency of the coverage data,
+// returning an error describing the first inconsistency found. It is
+// mostly useful for data that was not produced by this package's
+// readers. The checks are:
+//
+//   - every pod has a valid counter mode and granularity
+//   - packages are keyed by their ID, and functions by an index
+//     below the number of functions in their package
+//   - every unit has a start position before its end position
+//   - in set mode, no unit has a count other than 0 or 1
+//
+// The number of units of a function is not checked against the count
+// in its meta-data file: CoverageData does not record that count, and
+// the readers always build one unit per meta-data unit.
+func (c *CoverageData) Validate() error {
+	for _, hash := range sortedKeys(c.PodData) {
+		p := c.PodData[hash]
+		if p == nil {
+			return fmt.Errorf("pod %s: missing pod data", hash)
+		}
+		if p.CounterMode == CtrModeInvalid {
+			return fmt.Errorf("pod %s: invalid counter mode", hash)
+		}
+		if p.CounterGranularity == CtrGranularityInvalid {
+			return fmt.Errorf("pod %s: invalid counter granularity", hash)
+		}
+		for _, pkIdx := range sortedKeys(p.Packages) {
+			pack := p.Packages[pkIdx]
+			if pack == nil {
+				return fmt.Errorf("pod %s: package %d: missing package data", hash, pkIdx)
+			}
+			if pack.ID != pkIdx {
+				return fmt.Errorf("pod %s: package %d (%s): ID is %d", hash, pkIdx, pack.ImportPath, pack.ID)
+			}
+			for _, fnIdx := range sortedKeys(pack.Funcs) {
+				fn := pack.Funcs[fnIdx]
+				if fn == nil {
+					return fmt.Errorf("pod %s: package %s: func %d: missing func data", hash, pack.ImportPath, fnIdx)
+				}
+				if fnIdx >= pack.NumFuncs {
+					return fmt.Errorf("pod %s: package %s: func %d (%s): index out of range, package has %d funcs", hash, pack.ImportPath, fnIdx, fn.Name, pack.NumFuncs)
+				}
+				for i, u := range fn.Units {
+					if u == nil {
+						return fmt.Errorf("pod %s: package %s: func %s: unit %d: missing unit data", hash, pack.ImportPath, fn.Name, i)
+					}
+					if u.StLine > u.EnLine || (u.StLine == u.EnLine && u.StCol > u.EnCol) {
+						return fmt.Errorf("pod %s: package %s: func %s: unit %d: start %d:%d after end %d:%d", hash, pack.ImportPath, fn.Name, i, u.StLine, u.StCol, u.EnLine, u.EnCol)
+					}
+					if p.CounterMode == CtrModeSet && u.Count > 1 {
+						return fmt.Errorf("pod %s: package %s: func %s: unit %d: count %d in set mode", hash, pack.ImportPath, fn.Name, i, u.Count)
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
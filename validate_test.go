@@ -0,0 +1,66 @@
+package gocov
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateReadData(t *testing.T) {
+	for _, dir := range []string{setDir, countDir, variantDir} {
+		if err := readTestDir(t, dir).Validate(); err != nil {
+			t.Errorf("%s: Validate: %v", dir, err)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(d *CoverageData)
+		want   string
+	}{
+		{"nil pod", func(d *CoverageData) {
+			d.PodData[setHash] = nil
+		}, "missing pod data"},
+		{"invalid mode", func(d *CoverageData) {
+			d.PodData[setHash].CounterMode = CtrModeInvalid
+		}, "invalid counter mode"},
+		{"invalid granularity", func(d *CoverageData) {
+			d.PodData[setHash].CounterGranularity = CtrGranularityInvalid
+		}, "invalid counter granularity"},
+		{"nil package", func(d *CoverageData) {
+			d.PodData[setHash].Packages[1] = nil
+		}, "package 1: missing package data"},
+		{"package ID", func(d *CoverageData) {
+			d.PodData[setHash].Packages[1].ID = 7
+		}, "package 1 (example.com/prog/util): ID is 7"},
+		{"nil func", func(d *CoverageData) {
+			d.PodData[setHash].Packages[0].Funcs[1] = nil
+		}, "func 1: missing func data"},
+		{"func index", func(d *CoverageData) {
+			d.PodData[setHash].Packages[0].NumFuncs = 2
+		}, "func 2 (*T.Method): index out of range, package has 2 funcs"},
+		{"nil unit", func(d *CoverageData) {
+			d.PodData[setHash].Packages[0].Funcs[0].Units[1] = nil
+		}, "func Add: unit 1: missing unit data"},
+		{"unit lines", func(d *CoverageData) {
+			u := d.PodData[setHash].Packages[0].Funcs[0].Units[0]
+			u.StLine = u.EnLine + 1
+		}, "func Add: unit 0: start 5:2 after end 4:13"},
+		{"unit columns", func(d *CoverageData) {
+			u := d.PodData[setHash].Packages[0].Funcs[0].Units[0]
+			u.StCol = u.EnCol + 1
+		}, "func Add: unit 0: start 4:14 after end 4:13"},
+		{"set mode count", func(d *CoverageData) {
+			d.PodData[setHash].Packages[0].Funcs[0].Units[0].Count = 3
+		}, "func Add: unit 0: count 3 in set mode"},
+	}
+	for _, tt := range tests {
+		data := readTestDir(t, setDir)
+		tt.mutate(data)
+		err := data.Validate()
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: Validate = %v, want an error containing %q", tt.name, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package gocov
+
+import "encoding/hex"
+
+// MetaDataInfo describes the meta-data file of a pod.
+type MetaDataInfo struct {
+	// Hash is the hex encoded meta-data hash, the key of the pod in
+	// CoverageData.PodData.
+	Hash               string
+	CounterMode        counterMode
+	CounterGranularity CounterGranularity
+	NumPackages        int
+}
+
+// CovDataVisitor lets callers do their own aggregation of coverage data
+// instead of building a CoverageData. VisitDir invokes its methods as
+// it walks through the coverage data files:
+//
+//	for each pod P {
+//		BeginPod(P)
+//		VisitMetaData(meta-data file of P)
+//		for each counter data file D in P {
+//			BeginCounterDataFile(D)
+//			for each function F in D {
+//				VisitFuncCounterData(F)
+//			}
+//		}
+//		for each matching package PK in P {
+//			BeginPackage(PK)
+//			for each function F in PK {
+//				VisitFunc(F)
+//			}
+//		}
+//	}
+//
+// Counters are not merged on the visitor's behalf: a function executed
+// by several runs is visited once per counter data file (or segment).
+type CovDataVisitor interface {
+	BeginPod(p Pod)
+	VisitMetaData(info MetaDataInfo) error
+	// BeginCounterDataFile is passed the name of the counter data file
+	// and the args (such as os.Args, GOOS and GOARCH) recorded in it.
+	BeginCounterDataFile(name string, args map[string]string)
+	VisitFuncCounterData(p FuncPayload) error
+	// BeginPackage is passed the package without its functions.
+	BeginPackage(pkg *Package)
+	// VisitFunc is passed the function with its units; the unit counts
+	// are zero, the counters being delivered by VisitFuncCounterData.
+	VisitFunc(pkgIdx uint32, fnIdx uint32, fn *Func)
+}
+
+// VisitDir walks the coverage data files in 'dir', handing the packages
+// matching 'matchPkgs' (all if empty) and their counters to 'vis'.
+func VisitDir(dir string, vis CovDataVisitor, matchPkgs []string) error {
+	reader := makeCovDataDirReader(&visitorAdapter{vis: vis}, dir, matchPkgs...)
+	return reader.Visit()
+}
+
+// visitorAdapter implements covVisitor on top of a CovDataVisitor,
+// converting the decoder types into the exported ones.
+type visitorAdapter struct {
+	vis CovDataVisitor
+}
+
+func (a *visitorAdapter) BeginPod(p Pod) {
+	a.vis.BeginPod(p)
+}
+
+func (a *visitorAdapter) VisitMetaDataFile(mfr *coverageMetaFileReader) error {
+	fileHash := mfr.FileHash()
+	return a.vis.VisitMetaData(MetaDataInfo{
+		Hash:               hex.EncodeToString(fileHash[:]),
+		CounterMode:        mfr.CounterMode(),
+		CounterGranularity: mfr.CounterGranularity(),
+		NumPackages:        int(mfr.NumPackages()),
+	})
+}
+
+func (a *visitorAdapter) BeginCounterDataFile(cdf string, cdr *counterDataReader) {
+	args := make(map[string]string, len(cdr.args))
+	for k, v := range cdr.args {
+		args[k] = v
+	}
+	a.vis.BeginCounterDataFile(cdf, args)
+}
+
+func (a *visitorAdapter) VisitFuncCounterData(data funcPayload) error {
+	p := FuncPayload{
+		PkgIdx:   data.PkgIdx,
+		FuncIdx:  data.FuncIdx,
+		Counters: make([]uint32, len(data.Counters)),
+	}
+	copy(p.Counters, data.Counters)
+	return a.vis.VisitFuncCounterData(p)
+}
+
+func (a *visitorAdapter) BeginPackage(pd *coverageMetaDataDecoder, pkgIdx uint32) {
+	a.vis.BeginPackage(&Package{
+		ID:         pkgIdx,
+		Name:       pd.PackageName(),
+		ImportPath: pd.PackagePath(),
+		ModulePath: pd.ModulePath(),
+		NumFuncs:   pd.NumFuncs(),
+		MetaHash:   pd.MetaHash(),
+	})
+}
+
+func (a *visitorAdapter) VisitFunc(pkgIdx uint32, fnIdx uint32, fd *funcDesc) {
+	fn := &Func{
+		Name:         fd.Funcname,
+		SrcFile:      fd.Srcfile,
+		Units:        make([]*FuncUnit, len(fd.Units)),
+		Lit:          fd.Lit,
+		Instrumented: true,
+	}
+	for i, u := range fd.Units {
+		fn.Units[i] = &FuncUnit{
+			StLine:  u.StLine,
+			StCol:   u.StCol,
+			EnLine:  u.EnLine,
+			EnCol:   u.EnCol,
+			NxStmts: u.NxStmts,
+		}
+	}
+	a.vis.VisitFunc(pkgIdx, fnIdx, fn)
+}
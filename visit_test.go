@@ -0,0 +1,87 @@
+package gocov
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+)
+
+// countingVisitor is a CovDataVisitor that only sums up counters per
+// function and records what it is handed.
+type countingVisitor struct {
+	pods     []string
+	files    int
+	packages []string
+	funcs    map[string]pkfunc
+	sums     map[pkfunc][]uint32
+}
+
+func (v *countingVisitor) BeginPod(p Pod) {}
+
+func (v *countingVisitor) VisitMetaData(info MetaDataInfo) error {
+	v.pods = append(v.pods, info.Hash)
+	return nil
+}
+
+func (v *countingVisitor) BeginCounterDataFile(name string, args map[string]string) {
+	v.files++
+}
+
+func (v *countingVisitor) VisitFuncCounterData(p FuncPayload) error {
+	key := pkfunc{pk: p.PkgIdx, fcn: p.FuncIdx}
+	sum := v.sums[key]
+	if sum == nil {
+		sum = make([]uint32, len(p.Counters))
+		v.sums[key] = sum
+	}
+	for i, c := range p.Counters {
+		sum[i] += c
+	}
+	return nil
+}
+
+func (v *countingVisitor) BeginPackage(pkg *Package) {
+	v.packages = append(v.packages, pkg.ImportPath)
+}
+
+func (v *countingVisitor) VisitFunc(pkgIdx uint32, fnIdx uint32, fn *Func) {
+	v.funcs[v.packages[len(v.packages)-1]+"."+fn.Name] = pkfunc{pk: pkgIdx, fcn: fnIdx}
+}
+
+func TestVisitDir(t *testing.T) {
+	v := &countingVisitor{funcs: make(map[string]pkfunc), sums: make(map[pkfunc][]uint32)}
+	if err := VisitDir(countDir, v, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v.pods, []string{countHash}) || v.files != 3 {
+		t.Errorf("visited pods %v and %d counter data files, want [%s] and 3", v.pods, v.files, countHash)
+	}
+	sort.Strings(v.packages)
+	if want := []string{"example.com/prog", "example.com/prog/lib", "example.com/prog/util"}; !reflect.DeepEqual(v.packages, want) {
+		t.Errorf("visited packages %v, want %v", v.packages, want)
+	}
+
+	// In count mode the sums of the counters are the merged counts.
+	data := readTestDir(t, countDir)
+	for name, key := range v.funcs {
+		fn := data.PodData[countHash].Packages[key.pk].Funcs[key.fcn]
+		want := unitCounts(fn)
+		if got, ok := v.sums[key]; ok && !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: summed counters %v, want %v", name, got, want)
+		} else if !ok && !reflect.DeepEqual(want, make([]uint32, len(want))) {
+			t.Errorf("%s: no counters visited, want %v", name, want)
+		}
+	}
+	if len(v.funcs) != 6 {
+		t.Errorf("visited %d funcs, want 6", len(v.funcs))
+	}
+
+	// Only matching packages are visited.
+	v = &countingVisitor{funcs: make(map[string]pkfunc), sums: make(map[pkfunc][]uint32)}
+	if err := VisitDir(countDir, v, []string{"example.com/prog/util"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"example.com/prog/util"}; !reflect.DeepEqual(v.packages, want) {
+		t.Errorf("with a package filter: visited packages %v, want %v", v.packages, want)
+	}
+}
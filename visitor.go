@@ -3,6 +3,9 @@ package gocov
 import (
 	"encoding/hex"
 	"fmt"
+	"os"
+	"regexp"
+	"strings"
 )
 
 type pkfunc struct {
@@ -11,8 +14,8 @@ type pkfunc struct {
 
 // covDataVisitor encapsulates state and provides methods for implementing
 // various dump operations. Specifically, covDataVisitor implements the
-// CovDataVisitor interface, and is designed to be used in
-// concert with the CovDataReader utility, which abstracts away most
+// covVisitor interface, and is designed to be used in
+// concert with the covDataReader utility, which abstracts away most
 // of the grubby details of reading coverage data files.
 type covDataVisitor struct {
 	// for batch allocation of counter arrays
@@ -34,11 +37,108 @@ type covDataVisitor struct {
 	podHash   string
 	matchPkgs []string
 
+	// ovfUnits records, for the functions of the current pod whose
+	// counters saturated, the indices of the saturated units. The sites
+	// are resolved once the function names are known.
+	ovfUnits map[pkfunc][]int
+
+	// ctrLens records, per function of the current pod, the shortest
+	// and longest counter array of its payloads, which are checked
+	// against the function's units once these are known. The merged
+	// counters alone would hide a short payload among full ones.
+	ctrLens map[pkfunc]ctrLen
+
+	// runs counts, per unit of the functions of the current pod, the
+	// counter data payloads in which the unit was executed.
+	runs map[pkfunc][]uint32
+
+	// slashPaths rewrites backslashes in source file names.
+	slashPaths bool
+	// excludeStdlib and excludeSelf drop standard library packages
+	// and the packages of this module, respectively.
+	excludeStdlib bool
+	excludeSelf   bool
+	// funcRE, if set, selects the functions to keep by name.
+	funcRE *regexp.Regexp
+	// excludeTestFiles drops the functions of _test.go files.
+	excludeTestFiles bool
+	// strictCounters turns counter/unit count mismatches into an
+	// error, the first of which is recorded in 'err'. Payloads are
+	// checked as they are read against 'shapes', the functions of the
+	// packages of the current pod matched by 'shapePkgs', the MatchPkgs
+	// patterns the reader filters with.
+	strictCounters bool
+	err            error
+	shapes         map[pkfunc]funcShape
+	shapePkgs      []string
+
 	data *CoverageData
 }
 
-func (d *covDataVisitor) BeginPod(p pod) {
+// newCovDataVisitor creates a visitor that collects coverage data into
+// 'data' according to the config 'c'.
+func newCovDataVisitor(data *CoverageData, c CoverageConfig) (*covDataVisitor, error) {
+	d := &covDataVisitor{
+		cm:            &merger{},
+		data:          data,
+		slashPaths:    c.SlashPaths,
+		excludeStdlib: c.ExcludeStdlib,
+		excludeSelf:   c.ExcludeSelf,
+
+		strictCounters:   c.StrictCounters,
+		shapePkgs:        c.MatchPkgs,
+		excludeTestFiles: c.ExcludeTestFiles,
+	}
+	if c.FuncNameRegex != "" {
+		re, err := regexp.Compile(c.FuncNameRegex)
+		if err != nil {
+			return nil, fmt.Errorf("invalid function name regexp: %v", err)
+		}
+		d.funcRE = re
+	}
+	return d, nil
+}
+
+// ctrLen is the range of counter array lengths seen for a function.
+type ctrLen struct {
+	min, max int
+}
+
+// funcShape is the name and number of units of a function.
+type funcShape struct {
+	name  string
+	units int
+}
+
+func (d *covDataVisitor) BeginPod(p Pod) {
 	d.mm = make(map[pkfunc]funcPayload)
+	d.ovfUnits = make(map[pkfunc][]int)
+	d.ctrLens = make(map[pkfunc]ctrLen)
+	d.runs = make(map[pkfunc][]uint32)
+	// Each pod is decoded into its own PodData, so pods built with a
+	// different counter mode or granularity don't clash; only counters
+	// within a pod are merged.
+	d.cm.ResetModeAndGranularity()
+}
+
+// provenanceArgs lists the keys of the counter data file args section
+// that describe the configuration the program was built with. The Go
+// runtime doesn't write "goversion", but other producers of counter
+// data files may.
+var provenanceArgs = []string{"buildtags", "GOFLAGS", "goversion"}
+
+func (d *covDataVisitor) BeginCounterDataFile(cdf string, cdr *counterDataReader) {
+	podData := d.data.PodData[d.podHash]
+	for _, k := range provenanceArgs {
+		v, ok := cdr.args[k]
+		if !ok {
+			continue
+		}
+		if podData.Provenance == nil {
+			podData.Provenance = make(map[string]string)
+		}
+		podData.Provenance[k] = v
+	}
 }
 
 func (d *covDataVisitor) VisitFuncCounterData(data funcPayload) error {
@@ -46,6 +146,21 @@ func (d *covDataVisitor) VisitFuncCounterData(data funcPayload) error {
 		return nil
 	}
 	key := pkfunc{pk: data.PkgIdx, fcn: data.FuncIdx}
+	if shape, ok := d.shapes[key]; ok && len(data.Counters) != shape.units {
+		perFunc := d.cm.Granularity() == CtrGranularityPerFunc
+		if !(perFunc && len(data.Counters) == 1) {
+			return fmt.Errorf("pod %s: pkg %d func %d (%s) has %d counters but %d units",
+				d.podHash, data.PkgIdx, data.FuncIdx, shape.name, len(data.Counters), shape.units)
+		}
+	}
+	n := len(data.Counters)
+	if l, ok := d.ctrLens[key]; !ok {
+		d.ctrLens[key] = ctrLen{n, n}
+	} else if n < l.min {
+		d.ctrLens[key] = ctrLen{n, l.max}
+	} else if n > l.max {
+		d.ctrLens[key] = ctrLen{l.min, n}
+	}
 	val, ok := d.mm[key]
 	if !ok {
 		val = funcPayload{}
@@ -56,10 +171,26 @@ func (d *covDataVisitor) VisitFuncCounterData(data funcPayload) error {
 		val.Counters = d.AllocateCounters(len(data.Counters))
 		copy(val.Counters, t)
 	}
-	err, _ := d.cm.MergeCounters(val.Counters, data.Counters)
+	runs := d.runs[key]
+	if len(runs) < len(data.Counters) {
+		runs = append(runs, make([]uint32, len(data.Counters)-len(runs))...)
+		d.runs[key] = runs
+	}
+	for i, c := range data.Counters {
+		if c != 0 {
+			runs[i]++
+		}
+	}
+	// A payload shorter than earlier ones for the same function merges
+	// into their leading counters.
+	err, ovf := d.cm.MergeCounters(val.Counters[:len(data.Counters)], data.Counters)
 	if err != nil {
 		return err
 	}
+	if ovf {
+		d.data.overflowed = true
+		d.ovfUnits[key] = saturatedUnits(val.Counters)
+	}
 	d.mm[key] = val
 	return nil
 }
@@ -86,25 +217,41 @@ func (d *covDataVisitor) VisitMetaDataFile(mfr *coverageMetaFileReader) error {
 	// package/function combinations. This will help catch bugs in the
 	// counter file reader.
 	d.pkm = make(map[uint32]uint32)
+	d.shapes = nil
+	if d.strictCounters {
+		d.shapes = make(map[pkfunc]funcShape)
+	}
 	np := uint32(mfr.NumPackages())
-	payload := []byte{}
 	for pkIdx := uint32(0); pkIdx < np; pkIdx++ {
-		var pd *coverageMetaDataDecoder
-		var err error
-		pd, payload, err = mfr.GetPackageDecoder(pkIdx, payload)
+		mp, err := mfr.peekPackage(pkIdx)
 		if err != nil {
 			return fmt.Errorf("reading pkg %d from meta-file: %s", pkIdx, err)
 		}
-		d.pkm[pkIdx] = pd.NumFuncs()
+		d.pkm[pkIdx] = mp.nf
 
-		if d.matchPkg(pd.PackagePath()) {
-			podData.Packages[pkIdx] = &Package{
-				ID:         pkIdx,
-				ImportPath: pd.PackagePath(),
-				ModulePath: pd.ModulePath(),
-				Name:       pd.PackageName(),
-				NumFuncs:   pd.NumFuncs(),
-				Funcs:      make(map[uint32]*Func),
+		if !d.matchPkg(mp.path) {
+			continue
+		}
+		pd, err := mfr.packageDecoder(pkIdx)
+		if err != nil {
+			return fmt.Errorf("reading pkg %d from meta-file: %s", pkIdx, err)
+		}
+		podData.Packages[pkIdx] = &Package{
+			ID:         pkIdx,
+			ImportPath: pd.PackagePath(),
+			ModulePath: pd.ModulePath(),
+			Name:       pd.PackageName(),
+			NumFuncs:   pd.NumFuncs(),
+			Funcs:      make(map[uint32]*Func),
+			MetaHash:   pd.MetaHash(),
+		}
+		if d.shapes != nil && (len(d.shapePkgs) == 0 || matchAnyPattern(d.shapePkgs, mp.path)) {
+			var fd funcDesc
+			for fnIdx := uint32(0); fnIdx < pd.NumFuncs(); fnIdx++ {
+				if err := pd.ReadFunc(fnIdx, &fd); err != nil {
+					return fmt.Errorf("reading pkg %d from meta-file: %s", pkIdx, err)
+				}
+				d.shapes[pkfunc{pk: pkIdx, fcn: fnIdx}] = funcShape{fd.Funcname, len(fd.Units)}
 			}
 		}
 	}
@@ -122,43 +269,120 @@ func (d *covDataVisitor) BeginPackage(pd *coverageMetaDataDecoder, pkgIdx uint32
 }
 
 func (d *covDataVisitor) VisitFunc(pkgIdx uint32, fnIdx uint32, fd *funcDesc) {
+	podData := d.data.PodData[d.podHash]
+	packageData, ok := podData.Packages[pkgIdx]
+	if !ok {
+		return
+	}
+	if fnIdx+1 == packageData.NumFuncs {
+		// All functions of the package are known once the last one
+		// is visited.
+		defer packageData.nameLiterals()
+	}
+	if d.funcRE != nil && !d.funcRE.MatchString(fd.Funcname) {
+		return
+	}
+	if d.excludeTestFiles && strings.HasSuffix(fd.Srcfile, "_test.go") {
+		return
+	}
+
 	var counters []uint32
 	key := pkfunc{pk: pkgIdx, fcn: fnIdx}
 	v, haveCounters := d.mm[key]
 
+	// In perfunc granularity there is a single counter for the whole
+	// function, which applies to each of its units.
+	perFunc := podData.CounterGranularity == CtrGranularityPerFunc
+
 	if haveCounters {
 		counters = v.Counters
+		// A counter array that doesn't match the function's units
+		// points at a producer bug or mismatched files; units without
+		// a counter are left at zero, and extra counters are dropped.
+		lens := d.ctrLens[key]
+		for _, n := range []int{lens.min, lens.max} {
+			if n == len(fd.Units) || (perFunc && n == 1) {
+				continue
+			}
+			msg := fmt.Sprintf("pod %s: pkg %d func %d (%s) has %d counters but %d units",
+				d.podHash, pkgIdx, fnIdx, fd.Funcname, n, len(fd.Units))
+			if !d.strictCounters {
+				fmt.Fprintf(os.Stderr, "warning: %s\n", msg)
+			} else if d.err == nil {
+				d.err = fmt.Errorf("%s", msg)
+			}
+			break
+		}
+	}
+
+	srcFile := fd.Srcfile
+	if d.slashPaths {
+		// filepath.ToSlash only rewrites the separator of the OS we are
+		// running on, while the data may come from any OS.
+		srcFile = strings.ReplaceAll(srcFile, `\`, "/")
 	}
 
 	fnData := &Func{
-		Name:    fd.Funcname,
-		SrcFile: fd.Srcfile,
-		Units:   make([]*FuncUnit, len(fd.Units)),
+		Name:         fd.Funcname,
+		SrcFile:      srcFile,
+		Units:        funcUnits(fd, counters, d.runs[key], perFunc),
+		Lit:          fd.Lit,
+		Instrumented: true,
 	}
 
-	podData := d.data.PodData[d.podHash]
-	packageData := podData.Packages[pkgIdx]
 	packageData.Funcs[fnIdx] = fnData
+	for _, i := range d.ovfUnits[key] {
+		d.data.overflows = append(d.data.overflows, OverflowSite{
+			ImportPath: packageData.ImportPath,
+			FuncName:   fnData.Name,
+			Unit:       i,
+		})
+	}
+}
 
-	for i := 0; i < len(fd.Units); i++ {
-		u := fd.Units[i]
-		var count uint32
-		if counters != nil {
-			count = counters[i]
+// funcUnits returns the units of the function 'fd' with the merged
+// 'counters' and per-unit 'runs' of its payloads applied. Units without
+// a counter are left at zero. In perfunc granularity the single counter
+// applies to every unit.
+func funcUnits(fd *funcDesc, counters, runs []uint32, perFunc bool) []*FuncUnit {
+	units := make([]*FuncUnit, len(fd.Units))
+	for i, u := range fd.Units {
+		var count, nruns uint32
+		ci := i
+		if perFunc {
+			ci = 0
+		}
+		if ci < len(counters) {
+			count = counters[ci]
+		}
+		if ci < len(runs) {
+			nruns = runs[ci]
 		}
 
-		fnData.Units[i] = &FuncUnit{
-			StLine:  u.StLine,
-			EnLine:  u.EnLine,
-			StCol:   u.StCol,
-			EnCol:   u.EnCol,
-			NxStmts: u.NxStmts,
-			Count:   count,
+		units[i] = &FuncUnit{
+			StLine:      u.StLine,
+			EnLine:      u.EnLine,
+			StCol:       u.StCol,
+			EnCol:       u.EnCol,
+			NxStmts:     u.NxStmts,
+			Count:       count,
+			RunsCovered: nruns,
 		}
 	}
+	return units
 }
 
+// selfModulePath is the module path of this package, whose packages
+// are dropped by the ExcludeSelf option.
+const selfModulePath = "github.com/zeu5/gocov"
+
 func (d *covDataVisitor) matchPkg(path string) bool {
+	if d.excludeStdlib && !strings.Contains(strings.SplitN(path, "/", 2)[0], ".") {
+		return false
+	}
+	if d.excludeSelf && (path == selfModulePath || strings.HasPrefix(path, selfModulePath+"/")) {
+		return false
+	}
 	if len(d.matchPkgs) == 0 {
 		return true
 	}
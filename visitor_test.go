@@ -0,0 +1,377 @@
+package gocov
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSlashPaths(t *testing.T) {
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/win",
+				testFunc("A", `C:\work\win\a.go`, []uint32{3}, []uint32{1}),
+				testFunc("B", `C:\work\win\sub\b.go`, []uint32{5}, []uint32{1}),
+				testFunc("C", "/work/win/c.go", []uint32{7}, []uint32{1}),
+			),
+		},
+	})
+	files := func(c CoverageConfig) []string {
+		data, err := readDir(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data.SourceFiles()
+	}
+
+	want := []string{"/work/win/c.go", "C:/work/win/a.go", "C:/work/win/sub/b.go"}
+	if got := files(CoverageConfig{SlashPaths: true}); !reflect.DeepEqual(got, want) {
+		t.Errorf("with SlashPaths: source files %q, want %q", got, want)
+	}
+	// Without the option the names are kept as recorded.
+	want = []string{"/work/win/c.go", `C:\work\win\a.go`, `C:\work\win\sub\b.go`}
+	if got := files(CoverageConfig{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("without SlashPaths: source files %q, want %q", got, want)
+	}
+}
+
+func TestExcludeStdlibAndSelf(t *testing.T) {
+	dir, _ := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("strings",
+				testFunc("Index", "strings/strings.go", []uint32{3, 4}, []uint32{1, 1}),
+			),
+			1: testPackage(selfModulePath+"/bio",
+				testFunc("NewReader", "bio/reader.go", []uint32{5}, []uint32{1}),
+			),
+			2: testPackage("example.com/test/app",
+				testFunc("Run", "app/app.go", []uint32{3, 4, 5, 6}, []uint32{1, 0, 0, 0}),
+			),
+		},
+	})
+	tests := []struct {
+		c    CoverageConfig
+		want float64
+	}{
+		{CoverageConfig{}, 100 * 4.0 / 7},
+		{CoverageConfig{ExcludeStdlib: true}, 100 * 2.0 / 5},
+		{CoverageConfig{ExcludeSelf: true}, 100 * 3.0 / 6},
+		{CoverageConfig{ExcludeStdlib: true, ExcludeSelf: true}, 100 * 1.0 / 4},
+	}
+	for _, tt := range tests {
+		data, err := readDir(dir, tt.c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		c := &Coverage{config: tt.c, Data: data}
+		if got := c.GetPercent(); got != tt.want {
+			t.Errorf("ExcludeStdlib=%v ExcludeSelf=%v: %.2f%% covered, want %.2f%%",
+				tt.c.ExcludeStdlib, tt.c.ExcludeSelf, got, tt.want)
+		}
+	}
+}
+
+func TestReadMixedGranularity(t *testing.T) {
+	pod := func(gran CounterGranularity, pkg string) *PodData {
+		return &PodData{
+			CounterMode:        CtrModeSet,
+			CounterGranularity: gran,
+			Packages: map[uint32]*Package{
+				0: testPackage(pkg, testFunc("F", "/src/f.go", []uint32{3, 4}, []uint32{1, 1})),
+			},
+		}
+	}
+	blockDir, blockHash := writeTestPod(t, pod(CtrGranularityPerBlock, "example.com/test/block"))
+	funcDir, funcHash := writeTestPod(t, pod(CtrGranularityPerFunc, "example.com/test/fn"))
+	dir := mergeTestDirs(t, blockDir, funcDir)
+
+	data := readTestDir(t, dir)
+	for hash, want := range map[string]CounterGranularity{blockHash: CtrGranularityPerBlock, funcHash: CtrGranularityPerFunc} {
+		p, ok := data.PodData[hash]
+		if !ok {
+			t.Errorf("pod %s not read", hash)
+		} else if p.CounterGranularity != want {
+			t.Errorf("pod %s: granularity %s, want %s", hash, p.CounterGranularity, want)
+		}
+	}
+
+	// The clash only matters when merging data of the same pod.
+	c := &Coverage{Data: data}
+	other := &Coverage{Data: readTestDir(t, blockDir)}
+	other.Data.PodData[blockHash].CounterGranularity = CtrGranularityPerFunc
+	if err := c.Merge(other); err == nil {
+		t.Error("merging pods of different granularity succeeded")
+	}
+}
+
+func TestCounterUnitMismatch(t *testing.T) {
+	// Add has three units, but the new counter data file only has a
+	// counter for the first.
+	dir := copyTestDir(t, countDir)
+	writeCounterFile(t, dir, countHash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1}},
+	})
+
+	// The mismatch is warned about, and the units without a counter
+	// are left alone.
+	var data *CoverageData
+	var err error
+	stderr := captureStderr(t, func() {
+		data, err = readDir(dir, CoverageConfig{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "warning: pod " + countHash + ": pkg 0 func 0 (Add) has 1 counters but 3 units\n"; stderr != want {
+		t.Errorf("warned %q, want %q", stderr, want)
+	}
+	if got, want := unitCounts(findFunc(data, countHash, "example.com/prog/lib", "Add")), []uint32{4, 3, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Add counts %v, want %v", got, want)
+	}
+
+	// In perfunc granularity a single counter covers all units.
+	dir, hash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerFunc,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/fn", testFunc("F", "/src/f.go", []uint32{3, 4, 5}, []uint32{0, 0, 0})),
+		},
+	})
+	writeCounterFile(t, dir, hash, 1, nil, []funcPayload{{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1}}})
+	stderr = captureStderr(t, func() {
+		data, err = readDir(dir, CoverageConfig{})
+	})
+	if err != nil {
+		t.Fatalf("perfunc counter: %v", err)
+	}
+	if stderr != "" {
+		t.Errorf("perfunc counter: warned %q", stderr)
+	}
+	if got, want := unitCounts(findFunc(data, hash, "example.com/test/fn", "F")), []uint32{1, 1, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("perfunc counts %v, want %v", got, want)
+	}
+}
+
+func TestRunsCovered(t *testing.T) {
+	dir, hash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeCount,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/runs",
+				testFunc("A", "a.go", []uint32{3, 4, 5}, []uint32{0, 0, 0}),
+			),
+		},
+	})
+	// The first unit is hit in two of the three runs, five times in
+	// all, the second in one run only.
+	for i, counters := range [][]uint32{{2, 0, 0}, {0, 0, 0}, {3, 7, 0}} {
+		writeCounterFile(t, dir, hash, 101+i, nil, []funcPayload{
+			{PkgIdx: 0, FuncIdx: 0, Counters: counters},
+		})
+	}
+	data, err := ReadDir(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fn := findFunc(data, hash, "example.com/test/runs", "A")
+	if got, want := unitCounts(fn), []uint32{5, 7, 0}; !reflect.DeepEqual(got, want) {
+		t.Errorf("counts %v, want %v", got, want)
+	}
+	var runs []uint32
+	for _, u := range fn.Units {
+		runs = append(runs, u.RunsCovered)
+	}
+	if want := []uint32{2, 1, 0}; !reflect.DeepEqual(runs, want) {
+		t.Errorf("runs covered %v, want %v", runs, want)
+	}
+}
+
+func TestFuncNameRegex(t *testing.T) {
+	dir, hash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/server",
+				testFunc("HandleIndex", "server.go", []uint32{3, 4}, []uint32{1, 1}),
+				testFunc("HandleLogin", "server.go", []uint32{8, 9}, []uint32{1, 0}),
+				testFunc("helper", "server.go", []uint32{13, 14, 15}, []uint32{0, 0, 0}),
+				testFunc("*S.HandleX", "server.go", []uint32{19}, []uint32{0}),
+			),
+		},
+	})
+	c := CoverageConfig{FuncNameRegex: "^Handle"}
+	data, err := readDir(dir, c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, fnIdx := range sortedKeys(data.PodData[hash].Packages[0].Funcs) {
+		names = append(names, data.PodData[hash].Packages[0].Funcs[fnIdx].Name)
+	}
+	if want := []string{"HandleIndex", "HandleLogin"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("read funcs %v, want %v", names, want)
+	}
+	if got := (&Coverage{config: c, Data: data}).GetPercent(); got != 75 {
+		t.Errorf("GetPercent = %v, want 75", got)
+	}
+
+	if _, err := readDir(dir, CoverageConfig{FuncNameRegex: "Handle("}); err == nil || !strings.Contains(err.Error(), "invalid function name regexp") {
+		t.Errorf("readDir with an invalid regexp: error %v", err)
+	}
+}
+
+func TestStrictCounters(t *testing.T) {
+	// Add has three units, but the new counter data file has five
+	// counters for it.
+	dir := copyTestDir(t, countDir)
+	cdf := writeCounterFile(t, dir, countHash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1, 1, 1, 1, 1}},
+	})
+	meta, err := os.ReadFile(filepath.Join(dir, metaFilePref+"."+countHash))
+	if err != nil {
+		t.Fatal(err)
+	}
+	counters, err := os.ReadFile(cdf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const msg = "pod " + countHash + ": pkg 0 func 0 (Add) has 5 counters but 3 units"
+
+	// Without StrictCounters the extra counters are warned about and
+	// dropped.
+	var data *CoverageData
+	stderr := captureStderr(t, func() {
+		data, err = readDir(dir, CoverageConfig{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "warning: " + msg + "\n"; stderr != want {
+		t.Errorf("warned %q, want %q", stderr, want)
+	}
+	if got, want := unitCounts(findFunc(data, countHash, "example.com/prog/lib", "Add")), []uint32{4, 4, 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Add counts %v, want %v", got, want)
+	}
+
+	reads := map[string]func(c CoverageConfig) (*CoverageData, error){
+		"dir": func(c CoverageConfig) (*CoverageData, error) {
+			return readDir(dir, c)
+		},
+		"buffer": func(c CoverageConfig) (*CoverageData, error) {
+			return readFromBuffer(bytes.NewBuffer(meta), bytes.NewBuffer(counters), c)
+		},
+	}
+	for name, read := range reads {
+		if _, err := read(CoverageConfig{StrictCounters: true}); err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s, StrictCounters: error %v, want %q", name, err, msg)
+		}
+		// Packages that aren't read aren't checked.
+		if _, err := read(CoverageConfig{StrictCounters: true, MatchPkgs: []string{".../util"}}); err != nil {
+			t.Errorf("%s, StrictCounters without lib: %v", name, err)
+		}
+	}
+}
+
+func TestExcludeTestFiles(t *testing.T) {
+	dir, hash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeSet,
+		CounterGranularity: CtrGranularityPerBlock,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/p",
+				testFunc("Prod", "/src/p/p.go", []uint32{3, 4, 5, 6}, []uint32{1, 0, 0, 0}),
+				testFunc("helper", "/src/p/p_test.go", []uint32{3, 4}, []uint32{1, 1}),
+			),
+		},
+	})
+	for _, tt := range []struct {
+		exclude bool
+		want    float64
+	}{
+		{false, 50},
+		{true, 25},
+	} {
+		c := CoverageConfig{ExcludeTestFiles: tt.exclude}
+		data, err := readDir(dir, c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := (&Coverage{config: c, Data: data}).GetPercent(); got != tt.want {
+			t.Errorf("ExcludeTestFiles %v: GetPercent = %v, want %v", tt.exclude, got, tt.want)
+		}
+		if fn := findFunc(data, hash, "example.com/test/p", "helper"); (fn != nil) == tt.exclude {
+			t.Errorf("ExcludeTestFiles %v: read test file func %v", tt.exclude, fn != nil)
+		}
+	}
+}
+
+func TestStrictCountersFailFast(t *testing.T) {
+	// The payload of Add is short in the first counter data file, and
+	// the last one is corrupt.
+	dir := copyTestDir(t, countDir)
+	writeCounterFile(t, dir, countHash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{1}},
+	})
+	bad := writeCounterFile(t, dir, countHash, 9, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 1, Counters: []uint32{1, 1, 1}},
+	})
+	b, err := os.ReadFile(bad)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(bad, b[:len(b)-3], 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// Strict reading stops at the short payload, before the corrupt
+	// file is reached and skipped.
+	const msg = "pod " + countHash + ": pkg 0 func 0 (Add) has 1 counters but 3 units"
+	stderr := captureStderr(t, func() {
+		_, err = readDir(dir, CoverageConfig{StrictCounters: true})
+	})
+	if err == nil || !strings.Contains(err.Error(), msg) {
+		t.Errorf("StrictCounters: error %v, want %q", err, msg)
+	}
+	if stderr != "" {
+		t.Errorf("StrictCounters: warned %q, want nothing", stderr)
+	}
+	stderr = captureStderr(t, func() {
+		_, err = readDir(dir, CoverageConfig{})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stderr, "skipping counter data file "+bad) || !strings.Contains(stderr, "warning: "+msg) {
+		t.Errorf("warned %q, want warnings about %s and Add", stderr, bad)
+	}
+
+	// With per-function counters a single counter stands for all the
+	// units of a function.
+	dir, hash := writeTestPod(t, &PodData{
+		CounterMode:        CtrModeCount,
+		CounterGranularity: CtrGranularityPerFunc,
+		Packages: map[uint32]*Package{
+			0: testPackage("example.com/test/p",
+				testFunc("F", "p.go", []uint32{3, 4, 5}, []uint32{1, 1, 1}),
+			),
+		},
+	})
+	writeCounterFile(t, dir, hash, 1, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{2}},
+	})
+	if _, err := readDir(dir, CoverageConfig{StrictCounters: true}); err != nil {
+		t.Errorf("StrictCounters, per-function counter: %v", err)
+	}
+	writeCounterFile(t, dir, hash, 2, nil, []funcPayload{
+		{PkgIdx: 0, FuncIdx: 0, Counters: []uint32{2, 2}},
+	})
+	const perFuncMsg = "func 0 (F) has 2 counters but 3 units"
+	if _, err := readDir(dir, CoverageConfig{StrictCounters: true}); err == nil || !strings.Contains(err.Error(), perFuncMsg) {
+		t.Errorf("StrictCounters, two per-function counters: error %v, want %q", err, perFuncMsg)
+	}
+}
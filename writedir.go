@@ -0,0 +1,76 @@
+package gocov
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// WriteCovDataDir writes the coverage data as a GOCOVERDIR directory,
+// with a meta-data file and a counter data file for every pod, so that
+// tools like "go tool covdata" can read it. Packages and functions are
+// renumbered to account for any that were filtered out, which gives
+// each written pod a meta-data hash of its own.
+func (c *Coverage) WriteCovDataDir(dir string) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, hash := range sortedKeys(c.Data.PodData) {
+		if err := writePod(dir, c.Data.PodData[hash]); err != nil {
+			return fmt.Errorf("writing pod %s: %v", hash, err)
+		}
+	}
+	return nil
+}
+
+// writePod writes the meta-data and counter data files of pod 'p' to
+// 'dir'.
+func writePod(dir string, p *PodData) error {
+	var (
+		blobs    [][]byte
+		hashes   [][16]byte
+		payloads []funcPayload
+	)
+	for _, pkIdx := range sortedKeys(p.Packages) {
+		pack := p.Packages[pkIdx]
+		if len(pack.Funcs) == 0 {
+			// Left without functions by package filtering.
+			continue
+		}
+		newPkIdx := uint32(len(blobs))
+		funcs := make([]*Func, 0, len(pack.Funcs))
+		for _, fnIdx := range sortedKeys(pack.Funcs) {
+			fn := pack.Funcs[fnIdx]
+			counters := make([]uint32, len(fn.Units))
+			live := false
+			for i, u := range fn.Units {
+				counters[i] = u.Count
+				live = live || u.Covered()
+			}
+			// As with the runtime, only functions that were executed
+			// get a payload.
+			if live {
+				payloads = append(payloads, funcPayload{
+					PkgIdx:   newPkIdx,
+					FuncIdx:  uint32(len(funcs)),
+					Counters: counters,
+				})
+			}
+			funcs = append(funcs, fn)
+		}
+		blob, h := encodePackageMeta(pack, funcs)
+		blobs = append(blobs, blob)
+		hashes = append(hashes, h)
+	}
+
+	meta, fileHash := encodeMetaFile(blobs, hashes, p.CounterMode, p.CounterGranularity)
+	tag := hex.EncodeToString(fileHash[:])
+	if err := os.WriteFile(filepath.Join(dir, metaFilePref+"."+tag), meta, 0o644); err != nil {
+		return err
+	}
+	counters := encodeCounterFile(fileHash, p.Provenance, payloads)
+	name := fmt.Sprintf("%s.%s.%d.%d", counterFilePref, tag, os.Getpid(), time.Now().UnixNano())
+	return os.WriteFile(filepath.Join(dir, name), counters, 0o644)
+}
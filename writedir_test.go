@@ -0,0 +1,68 @@
+package gocov
+
+import (
+	"bytes"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestWriteCovDataDir(t *testing.T) {
+	for _, tt := range []struct {
+		dir       string
+		matchPkgs []string
+	}{
+		{setDir, nil},
+		{countDir, nil},
+		{variantDir, nil},
+		{countDir, []string{"example.com/prog/lib"}},
+	} {
+		c := readTestCoverage(t, tt.dir, tt.matchPkgs...)
+		out := t.TempDir()
+		if err := c.WriteCovDataDir(out); err != nil {
+			t.Fatalf("%s %v: %v", tt.dir, tt.matchPkgs, err)
+		}
+		got := readTestCoverage(t, out)
+		if g, w := got.GetPercent(), c.GetPercent(); g != w {
+			t.Errorf("%s %v: re-read percentage %v, want %v", tt.dir, tt.matchPkgs, g, w)
+		}
+		if g, w := got.FilePercents(), c.FilePercents(); !reflect.DeepEqual(g, w) {
+			t.Errorf("%s %v: re-read file percentages %v, want %v", tt.dir, tt.matchPkgs, g, w)
+		}
+		var g, w bytes.Buffer
+		if err := got.WriteTextProfile(&g); err != nil {
+			t.Fatal(err)
+		}
+		if err := c.WriteTextProfile(&w); err != nil {
+			t.Fatal(err)
+		}
+		if g.String() != w.String() {
+			t.Errorf("%s %v: re-read profile\n%s\nwant\n%s", tt.dir, tt.matchPkgs, &g, &w)
+		}
+	}
+}
+
+func TestWriteCovDataDirGoTool(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping go tool covdata in short mode")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go command not available")
+	}
+	out := t.TempDir()
+	if err := readTestCoverage(t, countDir, "example.com/prog/lib").WriteCovDataDir(out); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := exec.Command("go", "tool", "covdata", "percent", "-i="+out).CombinedOutput()
+	if err != nil {
+		t.Fatalf("go tool covdata percent: %v\n%s", err, stdout)
+	}
+	// 5 of the 9 statements of lib are covered.
+	if want := "example.com/prog/lib\t\tcoverage: 55.6% of statements"; !strings.Contains(string(stdout), want) {
+		t.Errorf("go tool covdata percent printed %q, want it to contain %q", stdout, want)
+	}
+	if strings.Contains(string(stdout), "example.com/prog/util") {
+		t.Errorf("go tool covdata percent printed %q, with filtered out package", stdout)
+	}
+}